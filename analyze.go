@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/internal/node"
+)
+
+// tinystore ring-analyze: report key distribution and movement for a ring built from real node ids
+func runRingAnalyze(args []string) error {
+	fs := flag.NewFlagSet("ring-analyze", flag.ExitOnError)
+	config_file := fs.String("config", "configs/nodes.json", "JSON config file with the node ids to place on the ring")
+	vnodes := fs.String("vnodes", "0:100", "virtual node count or inclusive range min:max")
+	step := fs.Int("step", 10, "vnode step when a range is given")
+	keys_file := fs.String("keys-file", "", "file with one sample key per line, overrides the generator")
+	num_keys := fs.Int("keys", 10000, "number of random keys to generate")
+	seed := fs.Int64("seed", 1, "seed for the key generator")
+	hasher := fs.String("hasher", ch.DEFAULT_HASHER, fmt.Sprintf("hasher: %s", strings.Join(ch.HasherNames(), ", ")))
+	format := fs.String("format", "json", "output format: json or csv")
+	output := fs.String("out", "", "output file, defaults to stdout")
+	fs.Parse(args)
+
+	minVirtual, maxVirtual, err := parseVnodeRange(*vnodes)
+	if err != nil {
+		return err
+	}
+
+	nodesInfo := node.LoadNodesConfig(*config_file)
+	var nodeIds []string
+	for id := range nodesInfo.Nodes {
+		nodeIds = append(nodeIds, id)
+	}
+	sort.Strings(nodeIds)
+
+	var keys []string
+	if *keys_file != "" {
+		keys, err = readKeys(*keys_file)
+		if err != nil {
+			return err
+		}
+	} else {
+		keys = generateKeys(*num_keys, *seed)
+	}
+
+	reports, err := ch.Analyze(ch.AnalyzeConfig{
+		NodeIds:    nodeIds,
+		MinVirtual: minVirtual,
+		MaxVirtual: maxVirtual,
+		Step:       *step,
+		Keys:       keys,
+		Hasher:     *hasher,
+	})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %s", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch *format {
+	case "json":
+		return ch.WriteReportsJSON(w, reports)
+	case "csv":
+		return ch.WriteReportsCSV(w, reports)
+	default:
+		return fmt.Errorf("unknown output format: %s", *format)
+	}
+}
+
+func parseVnodeRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vnode count %q", s)
+	}
+	if len(parts) == 1 {
+		return min, min, nil
+	}
+	max, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vnode range %q", s)
+	}
+	return min, max, nil
+}
+
+func readKeys(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening keys file: %s", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading keys file: %s", err)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("keys file is empty")
+	}
+	return keys, nil
+}
+
+func generateKeys(n int, seed int64) []string {
+	rng := rand.New(rand.NewSource(seed))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(rng.Int())
+	}
+	return keys
+}
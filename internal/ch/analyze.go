@@ -0,0 +1,200 @@
+package ch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const PROBE_NODE = "ring-analyze-probe"
+
+// Options for a ring distribution analysis
+type AnalyzeConfig struct {
+	NodeIds    []string
+	MinVirtual int
+	MaxVirtual int
+	Step       int
+	Keys       []string
+	Hasher     string
+}
+
+// Load and key movement statistics for one vnode count
+type DistributionReport struct {
+	Hasher        string  `json:"hasher"`
+	VirtualNodes  int     `json:"virtualNodes"`
+	Nodes         int     `json:"nodes"`
+	Keys          int     `json:"keys"`
+	MeanLoad      float64 `json:"meanLoad"`
+	StdDev        float64 `json:"stdDev"`
+	MinLoad       int     `json:"minLoad"`
+	MaxLoad       int     `json:"maxLoad"`
+	MaxMinRatio   float64 `json:"maxMinRatio"`
+	MovedOnAdd    float64 `json:"movedOnAdd"`
+	MovedOnRemove float64 `json:"movedOnRemove"`
+}
+
+// Analyze builds a ring for every vnode count in the configured range and
+// measures how the sample keys spread over the nodes and how many of them
+// change owner when a node joins or leaves.
+func Analyze(cfg AnalyzeConfig) ([]DistributionReport, error) {
+	if len(cfg.NodeIds) == 0 {
+		return nil, errors.New("no nodes to analyze")
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no keys to analyze")
+	}
+	if cfg.MinVirtual < 0 || cfg.MaxVirtual < cfg.MinVirtual {
+		return nil, fmt.Errorf("invalid vnode range %d:%d", cfg.MinVirtual, cfg.MaxVirtual)
+	}
+	hasher, err := GetHasher(cfg.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	hasherName := cfg.Hasher
+	if hasherName == "" {
+		hasherName = DEFAULT_HASHER
+	}
+	step := cfg.Step
+	if step <= 0 {
+		step = 1
+	}
+
+	nodeIds := append([]string{}, cfg.NodeIds...)
+	sort.Strings(nodeIds)
+
+	var reports []DistributionReport
+	for virtual := cfg.MinVirtual; virtual <= cfg.MaxVirtual; virtual += step {
+		r := InitRingWithHasher(virtual, hasher)
+		for _, id := range nodeIds {
+			r.Add(id, "localhost", 8080, 5005)
+		}
+		owners := r.owners(cfg.Keys)
+
+		report := loadStatistics(owners, nodeIds)
+		report.Hasher = hasherName
+		report.VirtualNodes = virtual
+		report.Keys = len(cfg.Keys)
+
+		r.Add(PROBE_NODE, "localhost", 8080, 5005)
+		report.MovedOnAdd = movedFraction(owners, r.owners(cfg.Keys))
+		r.Remove(PROBE_NODE)
+
+		if len(nodeIds) > 1 {
+			r.Remove(nodeIds[0])
+			report.MovedOnRemove = movedFraction(owners, r.owners(cfg.Keys))
+		}
+
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// owners maps every key to the physical node that owns it
+func (r *Ring) owners(keys []string) []string {
+	owners := make([]string, len(keys))
+	for i, key := range keys {
+		id := r.Get(key)
+		r.RLock()
+		owners[i] = r.physicalId(id)
+		r.RUnlock()
+	}
+	return owners
+}
+
+func loadStatistics(owners []string, nodeIds []string) DistributionReport {
+	load := make(map[string]int, len(nodeIds))
+	for _, id := range nodeIds {
+		load[id] = 0
+	}
+	for _, owner := range owners {
+		load[owner]++
+	}
+
+	mean := float64(len(owners)) / float64(len(nodeIds))
+	min, max := math.MaxInt, 0
+	variance := 0.0
+	for _, count := range load {
+		variance += (float64(count) - mean) * (float64(count) - mean)
+		if count < min {
+			min = count
+		}
+		if count > max {
+			max = count
+		}
+	}
+	variance /= float64(len(nodeIds))
+
+	ratio := math.Inf(1)
+	if min > 0 {
+		ratio = float64(max) / float64(min)
+	}
+
+	return DistributionReport{
+		Nodes:       len(nodeIds),
+		MeanLoad:    mean,
+		StdDev:      math.Sqrt(variance),
+		MinLoad:     min,
+		MaxLoad:     max,
+		MaxMinRatio: ratio,
+	}
+}
+
+func movedFraction(before []string, after []string) float64 {
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(before))
+}
+
+// WriteReportsJSON writes the reports as an indented JSON array
+func WriteReportsJSON(w io.Writer, reports []DistributionReport) error {
+	// JSON has no representation for +Inf, so an empty node is reported as -1
+	out := make([]DistributionReport, len(reports))
+	for i, report := range reports {
+		if math.IsInf(report.MaxMinRatio, 1) {
+			report.MaxMinRatio = -1
+		}
+		out[i] = report
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// WriteReportsCSV writes the reports as CSV with a header row
+func WriteReportsCSV(w io.Writer, reports []DistributionReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"hasher", "virtualNodes", "nodes", "keys", "meanLoad", "stdDev",
+		"minLoad", "maxLoad", "maxMinRatio", "movedOnAdd", "movedOnRemove",
+	})
+	for _, report := range reports {
+		writer.Write([]string{
+			report.Hasher,
+			strconv.Itoa(report.VirtualNodes),
+			strconv.Itoa(report.Nodes),
+			strconv.Itoa(report.Keys),
+			formatFloat(report.MeanLoad),
+			formatFloat(report.StdDev),
+			strconv.Itoa(report.MinLoad),
+			strconv.Itoa(report.MaxLoad),
+			formatFloat(report.MaxMinRatio),
+			formatFloat(report.MovedOnAdd),
+			formatFloat(report.MovedOnRemove),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
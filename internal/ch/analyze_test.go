@@ -0,0 +1,80 @@
+package ch
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnalyze(t *testing.T) {
+	nodeIds := []string{"node0", "node1", "node2", "node3"}
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	Convey("Given a vnode range", t, func() {
+		reports, err := Analyze(AnalyzeConfig{NodeIds: nodeIds, MinVirtual: 0, MaxVirtual: 100, Step: 50, Keys: keys})
+		So(err, ShouldBeNil)
+
+		Convey("Then it should report every step", func() {
+			So(len(reports), ShouldEqual, 3)
+			So(reports[0].VirtualNodes, ShouldEqual, 0)
+			So(reports[2].VirtualNodes, ShouldEqual, 100)
+		})
+
+		Convey("Then every key should be accounted for", func() {
+			for _, report := range reports {
+				So(report.Nodes, ShouldEqual, 4)
+				So(report.Keys, ShouldEqual, 2000)
+				So(report.MeanLoad, ShouldEqual, 500)
+				So(report.MinLoad, ShouldBeLessThanOrEqualTo, report.MaxLoad)
+				So(report.MovedOnAdd, ShouldBeBetweenOrEqual, 0, 1)
+				So(report.MovedOnRemove, ShouldBeBetweenOrEqual, 0, 1)
+			}
+		})
+
+		Convey("Then vnodes should even out the load", func() {
+			So(reports[2].StdDev, ShouldBeLessThan, reports[0].StdDev)
+		})
+	})
+
+	Convey("Given a named hasher", t, func() {
+		reports, err := Analyze(AnalyzeConfig{NodeIds: nodeIds, MinVirtual: 10, MaxVirtual: 10, Keys: keys, Hasher: "fnv1a"})
+		So(err, ShouldBeNil)
+		So(reports[0].Hasher, ShouldEqual, "fnv1a")
+	})
+
+	Convey("Given invalid options", t, func() {
+		_, err := Analyze(AnalyzeConfig{NodeIds: nodeIds, Keys: keys, Hasher: "md5"})
+		So(err, ShouldNotBeNil)
+
+		_, err = Analyze(AnalyzeConfig{Keys: keys})
+		So(err, ShouldNotBeNil)
+
+		_, err = Analyze(AnalyzeConfig{NodeIds: nodeIds, MinVirtual: 10, MaxVirtual: 5, Keys: keys})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWriteReports(t *testing.T) {
+	reports := []DistributionReport{{Hasher: DEFAULT_HASHER, VirtualNodes: 10, Nodes: 2, Keys: 4, MeanLoad: 2, MaxMinRatio: 3}}
+
+	Convey("CSV output should have a header and one row per report", t, func() {
+		var b bytes.Buffer
+		So(WriteReportsCSV(&b, reports), ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		So(len(lines), ShouldEqual, 2)
+		So(lines[0], ShouldStartWith, "hasher,virtualNodes")
+		So(lines[1], ShouldStartWith, "default,10,2,4")
+	})
+
+	Convey("JSON output should encode the reports", t, func() {
+		var b bytes.Buffer
+		So(WriteReportsJSON(&b, reports), ShouldBeNil)
+		So(b.String(), ShouldContainSubstring, `"virtualNodes": 10`)
+	})
+}
@@ -4,14 +4,11 @@ import (
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
-	"fmt"
-	"math/rand"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/nathang15/go-tinystore/internal/node"
 )
@@ -20,6 +17,8 @@ type Ring struct {
 	Nodes      node.Nodes
 	Virtual    int
 	VirtualMap map[string]string
+	Hasher     Hasher
+	table      atomic.Pointer[lookupTable]
 	sync.RWMutex
 }
 
@@ -27,27 +26,67 @@ func InitRing(virtual int) *Ring {
 	return &Ring{Nodes: node.Nodes{}, Virtual: virtual, VirtualMap: make(map[string]string)}
 }
 
+// InitRingWithHasher creates a ring that uses hasher for both node and key placement
+func InitRingWithHasher(virtual int, hasher Hasher) *Ring {
+	r := InitRing(virtual)
+	r.Hasher = hasher
+	return r
+}
+
 func (r *Ring) Add(id string, host string, restPort int32, grpcPort int32) {
+	r.AddWithZone(id, host, restPort, grpcPort, "")
+}
+
+// AddWithZone adds a node tagged with its zone/rack so replicas can be spread across zones
+func (r *Ring) AddWithZone(id string, host string, restPort int32, grpcPort int32, zone string) {
+	n := node.InitNode(id, host, restPort, grpcPort)
+	n.Zone = zone
+	r.AddNode(n)
+}
+
+// AddNode adds a node with its zone and weight. A node of weight w gets w
+// times as many virtual nodes as a node of weight 1.
+func (r *Ring) AddNode(n *node.Node) {
 	r.Lock()
 	defer r.Unlock()
 
+	id := n.Id
 	if r.Virtual == 0 {
-		node := node.InitNode(id, host, restPort, grpcPort)
+		// a weight scales virtual nodes, configs weighting nodes without them are rejected on load
+		node := node.InitNode(id, n.Host, n.RestPort, n.GrpcPort)
+		node.Zone = n.Zone
+		node.RestHost = n.RestHost
+		r.setHashId(node)
 		r.Nodes = append(r.Nodes, node)
 	} else {
+		virtual := r.Virtual
+		if n.Weight > 1 {
+			virtual *= int(n.Weight)
+		}
+
 		// Calculate the range for virtual nodes based on the number of virtual nodes
-		virtualNodeRange := 1 << 31 / r.Virtual
+		virtualNodeRange := 1 << 31 / virtual
 
-		for i := 0; i < r.Virtual; i++ {
+		for i := 0; i < virtual; i++ {
 			// Calculate the virtual node ID within the range
 			virtualNodeId := strconv.Itoa(int((hash(id) + uint32(virtualNodeRange*i)) % (1 << 31)))
 			virtualId := id + "-" + virtualNodeId
-			node := node.InitNode(virtualId, host, restPort, grpcPort)
+			node := node.InitNode(virtualId, n.Host, n.RestPort, n.GrpcPort)
+			node.Zone = n.Zone
+			node.RestHost = n.RestHost
+			r.setHashId(node)
 			r.Nodes = append(r.Nodes, node)
 			r.VirtualMap[virtualId] = id // map virtual node to actual node
 		}
 	}
 	sort.Sort(r.Nodes)
+	r.table.Store(r.buildLookupTable())
+}
+
+func (r *Ring) setHashId(n *node.Node) {
+	if r.Hasher != nil {
+		n.HashId = r.Hasher([]byte(n.Id))
+	}
 }
 
 func hash(s string) uint32 {
@@ -72,6 +111,7 @@ func (r *Ring) Remove(id string) error {
 		}
 
 		r.Nodes = append(r.Nodes[:i], r.Nodes[i+1:]...)
+		r.table.Store(r.buildLookupTable())
 
 		return nil
 	} else {
@@ -92,6 +132,7 @@ func (r *Ring) Remove(id string) error {
 	}
 
 	r.Nodes = newNodes
+	r.table.Store(r.buildLookupTable())
 	return nil
 }
 
@@ -118,9 +159,68 @@ func (r *Ring) Get(id string) string {
 	}
 }
 
+// GetReplicas returns up to n distinct physical nodes for a key. The first is
+// the primary owner, the rest are picked clockwise from it preferring zones
+// that do not hold a copy yet, then falling back to ring order.
+func (r *Ring) GetReplicas(key string, n int) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.Nodes) == 0 {
+		panic("Empty ring")
+	}
+
+	var start int
+	if r.Virtual == 0 {
+		start = r.search(key)
+	} else {
+		start = r.searchNode(key)
+	}
+	if start >= r.Nodes.Len() {
+		start = 0
+	}
+	return r.replicasFrom(start, n)
+}
+
+// replicasFrom walks the ring from entry start, caller holds the lock
+func (r *Ring) replicasFrom(start int, n int) []string {
+	var replicas []string
+	chosen := make(map[string]bool)
+	zones := make(map[string]bool)
+
+	// first pass only takes nodes from unused zones, second pass fills up the rest
+	for pass := 0; pass < 2 && len(replicas) < n; pass++ {
+		for i := 0; i < r.Nodes.Len() && len(replicas) < n; i++ {
+			candidate := r.Nodes[(start+i)%r.Nodes.Len()]
+			id := r.physicalId(candidate.Id)
+			if chosen[id] {
+				continue
+			}
+			if pass == 0 && len(replicas) > 0 && zones[candidate.Zone] {
+				continue
+			}
+			chosen[id] = true
+			zones[candidate.Zone] = true
+			replicas = append(replicas, id)
+		}
+	}
+	return replicas
+}
+
+func (r *Ring) physicalId(id string) string {
+	if physical, ok := r.VirtualMap[id]; ok {
+		return physical
+	}
+	return id
+}
+
 func (r *Ring) search(id string) int {
+	hash := node.GetHashId(id)
+	if r.Hasher != nil {
+		hash = r.Hasher([]byte(id))
+	}
 	searchfn := func(i int) bool {
-		return r.Nodes[i].HashId >= node.GetHashId(id)
+		return r.Nodes[i].HashId >= hash
 	}
 
 	return sort.Search(r.Nodes.Len(), searchfn)
@@ -128,6 +228,9 @@ func (r *Ring) search(id string) int {
 
 func (r *Ring) searchNode(id string) int {
 	hash := getHash(id)
+	if r.Hasher != nil {
+		hash = r.Hasher([]byte(id))
+	}
 	search := func(i int) bool {
 		return r.Nodes[i].HashId >= hash
 	}
@@ -142,32 +245,6 @@ func getHash(id string) uint32 {
 	return binary.BigEndian.Uint32(bs[:4])
 }
 
-func PrintBucketDistributionStats(filename string, buckets []string, members []string, maxVirtualNodes int) {
-	file, err := os.Create(filename)
-	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return
-	}
-	defer file.Close()
-
-	for virtualNodes := 1; virtualNodes <= maxVirtualNodes; virtualNodes++ {
-		r := InitRing(virtualNodes)
-
-		for _, bucket := range buckets {
-			r.Add(bucket, "localhost", 8080, 5005)
-		}
-
-		statistics := r.GetDistributionStatistics(members)
-
-		fmt.Fprintf(file, "No of virtual nodes : %d\n", virtualNodes)
-		fmt.Fprintf(file, "%5s%10s\n", "%", "BucketId")
-		for bucket, percent := range statistics {
-			fmt.Fprintf(file, "%5.2f %s\n", percent, bucket)
-		}
-		fmt.Fprintln(file)
-	}
-}
-
 func (r *Ring) GetDistributionStatistics(members []string) map[string]float64 {
 	r.RLock()
 	defer r.RUnlock()
@@ -187,17 +264,3 @@ func (r *Ring) GetDistributionStatistics(members []string) map[string]float64 {
 
 	return statistics
 }
-
-func PrintBucketDistribution() {
-	rand.New(rand.NewSource(time.Now().UnixNano()))
-	buckets := make([]string, 10)
-	for i := 0; i < 10; i++ {
-		buckets[i] = strconv.Itoa(rand.Intn(1 << 31 / 5))
-	}
-	members := make([]string, 10000)
-	for i := 0; i < 10000; i++ {
-		members[i] = strconv.Itoa(rand.Int())
-	}
-	maxVirtualNodes := 800
-	PrintBucketDistributionStats("output.txt", buckets, members, maxVirtualNodes)
-}
@@ -289,3 +289,64 @@ func TestEdgeCases(t *testing.T) {
 		})
 	})
 }
+
+func TestGetReplicas(t *testing.T) {
+	Convey("Given a ring with nodes across three zones", t, func() {
+		r := InitRing(10)
+		zones := []string{"zone-a", "zone-b", "zone-c"}
+		for i := 0; i < 6; i++ {
+			r.AddWithZone(fmt.Sprintf("node%d", i), "localhost", int32(8080+i), int32(5005+i), zones[i%3])
+		}
+		zoneOf := func(id string) string {
+			var i int
+			fmt.Sscanf(id, "node%d", &i)
+			return zones[i%3]
+		}
+
+		Convey("Then the first replica should be the primary owner", func() {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%d", i)
+				So(r.GetReplicas(key, 3)[0], ShouldEqual, r.VirtualMap[r.Get(key)])
+			}
+		})
+
+		Convey("Then replicas should land in distinct zones", func() {
+			for i := 0; i < 100; i++ {
+				replicas := r.GetReplicas(fmt.Sprintf("key%d", i), 3)
+				So(len(replicas), ShouldEqual, 3)
+				seen := make(map[string]bool)
+				for _, id := range replicas {
+					So(seen[zoneOf(id)], ShouldBeFalse)
+					seen[zoneOf(id)] = true
+				}
+			}
+		})
+
+		Convey("Then asking for more replicas than zones should still return distinct nodes", func() {
+			replicas := r.GetReplicas("key", 5)
+			So(len(replicas), ShouldEqual, 5)
+			seen := make(map[string]bool)
+			for _, id := range replicas {
+				So(seen[id], ShouldBeFalse)
+				seen[id] = true
+			}
+		})
+
+		Convey("Then asking for more replicas than nodes should return every node once", func() {
+			So(len(r.GetReplicas("key", 10)), ShouldEqual, 6)
+		})
+	})
+
+	Convey("Given a ring without zones", t, func() {
+		r := InitRing(0)
+		r.Add("node0", "localhost", 8080, 5005)
+		r.Add("node1", "localhost", 8081, 5006)
+		r.Add("node2", "localhost", 8082, 5007)
+
+		Convey("Then replicas should follow ring order", func() {
+			replicas := r.GetReplicas("random_key", 3)
+			So(replicas[0], ShouldEqual, r.Get("random_key"))
+			So(len(replicas), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,57 @@
+package ch
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+)
+
+const DEFAULT_HASHER = "default"
+
+// Hasher maps a key or node id onto the ring
+type Hasher func(key []byte) uint32
+
+var hashers = map[string]Hasher{
+	"crc32": crc32.ChecksumIEEE,
+	"sha1":  sha1Hash,
+	"fnv1a": fnv1aHash,
+}
+
+// GetHasher looks up a hasher by name. The default hasher is nil, which keeps
+// the ring's original crc32 node / sha1 key hashing.
+func GetHasher(name string) (Hasher, error) {
+	if name == "" || name == DEFAULT_HASHER {
+		return nil, nil
+	}
+	h, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hasher: %s", name)
+	}
+	return h, nil
+}
+
+// HasherNames returns every hasher name accepted by GetHasher
+func HasherNames() []string {
+	names := []string{DEFAULT_HASHER}
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func sha1Hash(key []byte) uint32 {
+	h := sha1.New()
+	h.Write(key)
+	bs := h.Sum(nil)
+	return binary.BigEndian.Uint32(bs[:4])
+}
+
+func fnv1aHash(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
@@ -0,0 +1,103 @@
+package ch
+
+import (
+	"errors"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+)
+
+// Number of hash bits used to index the lookup table buckets
+const LOOKUP_BITS = 16
+
+var ErrEmptyRing = errors.New("ring has no nodes")
+
+// Immutable snapshot of the ring used for lock-free lookups. A new table is
+// built on every membership change and swapped in atomically, so readers
+// never take the ring lock.
+type lookupTable struct {
+	hashes []uint32
+	ids    []string
+	// physical node id of every entry
+	owners []string
+	// buckets[b] is the index of the first node whose hash has top bits >= b
+	buckets []uint32
+	keyHash func(key string) uint32
+}
+
+func (r *Ring) buildLookupTable() *lookupTable {
+	t := &lookupTable{
+		hashes:  make([]uint32, len(r.Nodes)),
+		ids:     make([]string, len(r.Nodes)),
+		owners:  make([]string, len(r.Nodes)),
+		buckets: make([]uint32, 1<<LOOKUP_BITS+1),
+	}
+	for i, n := range r.Nodes {
+		t.hashes[i] = n.HashId
+		t.ids[i] = n.Id
+		t.owners[i] = r.physicalId(n.Id)
+	}
+
+	i := 0
+	for b := range t.buckets {
+		for i < len(t.hashes) && t.hashes[i]>>(32-LOOKUP_BITS) < uint32(b) {
+			i++
+		}
+		t.buckets[b] = uint32(i)
+	}
+
+	hasher := r.Hasher
+	switch {
+	case hasher != nil:
+		t.keyHash = func(key string) uint32 { return hasher([]byte(key)) }
+	case r.Virtual == 0:
+		t.keyHash = node.GetHashId
+	default:
+		t.keyHash = getHash
+	}
+	return t
+}
+
+func (t *lookupTable) get(key string) string {
+	return t.ids[t.index(key)]
+}
+
+func (t *lookupTable) index(key string) uint32 {
+	h := t.keyHash(key)
+	b := h >> (32 - LOOKUP_BITS)
+	i, end := t.buckets[b], t.buckets[b+1]
+	for i < end && t.hashes[i] < h {
+		i++
+	}
+	if int(i) >= len(t.ids) {
+		i = 0
+	}
+	return i
+}
+
+// Lookup returns the same node as Get without taking the ring lock, reading
+// from the latest snapshot in O(1) expected time.
+func (r *Ring) Lookup(key string) string {
+	t := r.table.Load()
+	if t == nil || len(t.ids) == 0 {
+		panic("Empty ring")
+	}
+	return t.get(key)
+}
+
+// LookupOwner is like Lookup but returns the physical node instead of the virtual node
+func (r *Ring) LookupOwner(key string) string {
+	t := r.table.Load()
+	if t == nil || len(t.ids) == 0 {
+		panic("Empty ring")
+	}
+	return t.owners[t.index(key)]
+}
+
+// Owner is like LookupOwner but returns ErrEmptyRing instead of panicking
+func (r *Ring) Owner(key string) (string, error) {
+	t := r.table.Load()
+	if t == nil || len(t.ids) == 0 {
+		return "", ErrEmptyRing
+	}
+	return t.owners[t.index(key)], nil
+}
@@ -0,0 +1,107 @@
+package ch
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLookup(t *testing.T) {
+	for _, virtual := range []int{0, 1, 10, 100} {
+		Convey(fmt.Sprintf("Given a ring with %d virtual nodes", virtual), t, func() {
+			r := InitRing(virtual)
+			for i := 0; i < 10; i++ {
+				r.Add(fmt.Sprintf("node%d", i), "localhost", int32(8080+i), int32(5005+i))
+			}
+
+			Convey("Then Lookup should agree with Get", func() {
+				for i := 0; i < 5000; i++ {
+					key := fmt.Sprintf("key%d", i)
+					So(r.Lookup(key), ShouldEqual, r.Get(key))
+				}
+			})
+
+			Convey("Then Lookup should follow membership changes", func() {
+				So(r.Remove("node3"), ShouldBeNil)
+				r.Add("node42", "localhost", 8090, 5015)
+				for i := 0; i < 5000; i++ {
+					key := fmt.Sprintf("key%d", i)
+					So(r.Lookup(key), ShouldEqual, r.Get(key))
+				}
+			})
+		})
+	}
+
+	Convey("Given a ring with a named hasher", t, func() {
+		hasher, _ := GetHasher("fnv1a")
+		r := InitRingWithHasher(10, hasher)
+		for i := 0; i < 10; i++ {
+			r.Add(fmt.Sprintf("node%d", i), "localhost", int32(8080+i), int32(5005+i))
+		}
+
+		Convey("Then Lookup should agree with Get", func() {
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key%d", i)
+				So(r.Lookup(key), ShouldEqual, r.Get(key))
+			}
+		})
+	})
+
+	Convey("Given an empty ring", t, func() {
+		r := InitRing(10)
+		So(func() { r.Lookup("key") }, ShouldPanic)
+	})
+}
+
+func benchmarkRing(virtual int) (*Ring, []string) {
+	r := InitRing(virtual)
+	for i := 0; i < 50; i++ {
+		r.Add(fmt.Sprintf("node%d", i), "localhost", int32(8080+i), int32(5005+i))
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	return r, keys
+}
+
+func BenchmarkRingGet(b *testing.B) {
+	r, keys := benchmarkRing(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkRingLookup(b *testing.B) {
+	r, keys := benchmarkRing(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Lookup(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkRingGetParallel(b *testing.B) {
+	r, keys := benchmarkRing(100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			r.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkRingLookupParallel(b *testing.B) {
+	r, keys := benchmarkRing(100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			r.Lookup(keys[i%len(keys)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package ch
+
+import (
+	"fmt"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+// InitRingFromConfig builds the ring described by a cluster config. Every
+// client that receives the same config ends up with an identical ring.
+func InitRingFromConfig(cfg *pb.ClusterConfig) (*Ring, error) {
+	placement := cfg.GetPlacement()
+	if placement == nil {
+		return nil, fmt.Errorf("cluster config has no placement")
+	}
+	switch placement.Algorithm {
+	case node.RING, node.SLOTS:
+	default:
+		return nil, fmt.Errorf("unknown placement algorithm: %s", placement.Algorithm)
+	}
+
+	hasher, err := GetHasher(placement.Hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	r := InitRingWithHasher(int(placement.VirtualNodes), hasher)
+	for _, n := range cfg.Nodes {
+		r.AddNode(node.FromProto(n))
+	}
+	return r, nil
+}
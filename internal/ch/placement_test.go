@@ -0,0 +1,56 @@
+package ch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInitRingFromConfig(t *testing.T) {
+	cfg := &pb.ClusterConfig{
+		Nodes: []*pb.Node{
+			{Id: "node0", Host: "localhost", RestPort: 8080, GrpcPort: 5005, Weight: 1},
+			{Id: "node1", Host: "localhost", RestPort: 8081, GrpcPort: 5006, Weight: 1},
+			{Id: "node2", Host: "localhost", RestPort: 8082, GrpcPort: 5007, Weight: 2},
+		},
+		Placement: &pb.Placement{Algorithm: node.RING, VirtualNodes: 50, Hasher: "sha1"},
+	}
+
+	Convey("Given a published cluster config", t, func() {
+		r1, err := InitRingFromConfig(cfg)
+		So(err, ShouldBeNil)
+		r2, err := InitRingFromConfig(cfg)
+		So(err, ShouldBeNil)
+
+		Convey("Then rings built from it should place keys identically", func() {
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key%d", i)
+				So(r1.LookupOwner(key), ShouldEqual, r2.LookupOwner(key))
+			}
+		})
+
+		Convey("Then node weight should scale the virtual nodes", func() {
+			So(r1.Nodes.Len(), ShouldEqual, 200)
+		})
+
+		Convey("Then owners should be physical node ids", func() {
+			for i := 0; i < 100; i++ {
+				So([]string{"node0", "node1", "node2"}, ShouldContain, r1.LookupOwner(fmt.Sprintf("key%d", i)))
+			}
+		})
+	})
+
+	Convey("Given an invalid placement", t, func() {
+		_, err := InitRingFromConfig(&pb.ClusterConfig{Placement: &pb.Placement{Algorithm: "random"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = InitRingFromConfig(&pb.ClusterConfig{Placement: &pb.Placement{Algorithm: node.RING, Hasher: "md5"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = InitRingFromConfig(&pb.ClusterConfig{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,58 @@
+package ch
+
+import "github.com/nathang15/go-tinystore/internal/node"
+
+// Interval (Start, End] of the key hash space owned by one ring entry. When
+// Start equals End the range covers the whole ring.
+type TokenRange struct {
+	Start    uint32
+	End      uint32
+	Replicas []string
+}
+
+// Contains reports whether a key hash falls into the range
+func (t TokenRange) Contains(h uint32) bool {
+	return uint64(h-t.Start-1) < t.Width()
+}
+
+// Width returns the number of hashes in the range
+func (t TokenRange) Width() uint64 {
+	if t.Start == t.End {
+		return 1 << 32
+	}
+	return uint64(t.End - t.Start)
+}
+
+// Offset returns the position of a hash within the range
+func (t TokenRange) Offset(h uint32) uint64 {
+	return uint64(h - t.Start - 1)
+}
+
+// TokenRanges splits the ring into the ranges between consecutive entries,
+// each with the n replicas that hold the keys in it
+func (r *Ring) TokenRanges(n int) []TokenRange {
+	r.RLock()
+	defer r.RUnlock()
+
+	var ranges []TokenRange
+	for i, entry := range r.Nodes {
+		prev := r.Nodes[(i+r.Nodes.Len()-1)%r.Nodes.Len()]
+		if prev.HashId == entry.HashId && r.Nodes.Len() > 1 {
+			continue
+		}
+		ranges = append(ranges, TokenRange{Start: prev.HashId, End: entry.HashId, Replicas: r.replicasFrom(i, n)})
+	}
+	return ranges
+}
+
+// KeyHash returns the position of a key on the ring
+func (r *Ring) KeyHash(key string) uint32 {
+	switch {
+	case r.Hasher != nil:
+		return r.Hasher([]byte(key))
+	case r.Virtual == 0:
+		return node.GetHashId(key)
+	default:
+		return getHash(key)
+	}
+}
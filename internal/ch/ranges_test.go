@@ -0,0 +1,52 @@
+package ch
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenRanges(t *testing.T) {
+	for _, virtual := range []int{0, 10} {
+		Convey(fmt.Sprintf("Given a ring with %d virtual nodes", virtual), t, func() {
+			r := InitRing(virtual)
+			for i := 0; i < 5; i++ {
+				r.Add(fmt.Sprintf("node%d", i), "localhost", int32(8080+i), int32(5005+i))
+			}
+			ranges := r.TokenRanges(3)
+
+			Convey("Then every key should fall into exactly one range with the key's replicas", func() {
+				for i := 0; i < 1000; i++ {
+					key := fmt.Sprintf("key%d", i)
+					h := r.KeyHash(key)
+					var matches []TokenRange
+					for _, tr := range ranges {
+						if tr.Contains(h) {
+							matches = append(matches, tr)
+						}
+					}
+					So(len(matches), ShouldEqual, 1)
+					So(matches[0].Replicas, ShouldResemble, r.GetReplicas(key, 3))
+				}
+			})
+
+			Convey("Then the ranges should cover the whole ring", func() {
+				var total uint64
+				for _, tr := range ranges {
+					total += tr.Width()
+				}
+				So(total, ShouldEqual, uint64(1)<<32)
+			})
+		})
+	}
+
+	Convey("Given a ring with a single node", t, func() {
+		r := InitRing(0)
+		r.Add("node0", "localhost", 8080, 5005)
+		ranges := r.TokenRanges(1)
+		So(len(ranges), ShouldEqual, 1)
+		So(ranges[0].Width(), ShouldEqual, uint64(1)<<32)
+		So(ranges[0].Contains(r.KeyHash("anykey")), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,140 @@
+package ch
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+const DEFAULT_SLOTS = 16384
+
+// Fixed-partition placement: the keyspace is split into a fixed number of
+// slots and every slot is explicitly assigned to a node by the leader
+type SlotTable struct {
+	Version int64
+	// node id owning each slot
+	Slots []string
+}
+
+// HashSlot maps a key to a slot with CRC16 like Redis Cluster. If the key has
+// a non-empty hash tag such as "{user1}.name", only the tag is hashed so
+// related keys share a slot.
+func HashSlot(key string, numSlots int) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16([]byte(key))) % numSlots
+}
+
+// crc16 implements CRC16-CCITT (XMODEM)
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// AssignSlots spreads numSlots evenly over nodeIds. Slots whose owner in prev
+// is still a member stay put as long as that node is under its share, so a
+// membership change only moves the slots it has to.
+func AssignSlots(prev *SlotTable, nodeIds []string, numSlots int) *SlotTable {
+	table := &SlotTable{Version: 1, Slots: make([]string, numSlots)}
+	if prev != nil {
+		table.Version = prev.Version + 1
+	}
+	if len(nodeIds) == 0 {
+		return table
+	}
+
+	ids := append([]string{}, nodeIds...)
+	sort.Strings(ids)
+
+	quota := make(map[string]int, len(ids))
+	for i, id := range ids {
+		quota[id] = numSlots / len(ids)
+		if i < numSlots%len(ids) {
+			quota[id]++
+		}
+	}
+
+	count := make(map[string]int, len(ids))
+	if prev != nil && len(prev.Slots) == numSlots {
+		for slot, owner := range prev.Slots {
+			if q, ok := quota[owner]; ok && count[owner] < q {
+				table.Slots[slot] = owner
+				count[owner]++
+			}
+		}
+	}
+
+	next := 0
+	for slot := range table.Slots {
+		if table.Slots[slot] != "" {
+			continue
+		}
+		for count[ids[next]] >= quota[ids[next]] {
+			next++
+		}
+		table.Slots[slot] = ids[next]
+		count[ids[next]]++
+	}
+	return table
+}
+
+// Get returns the node owning the slot of a key
+func (t *SlotTable) Get(key string) string {
+	return t.Slots[HashSlot(key, len(t.Slots))]
+}
+
+// Owners returns the distinct node ids that own at least one slot
+func (t *SlotTable) Owners() []string {
+	seen := make(map[string]bool)
+	var owners []string
+	for _, owner := range t.Slots {
+		if !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// Convert slot table to protobuf, compressing consecutive slots into ranges
+func (t *SlotTable) ToProto() *pb.SlotTable {
+	res := &pb.SlotTable{Version: t.Version, NumSlots: int32(len(t.Slots))}
+	for start := 0; start < len(t.Slots); {
+		end := start
+		for end+1 < len(t.Slots) && t.Slots[end+1] == t.Slots[start] {
+			end++
+		}
+		res.Ranges = append(res.Ranges, &pb.SlotRange{Start: int32(start), End: int32(end), NodeId: t.Slots[start]})
+		start = end + 1
+	}
+	return res
+}
+
+// Convert protobuf slot table, returns nil if the config carries none
+func SlotTableFromProto(t *pb.SlotTable) *SlotTable {
+	if t == nil || t.NumSlots == 0 {
+		return nil
+	}
+	table := &SlotTable{Version: t.Version, Slots: make([]string, t.NumSlots)}
+	for _, r := range t.Ranges {
+		for slot := r.Start; slot <= r.End && int(slot) < len(table.Slots); slot++ {
+			table.Slots[slot] = r.NodeId
+		}
+	}
+	return table
+}
@@ -0,0 +1,99 @@
+package ch
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHashSlot(t *testing.T) {
+	Convey("Given the Redis Cluster slot count", t, func() {
+		Convey("Then it should match Redis key slots", func() {
+			So(HashSlot("123456789", DEFAULT_SLOTS), ShouldEqual, 12739)
+			So(HashSlot("foo", DEFAULT_SLOTS), ShouldEqual, 12182)
+		})
+
+		Convey("Then keys with the same hash tag should share a slot", func() {
+			So(HashSlot("{user1000}.following", DEFAULT_SLOTS), ShouldEqual, HashSlot("{user1000}.followers", DEFAULT_SLOTS))
+			So(HashSlot("{user1000}.following", DEFAULT_SLOTS), ShouldEqual, HashSlot("user1000", DEFAULT_SLOTS))
+		})
+
+		Convey("Then an empty hash tag should hash the whole key", func() {
+			So(HashSlot("foo{}{bar}", DEFAULT_SLOTS), ShouldNotEqual, HashSlot("bar", DEFAULT_SLOTS))
+		})
+	})
+
+	Convey("Given a small slot count", t, func() {
+		for i := 0; i < 1000; i++ {
+			So(HashSlot(fmt.Sprintf("key%d", i), 271), ShouldBeBetweenOrEqual, 0, 270)
+		}
+	})
+}
+
+func TestAssignSlots(t *testing.T) {
+	Convey("Given three nodes", t, func() {
+		table := AssignSlots(nil, []string{"node2", "node0", "node1"}, DEFAULT_SLOTS)
+
+		Convey("Then every slot should be owned and the load balanced", func() {
+			count := make(map[string]int)
+			for _, owner := range table.Slots {
+				So(owner, ShouldNotBeEmpty)
+				count[owner]++
+			}
+			So(len(count), ShouldEqual, 3)
+			for _, c := range count {
+				So(c, ShouldBeBetweenOrEqual, DEFAULT_SLOTS/3, DEFAULT_SLOTS/3+1)
+			}
+			So(table.Version, ShouldEqual, 1)
+			So(table.Owners(), ShouldResemble, []string{"node0", "node1", "node2"})
+		})
+
+		Convey("When a node joins", func() {
+			next := AssignSlots(table, []string{"node0", "node1", "node2", "node3"}, DEFAULT_SLOTS)
+
+			Convey("Then the version should increase", func() {
+				So(next.Version, ShouldEqual, 2)
+			})
+
+			Convey("Then only the new node's share should move", func() {
+				moved := 0
+				for slot := range next.Slots {
+					if next.Slots[slot] != table.Slots[slot] {
+						moved++
+						So(next.Slots[slot], ShouldEqual, "node3")
+					}
+				}
+				So(moved, ShouldEqual, DEFAULT_SLOTS/4)
+			})
+		})
+
+		Convey("When a node leaves", func() {
+			next := AssignSlots(table, []string{"node0", "node2"}, DEFAULT_SLOTS)
+
+			Convey("Then only the departed node's slots should move", func() {
+				for slot := range next.Slots {
+					if table.Slots[slot] != "node1" {
+						So(next.Slots[slot], ShouldEqual, table.Slots[slot])
+					}
+					So(next.Slots[slot], ShouldNotEqual, "node1")
+				}
+			})
+		})
+
+		Convey("Then it should survive a protobuf round trip", func() {
+			decoded := SlotTableFromProto(table.ToProto())
+			So(decoded.Version, ShouldEqual, table.Version)
+			So(decoded.Slots, ShouldResemble, table.Slots)
+			So(len(table.ToProto().Ranges), ShouldEqual, 3)
+		})
+
+		Convey("Then keys should route to their slot owner", func() {
+			So(table.Get("foo"), ShouldEqual, table.Slots[12182])
+		})
+	})
+
+	Convey("Given no slot table in the config", t, func() {
+		So(SlotTableFromProto(nil), ShouldBeNil)
+	})
+}
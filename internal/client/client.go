@@ -10,24 +10,56 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/internal/discovery"
+	"github.com/nathang15/go-tinystore/internal/membership"
 	"github.com/nathang15/go-tinystore/internal/node"
 	"github.com/nathang15/go-tinystore/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Backoff between attempts to watch the cluster config while no node answers
+	WATCH_MIN_BACKOFF = 100 * time.Millisecond
+	WATCH_MAX_BACKOFF = 10 * time.Second
 )
 
 type Client struct {
-	Info    node.NodesInfo
-	Ring    *ch.Ring
-	vNode   int
+	Members *membership.Membership
 	CertDir string
+	// Zone of the client, reads prefer a replica in the same zone
+	Zone string
+	// Routing state of the latest cluster config, swapped in as a whole
+	routing atomic.Pointer[routing]
+	// Slot table when the cluster uses fixed-partition placement
+	slotTable atomic.Pointer[ch.SlotTable]
+}
+
+// Routing state built from one cluster config. It is never modified once
+// published, so requests never see a ring and placement from different configs.
+type routing struct {
+	ring      *ch.Ring
+	placement *pb.Placement
+	// Number of copies of each key in the cluster
+	replicas int
+	// Epoch and leader term of the cluster config
+	epoch      int64
+	leaderTerm int64
 }
 
 type Payload struct {
@@ -35,43 +67,57 @@ type Payload struct {
 	Value string `json:"value"`
 }
 
-func InitClient(cert string, configFile string, virtualNodes int) *Client {
-	initNodesConfig := node.LoadNodesConfig(configFile)
-	ring := ch.InitRing(virtualNodes)
-	var clusterConfig []*pb.Node
+// InitClient fetches the cluster config from the nodes in configFile and builds
+// its ring from the placement published by the cluster. virtualNodes is only
+// used when the cluster does not publish placement parameters.
+func InitClient(cert string, configFile string, virtualNodes int) (*Client, error) {
+	return InitClientWithDiscovery(cert, discovery.FromConfig(node.LoadNodesConfig(configFile)), virtualNodes)
+}
 
-	for _, node := range initNodesConfig.Nodes {
-		c, err := InitCacheClient(cert, node.Host, int(node.GrpcPort))
+// InitClientWithDiscovery bootstraps a client from the first discovered seed
+// that serves the cluster config. Fails when no seed names any member.
+func InitClientWithDiscovery(cert string, d discovery.Discovery, virtualNodes int) (*Client, error) {
+	clusterConfig := &pb.ClusterConfig{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	seeds, err := d.Seeds(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error discovering seeds: %v", err)
+	}
+	for _, seed := range seeds {
+		c, err := InitCacheClient(cert, seed.Host, int(seed.Port))
 		if err != nil {
 			log.Printf("error: %v", err)
 			continue
 		}
-		node.SetGrpcClient(c)
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-
 		res, err := c.GetClusterConfig(ctx, &pb.ClusterConfigRequest{CallerNodeId: "client"})
+		cancel()
 		if err != nil {
-			log.Printf("error getting cluster config from node %s: %v", node.Id, err)
+			log.Printf("error getting cluster config from seed %s: %v", seed.Addr(), err)
 			continue
 		}
-		clusterConfig = res.Nodes
+		clusterConfig = res
 		break
 	}
 
+	if len(clusterConfig.Nodes) == 0 {
+		return nil, fmt.Errorf("none of %d seeds served a cluster config with members", len(seeds))
+	}
+	if clusterConfig.Placement == nil {
+		clusterConfig.Placement = &pb.Placement{Algorithm: node.RING, VirtualNodes: int32(virtualNodes)}
+	}
+	r, err := newRouting(clusterConfig, clusterConfig.Placement)
+	if err != nil {
+		return nil, fmt.Errorf("error building ring from cluster config: %v", err)
+	}
+
 	infoMap := make(map[string]*node.Node)
-	for _, n := range clusterConfig {
-		infoMap[n.Id] = node.InitNode(n.Id, n.Host, n.RestPort, n.GrpcPort)
-
-		if virtualNodes == 0 {
-			ring.Add(n.Id, n.Host, n.RestPort, n.GrpcPort)
-		} else {
-			for i := 0; i < virtualNodes; i++ {
-				virtualNodeID := fmt.Sprintf("%s-%d", n.Id, i)
-				ring.Add(virtualNodeID, n.Host, n.RestPort, n.GrpcPort)
-			}
-		}
+	for _, n := range clusterConfig.Nodes {
+		infoMap[n.Id] = node.FromProto(n)
+
 		c, err := InitCacheClient(cert, n.Host, int(n.GrpcPort))
 		if err != nil {
 			log.Printf("error: %v", err)
@@ -80,15 +126,102 @@ func InitClient(cert string, configFile string, virtualNodes int) *Client {
 		infoMap[n.Id].SetGrpcClient(c)
 	}
 	info := node.NodesInfo{Nodes: infoMap}
-	return &Client{Info: info, Ring: ring, vNode: virtualNodes, CertDir: cert}
+	info.SetPlacement(clusterConfig.Placement)
+	client := &Client{
+		Members: membership.New(info),
+		CertDir: cert,
+	}
+	client.routing.Store(r)
+	client.setSlotTable(clusterConfig.SlotTable)
+	return client, nil
+}
+
+// newRouting builds the routing state of a cluster config with placement
+func newRouting(cfg *pb.ClusterConfig, placement *pb.Placement) (*routing, error) {
+	ring, err := ch.InitRingFromConfig(&pb.ClusterConfig{Nodes: cfg.Nodes, Placement: placement})
+	if err != nil {
+		return nil, err
+	}
+	return &routing{
+		ring:       ring,
+		placement:  placement,
+		replicas:   int(placement.ReplicationFactor),
+		epoch:      cfg.Epoch,
+		leaderTerm: cfg.LeaderTerm,
+	}, nil
+}
+
+// Ring returns the ring of the latest cluster config
+func (c *Client) Ring() *ch.Ring {
+	return c.routing.Load().ring
+}
+
+// Replicas returns the number of copies of each key in the cluster
+func (c *Client) Replicas() int {
+	return c.routing.Load().replicas
+}
+
+// setSlotTable installs a slot table from the cluster config if it is newer than the current one
+func (c *Client) setSlotTable(t *pb.SlotTable) {
+	table := ch.SlotTableFromProto(t)
+	if table == nil {
+		return
+	}
+	if current := c.slotTable.Load(); current != nil && current.Version >= table.Version {
+		return
+	}
+	c.slotTable.Store(table)
+}
+
+// getNodeId returns the physical node owning a key, routing by slot when the
+// cluster uses fixed-partition placement and by the hash ring otherwise
+func (c *Client) getNodeId(key string) (string, error) {
+	if table := c.slotTable.Load(); table != nil {
+		if owner := table.Get(key); owner != "" {
+			if _, ok := c.Members.Get(owner); ok {
+				return owner, nil
+			}
+		}
+	}
+	owner, err := c.Ring().Owner(key)
+	if err != nil {
+		return "", fmt.Errorf("no node found for key %s: %v", key, err)
+	}
+	return owner, nil
+}
+
+// SetZone sets the zone of the client so reads go to a same-zone replica when one exists
+func (c *Client) SetZone(zone string) {
+	c.Zone = zone
+}
+
+// getReadNodeId returns the node to read a key from, preferring a replica in the client's zone
+func (c *Client) getReadNodeId(key string) (string, error) {
+	nodeId, err := c.getNodeId(key)
+	r := c.routing.Load()
+	if err != nil || c.Zone == "" || r.replicas <= 1 {
+		return nodeId, err
+	}
+
+	for _, replicaId := range r.ring.GetReplicas(key, r.replicas) {
+		if replica, ok := c.Members.Get(replicaId); ok && replica.Zone == c.Zone {
+			return replicaId, nil
+		}
+	}
+	return nodeId, nil
 }
 
 func (c *Client) Get(key string) (string, error) {
-	nodeId := c.Ring.Get(key)
-	physicalNodeId := c.getPhysicalNodeId(nodeId)
-	nodeInfo := c.Info.Nodes[physicalNodeId]
+	nodeId, err := c.getReadNodeId(key)
+	if err != nil {
+		return "", err
+	}
+	nodeInfo, ok := c.Members.Get(nodeId)
+	if !ok {
+		return "", fmt.Errorf("no node information for node ID: %s", nodeId)
+	}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s:%d/get", nodeInfo.Host, nodeInfo.RestPort))
+	resp, err := http.Get(fmt.Sprintf("http://%s/get/%s", nodeInfo.RestAddr(), url.PathEscape(key)))
 	if err != nil {
 		return "", fmt.Errorf("error sending GET request: %s", err)
 	}
@@ -105,36 +238,56 @@ func (c *Client) Get(key string) (string, error) {
 }
 
 func (c *Client) GetForGrpc(key string) (string, error) {
-	nodeId := c.Ring.Get(key)
-	physicalNodeId := c.getPhysicalNodeId(nodeId)
-	nodeInfo := c.Info.Nodes[physicalNodeId]
+	res, err := c.GetWithQuorum(key, 0)
+	if err != nil {
+		return "", err
+	}
+	return res.Value, nil
+}
 
-	if nodeInfo.GrpcClient == nil {
-		client, err := InitCacheClient(c.CertDir, nodeInfo.Host, int(nodeInfo.GrpcPort))
-		if err != nil {
-			return "", fmt.Errorf("error initiating gRPC client: %s", err)
-		}
-		nodeInfo.SetGrpcClient(client)
+// Result of a quorum read
+type ReadResult struct {
+	Value string
+	// Conflict is set when the replicas that answered returned different values
+	Conflict bool
+	Replicas []*pb.ReplicaValue
+}
+
+// GetWithQuorum reads a key over gRPC and waits for r replicas to answer, 0 uses the server default
+func (c *Client) GetWithQuorum(key string, r int) (*ReadResult, error) {
+	nodeId, err := c.getReadNodeId(key)
+	if err != nil {
+		return nil, err
+	}
+	grpcClient, err := c.grpcClient(nodeId)
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := nodeInfo.GrpcClient.Get(ctx, &pb.GetRequest{Key: key})
+	req := &pb.GetRequest{Key: key, ReadQuorum: int32(r)}
+	res, err := grpcClient.Get(ctx, req)
+	if moved, ok := c.movedClient(err); ok {
+		res, err = moved.Get(ctx, req)
+	}
 	if err != nil {
-		return "", fmt.Errorf("error gRPC GET: %s", err)
+		return nil, fmt.Errorf("error gRPC GET: %s", err)
+	}
+	if res.Conflict {
+		log.Printf("Replicas of key %s returned conflicting values: %v", key, res.Replicas)
 	}
 
-	return res.GetData(), nil
+	return &ReadResult{Value: res.GetData(), Conflict: res.Conflict, Replicas: res.Replicas}, nil
 }
 
 func (c *Client) Put(key string, value string) error {
-	nodeId := c.Ring.Get(key)
-	physicalNodeId := c.getPhysicalNodeId(nodeId)
-	if physicalNodeId == "" {
-		return fmt.Errorf("no node found for key: %s", key)
+	physicalNodeId, err := c.getNodeId(key)
+	if err != nil {
+		return err
 	}
-	nodeInfo, exists := c.Info.Nodes[physicalNodeId]
+	nodeInfo, exists := c.Members.Get(physicalNodeId)
 	if !exists {
 		return fmt.Errorf("no node information for node ID: %s", physicalNodeId)
 	}
@@ -143,47 +296,107 @@ func (c *Client) Put(key string, value string) error {
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(payload)
 
-	host := fmt.Sprintf("http://%s:%d/put", nodeInfo.Host, nodeInfo.RestPort)
+	host := fmt.Sprintf("http://%s/put", nodeInfo.RestAddr())
 	req, err := http.NewRequest("POST", host, b)
 	if err != nil {
 		return fmt.Errorf("error creating POST request: %s", err)
 	}
 
 	res, err := new(http.Client).Do(req)
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("error sending POST request: %s", err)
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("error response to POST request: %s %s", res.Status, body)
+	}
 	return nil
 }
 
 func (client *Client) PutForGrpc(key string, value string) error {
-	nodeId := client.Ring.Get(key)
-	physicalNodeId := client.getPhysicalNodeId(nodeId)
-	nodeInfo := client.Info.Nodes[physicalNodeId]
+	return client.PutWithQuorum(key, value, 0)
+}
 
-	if nodeInfo.GrpcClient == nil {
-		client, err := InitCacheClient(client.CertDir, nodeInfo.Host, int(nodeInfo.GrpcPort))
-		if err != nil {
-			return fmt.Errorf("error initiating gRPC client: %s", err)
-		}
-		nodeInfo.SetGrpcClient(client)
+// PutWithQuorum writes a key over gRPC and waits for w replicas to acknowledge, 0 uses the server default
+func (client *Client) PutWithQuorum(key string, value string, w int) error {
+	nodeId, err := client.getNodeId(key)
+	if err != nil {
+		return err
+	}
+	grpcClient, err := client.grpcClient(nodeId)
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := nodeInfo.GrpcClient.Put(ctx, &pb.PutRequest{Key: key, Value: value})
+	req := &pb.PutRequest{Key: key, Value: value, WriteQuorum: int32(w)}
+	_, err = grpcClient.Put(ctx, req)
+	if moved, ok := client.movedClient(err); ok {
+		_, err = moved.Put(ctx, req)
+	}
 	if err != nil {
 		return fmt.Errorf("error making gRPC PUT: %s", err)
 	}
 	return nil
 }
 
+// grpcClient returns the gRPC client of a member, connecting to it on first use
+func (c *Client) grpcClient(nodeId string) (pb.CacheServiceClient, error) {
+	nodeInfo, ok := c.Members.Get(nodeId)
+	if !ok {
+		return nil, fmt.Errorf("no node information for node ID: %s", nodeId)
+	}
+	if nodeInfo.GrpcClient != nil {
+		return nodeInfo.GrpcClient, nil
+	}
+
+	grpcClient, err := InitCacheClient(c.CertDir, nodeInfo.Host, int(nodeInfo.GrpcPort))
+	if err != nil {
+		return nil, fmt.Errorf("error initiating gRPC client: %s", err)
+	}
+	c.Members.Update(nodeId, func(n *node.Node) { n.SetGrpcClient(grpcClient) })
+	return grpcClient, nil
+}
+
+// movedClient returns a client for the node named in a MOVED error, which a
+// node running in redirect mode sends as "MOVED <node id> <host:port>" when
+// the ring of the client is out of date
+func (c *Client) movedClient(err error) (pb.CacheServiceClient, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition {
+		return nil, false
+	}
+	fields := strings.Fields(st.Message())
+	if len(fields) != 3 || fields[0] != "MOVED" {
+		return nil, false
+	}
+
+	if nodeInfo, ok := c.Members.Get(fields[1]); ok && nodeInfo.GrpcClient != nil {
+		return nodeInfo.GrpcClient, true
+	}
+	host, port, err := net.SplitHostPort(fields[2])
+	if err != nil {
+		return nil, false
+	}
+	grpcPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, false
+	}
+	moved, err := InitCacheClient(c.CertDir, host, grpcPort)
+	if err != nil {
+		return nil, false
+	}
+	log.Printf("Key moved to node %s, retrying on %s", fields[1], fields[2])
+	return moved, true
+}
+
 func InitCacheClient(cert string, server_host string, server_port int) (pb.CacheServiceClient, error) {
 	creds, err := LoadTLSCredentials(cert)
 	if err != nil {
-		log.Fatalf("failed to create credentials: %v", err)
+		return nil, fmt.Errorf("failed to create credentials: %v", err)
 	}
 
 	var healthCheck = keepalive.ClientParameters{
@@ -232,91 +445,103 @@ func LoadTLSCredentials(cert string) (credentials.TransportCredentials, error) {
 	return credentials.NewTLS(config), nil
 }
 
+// StartClusterConfigWatcher keeps the ring of the client up to date. It
+// streams the cluster config from one of the nodes and applies every new
+// version as soon as it is pushed. When the stream breaks it moves on to
+// another random node, backing off while none of them answers.
 func (c *Client) StartClusterConfigWatcher() {
 	go func() {
+		backoff := WATCH_MIN_BACKOFF
 		for {
-			var leader *node.Node
-			attempted := make(map[string]bool)
-			for {
-				randomNode := node.GetRandom(c.Ring.Nodes)
-
-				if len(attempted) == len(c.Ring.Nodes) {
-					log.Fatalf("Unable to connect to any nodes!")
-				}
-
-				if _, ok := attempted[randomNode.Id]; ok {
-					log.Printf("Skipping visited node %s...", randomNode.Id)
-					continue
-				}
-
-				attempted[randomNode.Id] = true
-
-				client, err := InitCacheClient(c.CertDir, randomNode.Host, int(randomNode.GrpcPort))
-				if err != nil {
-					continue
+			ids := c.Members.Ids()
+			if len(ids) == 0 {
+				log.Printf("No nodes to watch the cluster config from, retrying in %s", backoff)
+			} else {
+				nodeId := ids[rand.Intn(len(ids))]
+				if err := c.watchClusterConfig(nodeId, &backoff); err != nil {
+					log.Printf("Cluster config watch on node %s failed, retrying in %s: %v", nodeId, backoff, err)
 				}
-
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-
-				res, err := client.GetLeader(ctx, &pb.LeaderRequest{Caller: "client"})
-				if err != nil {
-					continue
-				}
-
-				leader = c.Info.Nodes[res.Id]
-				break
 			}
 
-			if leader == nil {
-				continue
-			}
+			time.Sleep(backoff)
+			backoff = min(2*backoff, WATCH_MAX_BACKOFF)
+		}
+	}()
+}
 
-			req := pb.ClusterConfigRequest{CallerNodeId: "client"}
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-			defer cancel()
+// watchClusterConfig applies the configs streamed by a node until the stream
+// breaks. backoff is reset once the node has sent a config.
+func (c *Client) watchClusterConfig(nodeId string, backoff *time.Duration) error {
+	grpcClient, err := c.grpcClient(nodeId)
+	if err != nil {
+		return err
+	}
 
-			client, err := InitCacheClient(c.CertDir, leader.Host, int(leader.GrpcPort))
-			if err != nil {
-				continue
-			}
+	stream, err := grpcClient.WatchClusterConfig(context.Background(), &pb.ClusterConfigRequest{CallerNodeId: "client"})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		*backoff = WATCH_MIN_BACKOFF
+		c.applyClusterConfig(nodeId, res)
+	}
+}
 
-			res, err := client.GetClusterConfig(ctx, &req)
-			if err != nil {
-				log.Printf("Error getting cluster config from node %s at %s:%d: %v", leader.Id, leader.Host, leader.GrpcPort, err)
-				continue
-			}
+// applyClusterConfig updates the ring and members from a config unless it is
+// older than the one the client already has. The ring is rebuilt and
+// published with the placement it was built from in a single swap.
+func (c *Client) applyClusterConfig(from string, res *pb.ClusterConfig) {
+	current := c.routing.Load()
+	if node.IsStaleConfig(res, current.leaderTerm, current.epoch) {
+		log.Printf("Ignoring stale cluster config epoch %d from node %s", res.Epoch, from)
+		return
+	}
 
-			cluster_nodes := make(map[string]bool)
-			for _, nodecfg := range res.Nodes {
-				cluster_nodes[nodecfg.Id] = true
-			}
+	placement := current.placement
+	if res.Placement != nil {
+		if !proto.Equal(res.Placement, placement) {
+			log.Printf("Placement changed, rebuilding ring")
+		}
+		placement = res.Placement
+	}
+	next, err := newRouting(res, placement)
+	if err != nil {
+		log.Printf("Error building ring from cluster config: %v", err)
+		return
+	}
 
-			for _, node := range c.Info.Nodes {
-				if _, ok := cluster_nodes[node.Id]; !ok {
-					log.Printf("Removing node %s from ring", node.Id)
-					delete(c.Info.Nodes, node.Id)
-					c.Ring.Remove(node.Id)
-				}
-			}
+	c.setSlotTable(res.SlotTable)
+	if res.LeaderId != "" {
+		c.Members.AdoptLeader(res.LeaderId, res.LeaderTerm)
+	}
 
-			for _, nodeConfig := range res.Nodes {
-				if _, ok := c.Info.Nodes[nodeConfig.Id]; !ok {
-					log.Printf("Adding node %s to ring", nodeConfig.Id)
-					c.Info.Nodes[nodeConfig.Id] = node.InitNode(nodeConfig.Id, nodeConfig.Host, nodeConfig.RestPort, nodeConfig.GrpcPort)
-					c.Ring.Add(nodeConfig.Id, nodeConfig.Host, nodeConfig.RestPort, nodeConfig.GrpcPort)
-				}
-			}
+	cluster_nodes := make(map[string]bool)
+	for _, nodecfg := range res.Nodes {
+		cluster_nodes[nodecfg.Id] = true
+	}
 
-			time.Sleep(3 * time.Second)
+	// members join before the ring routes to them and leave once it no longer does
+	for _, nodeConfig := range res.Nodes {
+		updated := node.FromProto(nodeConfig)
+		current, ok := c.Members.Get(updated.Id)
+		switch {
+		case !ok:
+			c.Members.Add(updated)
+			log.Printf("Adding node %s to ring", updated.Id)
+		case !current.SameConfig(updated):
+			// the connection goes with the old member, it may point to an old address
+			c.Members.Update(updated.Id, func(n *node.Node) { *n = *updated })
+			log.Printf("Updating node %s", updated.Id)
+		}
+	}
+	c.routing.Store(next)
+	for _, id := range c.Members.Ids() {
+		if _, ok := cluster_nodes[id]; !ok && c.Members.Remove(id) {
+			log.Printf("Removing node %s from ring", id)
 		}
-	}()
-}
-
-func (c *Client) getPhysicalNodeId(virtualNodeId string) string {
-	parts := strings.Split(virtualNodeId, "-")
-	if len(parts) > 1 {
-		return parts[0]
 	}
-	return virtualNodeId
 }
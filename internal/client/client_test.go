@@ -6,6 +6,11 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/nathang15/go-tinystore/internal/discovery"
+	"github.com/nathang15/go-tinystore/internal/membership"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
 )
 
 const (
@@ -22,7 +27,10 @@ func Test10kPutsNoVnode(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -56,7 +64,10 @@ func Test10kPuts(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -90,7 +101,10 @@ func Test50kPuts(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -125,7 +139,10 @@ func Test10kGRPCPutsNoVnode(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -158,7 +175,10 @@ func Test10kGRPCPuts(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -192,7 +212,10 @@ func Test50kGRPCPuts(t *testing.T) {
 	certdir, _ := filepath.Abs(CLIENT_CERT_DIR)
 	configPath, _ := filepath.Abs(CONFIG_PATH)
 
-	c := InitClient(certdir, configPath, vNode)
+	c, err := InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -220,3 +243,127 @@ func Test50kGRPCPuts(t *testing.T) {
 	t.Logf("Time to complete 50k puts with GRPC, 10 virtual nodes: %s", end)
 	t.Logf("Cache misses: %d/50,000 (%f%%)", int(miss), miss/50000)
 }
+
+func testConfig(epoch int64, replicas int32, ids ...string) *pb.ClusterConfig {
+	cfg := &pb.ClusterConfig{
+		Placement:  &pb.Placement{Algorithm: node.RING, VirtualNodes: 10, ReplicationFactor: replicas},
+		Epoch:      epoch,
+		LeaderTerm: 1,
+	}
+	for i, id := range ids {
+		cfg.Nodes = append(cfg.Nodes, node.InitNode(id, id+"-host", int32(8080+i), int32(5005+i)).ToProto())
+	}
+	return cfg
+}
+
+func testClient(t *testing.T, cfg *pb.ClusterConfig) *Client {
+	nodes := make(map[string]*node.Node)
+	for _, n := range cfg.Nodes {
+		nodes[n.Id] = node.FromProto(n)
+	}
+	r, err := newRouting(cfg, cfg.Placement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Members: membership.New(node.NodesInfo{Nodes: nodes})}
+	c.routing.Store(r)
+	return c
+}
+
+func TestApplyClusterConfig(t *testing.T) {
+	c := testClient(t, testConfig(1, 1, "node0", "node1"))
+
+	// requests keep routing while configs are applied
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := c.getReadNodeId(strconv.Itoa(i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for epoch := int64(2); epoch < 50; epoch++ {
+		c.applyClusterConfig("node0", testConfig(epoch, 2, "node0", "node1", "node2"))
+	}
+	close(stop)
+	<-done
+
+	if c.Replicas() != 2 {
+		t.Errorf("expected the new replication factor, got %d", c.Replicas())
+	}
+	if _, ok := c.Members.Get("node2"); !ok {
+		t.Error("expected node2 to be a member")
+	}
+	owners := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		owners[c.Ring().LookupOwner(strconv.Itoa(i))] = true
+	}
+	if !owners["node2"] {
+		t.Errorf("expected node2 on the ring, keys are owned by %v", owners)
+	}
+
+	// a stale config changes nothing
+	c.applyClusterConfig("node1", testConfig(1, 1, "node0", "node1"))
+	if _, ok := c.Members.Get("node2"); !ok || c.Replicas() != 2 {
+		t.Error("expected the stale config to be ignored")
+	}
+}
+
+func TestApplyClusterConfigUpdatesMembers(t *testing.T) {
+	c := testClient(t, testConfig(1, 1, "node0", "node1"))
+
+	// node1 came back on another host and in another zone
+	cfg := testConfig(2, 1, "node0", "node1")
+	cfg.Nodes[1].Host = "10.0.0.9"
+	cfg.Nodes[1].RestPort = 9000
+	cfg.Nodes[1].Zone = "b"
+	c.applyClusterConfig("node0", cfg)
+
+	updated, _ := c.Members.Get("node1")
+	if updated.Host != "10.0.0.9" || updated.RestAddr() != "10.0.0.9:9000" || updated.Zone != "b" {
+		t.Errorf("expected node1 to be updated, got %+v", updated)
+	}
+	if unchanged, _ := c.Members.Get("node0"); unchanged.Host != "node0-host" {
+		t.Errorf("expected node0 to be unchanged, got %+v", unchanged)
+	}
+}
+
+func TestInitCacheClientWithoutCerts(t *testing.T) {
+	// a missing cert is an error the watcher retries, not a reason to exit
+	if _, err := InitCacheClient(t.TempDir(), "localhost", 5005); err == nil {
+		t.Error("expected an error without certificates")
+	}
+	c := testClient(t, testConfig(1, 1, "node0"))
+	c.CertDir = t.TempDir()
+	backoff := WATCH_MAX_BACKOFF
+	if err := c.watchClusterConfig("node0", &backoff); err == nil {
+		t.Error("expected the watch to fail without certificates")
+	}
+}
+
+func TestNoReachableNode(t *testing.T) {
+	// no seed is reachable without certificates, the client is not built
+	if _, err := InitClientWithDiscovery(t.TempDir(), discovery.Static{{Host: "localhost", Port: 5005}}, 10); err == nil {
+		t.Error("expected an error without any reachable seed")
+	}
+	if _, err := InitClientWithDiscovery(t.TempDir(), discovery.Static{}, 10); err == nil {
+		t.Error("expected an error without seeds")
+	}
+
+	// lookups on a ring without nodes fail instead of panicking
+	c := testClient(t, testConfig(1, 1))
+	if _, err := c.GetWithQuorum("key", 0); err == nil {
+		t.Error("expected a read to fail without nodes")
+	}
+	if err := c.Put("key", "value"); err == nil {
+		t.Error("expected a write to fail without nodes")
+	}
+}
@@ -0,0 +1,243 @@
+// Seed discovery: how a new node or a client finds the nodes of the cluster
+// it bootstraps from. Seeds only need to be reachable, the cluster config
+// served by any of them names every member.
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+)
+
+const DEFAULT_WATCH_INTERVAL = 5 * time.Second
+
+// Seed is the gRPC address of a node to bootstrap from
+type Seed struct {
+	// Node id, empty when the source only knows addresses
+	Id   string
+	Host string
+	Port int32
+}
+
+func (seed Seed) Addr() string {
+	return net.JoinHostPort(seed.Host, strconv.Itoa(int(seed.Port)))
+}
+
+type Discovery interface {
+	// Seeds returns the nodes to bootstrap from
+	Seeds(ctx context.Context) ([]Seed, error)
+}
+
+// Watcher is a discovery whose seeds change over time
+type Watcher interface {
+	Discovery
+	// Watch sends the seeds every time they change until stop is closed
+	Watch(stop <-chan bool) <-chan []Seed
+}
+
+// Parse builds a discovery from a spec:
+//
+//	static:host1:5005,host2:5005  fixed list of addresses
+//	dns:name:5005                 A/AAAA records of name, all on port 5005
+//	srv:name                      SRV records of name, e.g. _grpc._tcp.tinystore.default.svc.cluster.local
+//	file:path                     watched seed file, see File
+func Parse(spec string) (Discovery, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid discovery %q, expected <kind>:<argument>", spec)
+	}
+	switch kind {
+	case "static":
+		return ParseStatic(arg)
+	case "dns":
+		seed, err := parseSeed(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &DNS{Name: seed.Host, Port: seed.Port}, nil
+	case "srv":
+		return &DNS{Name: arg, SRV: true}, nil
+	case "file":
+		return NewFile(arg), nil
+	default:
+		return nil, fmt.Errorf("unknown discovery %q", kind)
+	}
+}
+
+// Static is a fixed list of seeds
+type Static []Seed
+
+func (static Static) Seeds(ctx context.Context) ([]Seed, error) {
+	return static, nil
+}
+
+// FromConfig uses the nodes of a config file as seeds
+func FromConfig(info node.NodesInfo) Static {
+	var static Static
+	for _, n := range info.Nodes {
+		static = append(static, Seed{Id: n.Id, Host: n.Host, Port: n.GrpcPort})
+	}
+	sort.Slice(static, func(i, j int) bool { return static[i].Id < static[j].Id })
+	return static
+}
+
+// ParseStatic reads a comma separated list of host:port addresses
+func ParseStatic(addrs string) (Static, error) {
+	var static Static
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		seed, err := parseSeed(addr)
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, seed)
+	}
+	return static, nil
+}
+
+func parseSeed(addr string) (Seed, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return Seed{}, fmt.Errorf("invalid seed %q: %v", addr, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 || host == "" {
+		return Seed{}, fmt.Errorf("invalid seed %q, expected host:port", addr)
+	}
+	return Seed{Host: host, Port: int32(p)}, nil
+}
+
+// Resolver looks up DNS records, *net.Resolver implements it
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNS finds seeds through DNS, e.g. the headless service of a Kubernetes
+// StatefulSet, which resolves to every ready pod
+type DNS struct {
+	Name string
+	// Port of every address with A/AAAA lookups
+	Port int32
+	// Look up SRV records, which carry the port of each target
+	SRV bool
+	// net.DefaultResolver when nil
+	Resolver Resolver
+}
+
+func (d *DNS) Seeds(ctx context.Context) ([]Seed, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var seeds []Seed
+	if d.SRV {
+		// records come sorted by priority and randomized by weight
+		_, records, err := resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			seeds = append(seeds, Seed{Host: strings.TrimSuffix(record.Target, "."), Port: int32(record.Port)})
+		}
+		return seeds, nil
+	}
+
+	addrs, err := resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		seeds = append(seeds, Seed{Host: addr, Port: d.Port})
+	}
+	return seeds, nil
+}
+
+// File reads seeds from a file that can change while nodes run. The file is
+// either a nodes config in the format of the -config file, or lists one
+// host:port per line, with # starting a comment.
+type File struct {
+	Path     string
+	Interval time.Duration
+}
+
+func NewFile(path string) *File {
+	return &File{Path: path, Interval: DEFAULT_WATCH_INTERVAL}
+}
+
+func (f *File) Seeds(ctx context.Context) ([]Seed, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFile(data)
+}
+
+// Watch polls the file every Interval. Unreadable or invalid versions of the
+// file are skipped, the last valid seeds stay in use.
+func (f *File) Watch(stop <-chan bool) <-chan []Seed {
+	updates := make(chan []Seed, 1)
+	go func() {
+		defer close(updates)
+		var last []byte
+		ticker := time.NewTicker(f.Interval)
+		defer ticker.Stop()
+		for {
+			if data, err := os.ReadFile(f.Path); err == nil && (last == nil || !bytes.Equal(data, last)) {
+				if seeds, err := parseFile(data); err == nil {
+					last = data
+					select {
+					case updates <- seeds:
+					case <-stop:
+						return
+					}
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return updates
+}
+
+func parseFile(data []byte) ([]Seed, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var info node.NodesInfo
+		if err := json.Unmarshal(trimmed, &info); err != nil {
+			return nil, err
+		}
+		return FromConfig(info), nil
+	}
+
+	var seeds []Seed
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		seed, err := parseSeed(line)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, scanner.Err()
+}
@@ -0,0 +1,149 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resolver answering from fixed records, as a headless service would
+type fakeResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func addrs(seeds []Seed) string {
+	var out []string
+	for _, seed := range seeds {
+		out = append(out, seed.Addr())
+	}
+	return fmt.Sprint(out)
+}
+
+func TestDNS(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{"tinystore.default.svc.cluster.local": {"10.0.0.7", "10.0.0.5"}},
+		srv: map[string][]*net.SRV{"_grpc._tcp.tinystore.default.svc.cluster.local": {
+			{Target: "tinystore-0.tinystore.default.svc.cluster.local.", Port: 5005},
+			{Target: "tinystore-1.tinystore.default.svc.cluster.local.", Port: 5006},
+		}},
+	}
+
+	a := &DNS{Name: "tinystore.default.svc.cluster.local", Port: 5005, Resolver: resolver}
+	seeds, err := a.Seeds(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(seeds); got != "[10.0.0.5:5005 10.0.0.7:5005]" {
+		t.Errorf("expected both pods on port 5005, got %s", got)
+	}
+
+	srv := &DNS{Name: "_grpc._tcp.tinystore.default.svc.cluster.local", SRV: true, Resolver: resolver}
+	seeds, err = srv.Seeds(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(seeds); got != "[tinystore-0.tinystore.default.svc.cluster.local:5005 tinystore-1.tinystore.default.svc.cluster.local:5006]" {
+		t.Errorf("expected SRV targets with their ports, got %s", got)
+	}
+
+	missing := &DNS{Name: "nothing.local", Port: 5005, Resolver: resolver}
+	var dnsErr *net.DNSError
+	if _, err := missing.Seeds(context.Background()); !errors.As(err, &dnsErr) {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	d, err := Parse("static:node0:5005, 10.0.0.5:5006")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds, _ := d.Seeds(context.Background())
+	if got := addrs(seeds); got != "[node0:5005 10.0.0.5:5006]" {
+		t.Errorf("expected the static seeds, got %s", got)
+	}
+
+	d, err = Parse("dns:tinystore.default.svc.cluster.local:5005")
+	if dns, ok := d.(*DNS); err != nil || !ok || dns.Name != "tinystore.default.svc.cluster.local" || dns.Port != 5005 || dns.SRV {
+		t.Errorf("expected an A lookup on port 5005, got %+v %v", d, err)
+	}
+	d, err = Parse("srv:_grpc._tcp.tinystore")
+	if dns, ok := d.(*DNS); err != nil || !ok || dns.Name != "_grpc._tcp.tinystore" || !dns.SRV {
+		t.Errorf("expected an SRV lookup, got %+v %v", d, err)
+	}
+	d, err = Parse("file:/etc/tinystore/seeds")
+	if file, ok := d.(*File); err != nil || !ok || file.Path != "/etc/tinystore/seeds" {
+		t.Errorf("expected a seed file, got %+v %v", d, err)
+	}
+
+	for _, spec := range []string{"", "static", "consul:tinystore", "static:node0", "dns:tinystore", "static::5005"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# seeds\nnode0:5005\n\n10.0.0.5:5006 # second\n")
+	file := &File{Path: path, Interval: 5 * time.Millisecond}
+	seeds, err := file.Seeds(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(seeds); got != "[node0:5005 10.0.0.5:5006]" {
+		t.Errorf("expected the listed seeds, got %s", got)
+	}
+
+	stop := make(chan bool)
+	defer close(stop)
+	updates := file.Watch(stop)
+	next := func() []Seed {
+		select {
+		case seeds := <-updates:
+			return seeds
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the seed file to be reloaded")
+			return nil
+		}
+	}
+	if got := addrs(next()); got != "[node0:5005 10.0.0.5:5006]" {
+		t.Errorf("expected the current seeds first, got %s", got)
+	}
+
+	// an invalid version is skipped, the next valid one is picked up
+	write("node0\n")
+	write(`{"nodes": {"node3": {"id": "node3", "host": "node3-host", "grpcPort": 5007}}}`)
+	seeds = next()
+	if got := addrs(seeds); got != "[node3-host:5007]" || seeds[0].Id != "node3" {
+		t.Errorf("expected the nodes of the config, got %s %v", got, seeds)
+	}
+}
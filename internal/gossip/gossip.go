@@ -0,0 +1,469 @@
+// SWIM-style gossip membership: every node probes one random member per
+// interval, asks other members to probe it indirectly when it does not
+// answer, and only declares it dead after a suspicion timeout. Membership
+// updates are piggybacked on probe messages instead of being broadcast.
+package gossip
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+type State int32
+
+const (
+	ALIVE State = iota
+	SUSPECT
+	DEAD
+	LEFT
+)
+
+func (s State) String() string {
+	switch s {
+	case ALIVE:
+		return "alive"
+	case SUSPECT:
+		return "suspect"
+	case DEAD:
+		return "dead"
+	default:
+		return "left"
+	}
+}
+
+type EventType int
+
+const (
+	EVENT_JOIN EventType = iota
+	EVENT_SUSPECT
+	EVENT_ALIVE
+	EVENT_LEAVE
+)
+
+// Max number of updates piggybacked on a single message
+const MAX_PIGGYBACK = 8
+
+// Membership change published to subscribers
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+type Member struct {
+	Node        *pb.Node
+	State       State
+	Incarnation uint64
+}
+
+type Config struct {
+	// How often a member is probed
+	ProbeInterval time.Duration
+	// How long to wait for a direct probe before probing indirectly
+	ProbeTimeout time.Duration
+	// Number of members asked to probe an unresponsive member
+	IndirectProbes int
+	// How long a member stays suspect before it is declared dead
+	SuspicionTimeout time.Duration
+	// An update is piggybacked on RetransmitMult * log10(n+1) messages
+	RetransmitMult int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     500 * time.Millisecond,
+		IndirectProbes:   3,
+		SuspicionTimeout: 5 * time.Second,
+		RetransmitMult:   4,
+	}
+}
+
+// Transport sends probes to other members
+type Transport interface {
+	Ping(ctx context.Context, target *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error)
+	PingReq(ctx context.Context, via *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error)
+}
+
+type member struct {
+	Member
+	suspectedAt time.Time
+}
+
+type broadcast struct {
+	update    *pb.Member
+	transmits int
+}
+
+type Memberlist struct {
+	mut         sync.Mutex
+	self        string
+	members     map[string]*member
+	queue       []*broadcast
+	probeOrder  []string
+	probeIndex  int
+	transport   Transport
+	config      Config
+	subscribers []chan Event
+	// events produced under the lock, delivered once it is released
+	pending []Event
+	// keeps events in the order they were produced while delivering them
+	deliverMut sync.Mutex
+}
+
+func New(self *pb.Node, transport Transport, config Config) *Memberlist {
+	m := &Memberlist{
+		self:      self.Id,
+		members:   make(map[string]*member),
+		transport: transport,
+		config:    config,
+	}
+	m.members[self.Id] = &member{Member: Member{Node: self, State: ALIVE}}
+	return m
+}
+
+// Subscribe returns a channel receiving every membership event
+func (m *Memberlist) Subscribe() <-chan Event {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	ch := make(chan Event, 256)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Members returns the members that are alive or suspect, including this node
+func (m *Memberlist) Members() []Member {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var members []Member
+	for _, mem := range m.members {
+		if mem.State == ALIVE || mem.State == SUSPECT {
+			members = append(members, mem.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Node.Id < members[j].Node.Id })
+	return members
+}
+
+// Join adds the seed nodes as members and announces this node to them
+func (m *Memberlist) Join(ctx context.Context, seeds []*pb.Node) int {
+	m.mut.Lock()
+	for _, seed := range seeds {
+		m.apply(&pb.Member{Node: seed, State: int32(ALIVE)})
+	}
+	m.mut.Unlock()
+	m.flush()
+
+	joined := 0
+	for _, seed := range seeds {
+		ack, err := m.transport.Ping(ctx, seed, m.ping(seed))
+		if err != nil {
+			continue
+		}
+		m.handleAck(ack)
+		joined++
+	}
+	return joined
+}
+
+// Leave announces that this node leaves the cluster
+func (m *Memberlist) Leave(ctx context.Context) {
+	m.mut.Lock()
+	self := m.members[m.self]
+	self.Incarnation++
+	self.State = LEFT
+	targets := m.randomMembers(m.config.IndirectProbes, "")
+	m.mut.Unlock()
+
+	for _, target := range targets {
+		m.transport.Ping(ctx, target, m.ping(target))
+	}
+}
+
+// Run probes members until stop is closed
+func (m *Memberlist) Run(stop <-chan bool) {
+	ticker := time.NewTicker(m.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		m.Probe()
+		m.ReapSuspects(time.Now())
+	}
+}
+
+// Probe pings the next member and suspects it if neither it nor any of the
+// members asked to probe it indirectly gets an answer
+func (m *Memberlist) Probe() {
+	m.mut.Lock()
+	target := m.nextTarget()
+	m.mut.Unlock()
+	if target == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.ProbeTimeout)
+	ack, err := m.transport.Ping(ctx, target, m.ping(target))
+	cancel()
+	if err == nil {
+		m.handleAck(ack)
+		return
+	}
+
+	m.mut.Lock()
+	helpers := m.randomMembers(m.config.IndirectProbes, target.Id)
+	m.mut.Unlock()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*m.config.ProbeTimeout)
+	defer cancel()
+	acks := make(chan *pb.GossipAck, len(helpers))
+	for _, helper := range helpers {
+		go func(helper *pb.Node) {
+			msg := m.ping(helper)
+			msg.Target = target
+			ack, err := m.transport.PingReq(ctx, helper, msg)
+			if err != nil {
+				ack = nil
+			}
+			acks <- ack
+		}(helper)
+	}
+	for range helpers {
+		if ack := <-acks; ack != nil {
+			m.handleAck(ack)
+			return
+		}
+	}
+
+	m.mut.Lock()
+	if mem, ok := m.members[target.Id]; ok && mem.State == ALIVE {
+		m.apply(&pb.Member{Node: mem.Node, State: int32(SUSPECT), Incarnation: mem.Incarnation})
+	}
+	m.mut.Unlock()
+	m.flush()
+}
+
+// ReapSuspects declares members dead that stayed suspect for longer than the suspicion timeout
+func (m *Memberlist) ReapSuspects(now time.Time) {
+	m.mut.Lock()
+	for _, mem := range m.members {
+		if mem.State == SUSPECT && now.Sub(mem.suspectedAt) > m.config.SuspicionTimeout {
+			m.apply(&pb.Member{Node: mem.Node, State: int32(DEAD), Incarnation: mem.Incarnation})
+		}
+	}
+	m.mut.Unlock()
+	m.flush()
+}
+
+// HandlePing applies the updates of a probe and acknowledges it
+func (m *Memberlist) HandlePing(msg *pb.GossipPing) *pb.GossipAck {
+	m.mut.Lock()
+	for _, update := range msg.Updates {
+		m.apply(update)
+	}
+	ack := &pb.GossipAck{NodeId: m.self, Updates: m.piggyback(msg.CallerNodeId)}
+	m.mut.Unlock()
+	m.flush()
+	return ack
+}
+
+// HandlePingReq probes the target of an indirect probe and relays its ack
+func (m *Memberlist) HandlePingReq(ctx context.Context, msg *pb.GossipPing) (*pb.GossipAck, error) {
+	m.HandlePing(msg)
+	ack, err := m.transport.Ping(ctx, msg.Target, m.ping(msg.Target))
+	if err != nil {
+		return nil, err
+	}
+	m.handleAck(ack)
+	return ack, nil
+}
+
+func (m *Memberlist) handleAck(ack *pb.GossipAck) {
+	m.mut.Lock()
+	for _, update := range ack.Updates {
+		m.apply(update)
+	}
+	m.mut.Unlock()
+	m.flush()
+}
+
+func (m *Memberlist) ping(target *pb.Node) *pb.GossipPing {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return &pb.GossipPing{CallerNodeId: m.self, Target: target, Updates: m.piggyback(target.Id)}
+}
+
+// apply merges an update into the member list following the SWIM rules: a
+// higher incarnation always wins, on equal incarnations suspect overrides
+// alive and dead overrides both. Caller holds the lock.
+func (m *Memberlist) apply(update *pb.Member) {
+	if update.Node == nil {
+		return
+	}
+	id := update.Node.Id
+	state := State(update.State)
+
+	if id == m.self {
+		// refute suspicion by outliving it with a higher incarnation, the own
+		// record goes along with every message
+		self := m.members[id]
+		if (state == SUSPECT || state == DEAD) && self.State == ALIVE && update.Incarnation >= self.Incarnation {
+			self.Incarnation = update.Incarnation + 1
+		}
+		return
+	}
+
+	cur, ok := m.members[id]
+	if !ok {
+		if state == DEAD || state == LEFT {
+			return
+		}
+		cur = &member{Member: Member{Node: update.Node, State: state, Incarnation: update.Incarnation}, suspectedAt: time.Now()}
+		m.members[id] = cur
+		m.enqueue(cur)
+		m.publish(EVENT_JOIN, cur)
+		return
+	}
+
+	switch state {
+	case ALIVE:
+		if update.Incarnation <= cur.Incarnation {
+			return
+		}
+		prev := cur.State
+		cur.Node, cur.State, cur.Incarnation = update.Node, ALIVE, update.Incarnation
+		m.enqueue(cur)
+		switch prev {
+		case SUSPECT:
+			m.publish(EVENT_ALIVE, cur)
+		case DEAD, LEFT:
+			m.publish(EVENT_JOIN, cur)
+		}
+	case SUSPECT:
+		if cur.State == DEAD || cur.State == LEFT || update.Incarnation < cur.Incarnation ||
+			(cur.State == SUSPECT && update.Incarnation == cur.Incarnation) {
+			return
+		}
+		cur.State, cur.Incarnation, cur.suspectedAt = SUSPECT, update.Incarnation, time.Now()
+		m.enqueue(cur)
+		m.publish(EVENT_SUSPECT, cur)
+	case DEAD, LEFT:
+		if cur.State == DEAD || cur.State == LEFT || update.Incarnation < cur.Incarnation {
+			return
+		}
+		cur.State, cur.Incarnation = state, update.Incarnation
+		m.enqueue(cur)
+		m.publish(EVENT_LEAVE, cur)
+	}
+}
+
+// enqueue schedules an update for dissemination, replacing any older update
+// about the same member. Caller holds the lock.
+func (m *Memberlist) enqueue(mem *member) {
+	update := &pb.Member{Node: mem.Node, State: int32(mem.State), Incarnation: mem.Incarnation}
+	for i, b := range m.queue {
+		if b.update.Node.Id == mem.Node.Id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	m.queue = append(m.queue, &broadcast{update: update})
+}
+
+// piggyback picks the least transmitted updates for a message to a member.
+// The sender's own record always goes along, as does the record of the
+// receiver when it is not alive so that it can refute it. Messages to such a
+// receiver carry nothing else, they are likely lost and would use up the
+// transmits of the updates. Caller holds the lock.
+func (m *Memberlist) piggyback(to string) []*pb.Member {
+	self := m.members[m.self]
+	updates := []*pb.Member{{Node: self.Node, State: int32(self.State), Incarnation: self.Incarnation}}
+	if mem, ok := m.members[to]; ok && mem.State != ALIVE {
+		return append(updates, &pb.Member{Node: mem.Node, State: int32(mem.State), Incarnation: mem.Incarnation})
+	}
+
+	sort.SliceStable(m.queue, func(i, j int) bool { return m.queue[i].transmits < m.queue[j].transmits })
+	limit := m.config.RetransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+1))))
+	kept := m.queue[:0]
+	for i, b := range m.queue {
+		if i < MAX_PIGGYBACK {
+			updates = append(updates, b.update)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	m.queue = kept
+	return updates
+}
+
+// nextTarget walks the members in a random order that is reshuffled after
+// every full pass. Caller holds the lock.
+func (m *Memberlist) nextTarget() *pb.Node {
+	for attempts := 0; attempts < 2; attempts++ {
+		for m.probeIndex < len(m.probeOrder) {
+			id := m.probeOrder[m.probeIndex]
+			m.probeIndex++
+			if mem, ok := m.members[id]; ok && (mem.State == ALIVE || mem.State == SUSPECT) {
+				return mem.Node
+			}
+		}
+		m.probeOrder = m.probeOrder[:0]
+		for id := range m.members {
+			if id != m.self {
+				m.probeOrder = append(m.probeOrder, id)
+			}
+		}
+		rand.Shuffle(len(m.probeOrder), func(i, j int) { m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i] })
+		m.probeIndex = 0
+	}
+	return nil
+}
+
+// randomMembers picks up to k alive members other than this node and exclude. Caller holds the lock.
+func (m *Memberlist) randomMembers(k int, exclude string) []*pb.Node {
+	var nodes []*pb.Node
+	for id, mem := range m.members {
+		if id != m.self && id != exclude && mem.State == ALIVE {
+			nodes = append(nodes, mem.Node)
+		}
+	}
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if len(nodes) > k {
+		nodes = nodes[:k]
+	}
+	return nodes
+}
+
+// publish queues an event for the subscribers. Caller holds the lock.
+func (m *Memberlist) publish(eventType EventType, mem *member) {
+	m.pending = append(m.pending, Event{Type: eventType, Member: mem.Member})
+}
+
+// flush delivers the queued events outside the lock
+func (m *Memberlist) flush() {
+	m.deliverMut.Lock()
+	defer m.deliverMut.Unlock()
+
+	m.mut.Lock()
+	events := m.pending
+	m.pending = nil
+	subscribers := append([]chan Event{}, m.subscribers...)
+	m.mut.Unlock()
+
+	for _, event := range events {
+		for _, ch := range subscribers {
+			ch <- event
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+// in-memory network connecting member lists, nodes marked down drop every message
+type network struct {
+	mut   sync.Mutex
+	lists map[string]*Memberlist
+	down  map[string]bool
+}
+
+type memTransport struct {
+	net  *network
+	from string
+}
+
+func (n *network) get(from string, to string) (*Memberlist, error) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if n.down[from] || n.down[to] {
+		return nil, errors.New("unreachable")
+	}
+	return n.lists[to], nil
+}
+
+func (t *memTransport) Ping(ctx context.Context, target *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error) {
+	m, err := t.net.get(t.from, target.Id)
+	if err != nil {
+		return nil, err
+	}
+	return m.HandlePing(msg), nil
+}
+
+func (t *memTransport) PingReq(ctx context.Context, via *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error) {
+	m, err := t.net.get(t.from, via.Id)
+	if err != nil {
+		return nil, err
+	}
+	return m.HandlePingReq(ctx, msg)
+}
+
+func testCluster(n int) (*network, []*Memberlist) {
+	net := &network{lists: make(map[string]*Memberlist), down: make(map[string]bool)}
+	config := DefaultConfig()
+	config.ProbeTimeout = 10 * time.Millisecond
+
+	var lists []*Memberlist
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("node%d", i)
+		m := New(&pb.Node{Id: id, Host: "localhost", GrpcPort: int32(5005 + i)}, &memTransport{net: net, from: id}, config)
+		net.lists[id] = m
+		lists = append(lists, m)
+	}
+	return net, lists
+}
+
+func probeRounds(lists []*Memberlist, rounds int) {
+	for i := 0; i < rounds; i++ {
+		for _, m := range lists {
+			m.Probe()
+		}
+	}
+}
+
+func TestJoinAndDissemination(t *testing.T) {
+	_, lists := testCluster(5)
+	seed := lists[0].members["node0"].Node
+	for _, m := range lists[1:] {
+		if joined := m.Join(context.Background(), []*pb.Node{seed}); joined != 1 {
+			t.Fatalf("expected to join through the seed, joined %d", joined)
+		}
+	}
+
+	probeRounds(lists, 10)
+	for _, m := range lists {
+		if members := m.Members(); len(members) != 5 {
+			t.Errorf("expected %s to know all 5 members, got %v", m.self, members)
+		}
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	net, lists := testCluster(4)
+	for _, m := range lists[1:] {
+		m.Join(context.Background(), []*pb.Node{lists[0].members["node0"].Node})
+	}
+	probeRounds(lists, 10)
+	events := lists[0].Subscribe()
+
+	net.mut.Lock()
+	net.down["node3"] = true
+	net.mut.Unlock()
+
+	probeRounds(lists[:3], 5)
+	if state := lists[0].members["node3"].State; state != SUSPECT {
+		t.Fatalf("expected node3 to be suspect, got %v", state)
+	}
+	if event := <-events; event.Type != EVENT_SUSPECT || event.Member.Node.Id != "node3" {
+		t.Errorf("expected a suspect event for node3, got %+v", event)
+	}
+
+	// node0 runs its suspicion timeout out, the others only learn that node3
+	// is dead from the gossip
+	for round := 0; round < 100 && !converged(lists[:3], 3); round++ {
+		probeRounds(lists[:3], 1)
+		lists[0].ReapSuspects(time.Now().Add(time.Duration(round) * time.Second))
+	}
+	if event := <-events; event.Type != EVENT_LEAVE || event.Member.Node.Id != "node3" {
+		t.Errorf("expected a leave event for node3, got %+v", event)
+	}
+	for _, m := range lists[:3] {
+		if members := m.Members(); len(members) != 3 {
+			t.Errorf("expected %s to drop node3, got %v", m.self, members)
+		}
+	}
+}
+
+// converged reports whether every list holds exactly n members
+func converged(lists []*Memberlist, n int) bool {
+	for _, m := range lists {
+		if len(m.Members()) != n {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	_, lists := testCluster(3)
+	for _, m := range lists[1:] {
+		m.Join(context.Background(), []*pb.Node{lists[0].members["node0"].Node})
+	}
+	probeRounds(lists, 10)
+	events := lists[0].Subscribe()
+
+	// node0 wrongly suspects node1, which refutes it with a higher incarnation
+	lists[0].mut.Lock()
+	lists[0].apply(&pb.Member{Node: lists[0].members["node1"].Node, State: int32(SUSPECT)})
+	lists[0].mut.Unlock()
+	lists[0].flush()
+	probeRounds(lists, 10)
+
+	if mem := lists[0].members["node1"]; mem.State != ALIVE || mem.Incarnation == 0 {
+		t.Errorf("expected node1 to be alive with a new incarnation, got %v@%d", mem.State, mem.Incarnation)
+	}
+	if event := <-events; event.Type != EVENT_SUSPECT {
+		t.Errorf("expected suspect event first, got %+v", event)
+	}
+	if event := <-events; event.Type != EVENT_ALIVE || event.Member.Node.Id != "node1" {
+		t.Errorf("expected node1 to be alive again, got %+v", event)
+	}
+}
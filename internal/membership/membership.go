@@ -0,0 +1,303 @@
+// Cluster membership shared by the subsystems of a node: the nodes of the
+// cluster, its placement parameters and its leader. Every change replaces the
+// node map instead of modifying it, so snapshots can be read without locking,
+// and subscribers are told about joins, leaves and leader changes.
+package membership
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+const NO_LEADER = "NO LEADER"
+
+type EventType int
+
+const (
+	EVENT_JOIN EventType = iota
+	EVENT_LEAVE
+	EVENT_LEADER
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EVENT_JOIN:
+		return "join"
+	case EVENT_LEAVE:
+		return "leave"
+	default:
+		return "leader"
+	}
+}
+
+type Event struct {
+	Type EventType
+	// Node that joined or left
+	Node *node.Node
+	// Leader and term after a leader change
+	LeaderId string
+	Term     int64
+}
+
+type Membership struct {
+	mut      sync.RWMutex
+	info     node.NodesInfo
+	leaderId string
+	term     int64
+	// epoch of the cluster config the members belong to
+	epoch int64
+
+	subscribers []chan Event
+	// events produced under the lock, delivered in order once it is released
+	pending    []Event
+	deliverMut sync.Mutex
+}
+
+func New(info node.NodesInfo) *Membership {
+	nodes := make(map[string]*node.Node, len(info.Nodes))
+	for id, n := range info.Nodes {
+		nodes[id] = clone(n)
+	}
+	info.Nodes = nodes
+	return &Membership{info: info, leaderId: NO_LEADER}
+}
+
+// Snapshot returns the members and placement parameters. The snapshot is
+// never modified once handed out, and callers must not modify it either.
+func (m *Membership) Snapshot() node.NodesInfo {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.info
+}
+
+// Epoch returns the epoch of the cluster config the members belong to
+func (m *Membership) Epoch() int64 {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.epoch
+}
+
+// Versioned returns a snapshot along with the epoch it belongs to
+func (m *Membership) Versioned() (node.NodesInfo, int64) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.info, m.epoch
+}
+
+// NextEpoch allocates a new epoch for the current members and returns it
+// with the snapshot it versions
+func (m *Membership) NextEpoch() (node.NodesInfo, int64) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.epoch++
+	return m.info, m.epoch
+}
+
+// Get returns a member, which must not be modified
+func (m *Membership) Get(id string) (*node.Node, bool) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	n, ok := m.info.Nodes[id]
+	return n, ok
+}
+
+// Ids returns the sorted ids of the members
+func (m *Membership) Ids() []string {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	ids := make([]string, 0, len(m.info.Nodes))
+	for id := range m.info.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Add adds a node unless a node with its id is already a member
+func (m *Membership) Add(n *node.Node) bool {
+	m.mut.Lock()
+	if _, ok := m.info.Nodes[n.Id]; ok {
+		m.mut.Unlock()
+		return false
+	}
+	nodes := m.copyNodes()
+	nodes[n.Id] = clone(n)
+	m.info.Nodes = nodes
+	m.publish(Event{Type: EVENT_JOIN, Node: nodes[n.Id]})
+	m.mut.Unlock()
+
+	m.flush()
+	return true
+}
+
+// Remove removes a member, returns false if it was not one
+func (m *Membership) Remove(id string) bool {
+	m.mut.Lock()
+	removed, ok := m.info.Nodes[id]
+	if !ok {
+		m.mut.Unlock()
+		return false
+	}
+	nodes := m.copyNodes()
+	delete(nodes, id)
+	m.info.Nodes = nodes
+	m.publish(Event{Type: EVENT_LEAVE, Node: removed})
+	m.mut.Unlock()
+
+	m.flush()
+	return true
+}
+
+// Update changes a copy of a member with fn and replaces the member with it
+func (m *Membership) Update(id string, fn func(n *node.Node)) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	current, ok := m.info.Nodes[id]
+	if !ok {
+		return false
+	}
+	updated := clone(current)
+	fn(updated)
+	nodes := m.copyNodes()
+	nodes[id] = updated
+	m.info.Nodes = nodes
+	return true
+}
+
+// Replace adopts the members, placement and epoch of a cluster config,
+// publishing a join or leave for every node that differs
+func (m *Membership) Replace(members []*node.Node, placement *pb.Placement, epoch int64) {
+	m.mut.Lock()
+	nodes := make(map[string]*node.Node, len(members))
+	for _, n := range members {
+		nodes[n.Id] = clone(n)
+		if _, ok := m.info.Nodes[n.Id]; !ok {
+			m.publish(Event{Type: EVENT_JOIN, Node: nodes[n.Id]})
+		}
+	}
+	for id, n := range m.info.Nodes {
+		if _, ok := nodes[id]; !ok {
+			m.publish(Event{Type: EVENT_LEAVE, Node: n})
+		}
+	}
+	info := m.info
+	info.Nodes = nodes
+	info.SetPlacement(placement)
+	m.info = info
+	m.epoch = epoch
+	m.mut.Unlock()
+
+	m.flush()
+}
+
+// Leader returns the leader, NO_LEADER if there is none, and its term
+func (m *Membership) Leader() (string, int64) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.leaderId, m.term
+}
+
+// IsLeader reports whether id is the current leader
+func (m *Membership) IsLeader(id string) bool {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.leaderId == id
+}
+
+// SetLeader sets the leader and its term
+func (m *Membership) SetLeader(leaderId string, term int64) {
+	m.mut.Lock()
+	m.setLeader(leaderId, term)
+	m.mut.Unlock()
+	m.flush()
+}
+
+// AdoptLeader sets the leader unless term is older than the current term, or
+// is the current term and another node leads it, a term has one leader
+func (m *Membership) AdoptLeader(leaderId string, term int64) bool {
+	m.mut.Lock()
+	if term < m.term || (term == m.term && m.leaderId != NO_LEADER && m.leaderId != leaderId) {
+		m.mut.Unlock()
+		return false
+	}
+	m.setLeader(leaderId, term)
+	m.mut.Unlock()
+	m.flush()
+	return true
+}
+
+// ClearLeader forgets the leader if it is still leaderId
+func (m *Membership) ClearLeader(leaderId string) bool {
+	m.mut.Lock()
+	if m.leaderId != leaderId {
+		m.mut.Unlock()
+		return false
+	}
+	m.setLeader(NO_LEADER, m.term)
+	m.mut.Unlock()
+	m.flush()
+	return true
+}
+
+func (m *Membership) setLeader(leaderId string, term int64) {
+	if leaderId == m.leaderId && term == m.term {
+		return
+	}
+	changed := leaderId != m.leaderId
+	m.leaderId = leaderId
+	m.term = term
+	if changed {
+		m.publish(Event{Type: EVENT_LEADER, LeaderId: leaderId, Term: term})
+	}
+}
+
+// Subscribe returns a channel receiving every membership event
+func (m *Membership) Subscribe() <-chan Event {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	ch := make(chan Event, 256)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// copyNodes copies the node map before it is changed. Caller holds the lock.
+func (m *Membership) copyNodes() map[string]*node.Node {
+	nodes := make(map[string]*node.Node, len(m.info.Nodes)+1)
+	for id, n := range m.info.Nodes {
+		nodes[id] = n
+	}
+	return nodes
+}
+
+// publish queues an event for the subscribers. Caller holds the lock.
+func (m *Membership) publish(event Event) {
+	if len(m.subscribers) > 0 {
+		m.pending = append(m.pending, event)
+	}
+}
+
+// flush delivers the queued events outside the lock
+func (m *Membership) flush() {
+	m.deliverMut.Lock()
+	defer m.deliverMut.Unlock()
+
+	m.mut.Lock()
+	events := m.pending
+	m.pending = nil
+	subscribers := append([]chan Event{}, m.subscribers...)
+	m.mut.Unlock()
+
+	for _, event := range events {
+		for _, ch := range subscribers {
+			ch <- event
+		}
+	}
+}
+
+func clone(n *node.Node) *node.Node {
+	c := *n
+	return &c
+}
@@ -0,0 +1,111 @@
+package membership
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+)
+
+func TestEvents(t *testing.T) {
+	m := New(node.NodesInfo{Nodes: map[string]*node.Node{"node0": node.InitNode("node0", "localhost", 8080, 5005)}})
+	events := m.Subscribe()
+
+	if !m.Add(node.InitNode("node1", "localhost", 8081, 5006)) || m.Add(node.InitNode("node1", "localhost", 8081, 5006)) {
+		t.Errorf("expected node1 to be added once")
+	}
+	if e := <-events; e.Type != EVENT_JOIN || e.Node.Id != "node1" {
+		t.Errorf("expected node1 to join, got %s %v", e.Type, e.Node)
+	}
+
+	// a replaced config publishes the difference with the current members
+	m.Replace([]*node.Node{node.InitNode("node1", "localhost", 8081, 5006), node.InitNode("node2", "localhost", 8082, 5007)}, nil, 1)
+	got := map[string]EventType{}
+	for i := 0; i < 2; i++ {
+		e := <-events
+		got[e.Node.Id] = e.Type
+	}
+	if got["node2"] != EVENT_JOIN || got["node0"] != EVENT_LEAVE {
+		t.Errorf("expected node2 to join and node0 to leave, got %v", got)
+	}
+
+	if !m.Remove("node1") || m.Remove("node1") {
+		t.Errorf("expected node1 to be removed once")
+	}
+	if e := <-events; e.Type != EVENT_LEAVE || e.Node.Id != "node1" {
+		t.Errorf("expected node1 to leave, got %s %v", e.Type, e.Node)
+	}
+}
+
+func TestLeader(t *testing.T) {
+	m := New(node.NodesInfo{Nodes: map[string]*node.Node{}})
+	if leaderId, _ := m.Leader(); leaderId != NO_LEADER {
+		t.Errorf("expected no leader, got %s", leaderId)
+	}
+	events := m.Subscribe()
+
+	m.SetLeader("node0", 3)
+	if e := <-events; e.Type != EVENT_LEADER || e.LeaderId != "node0" || e.Term != 3 {
+		t.Errorf("expected node0 to lead term 3, got %v", e)
+	}
+	if m.AdoptLeader("node1", 2) {
+		t.Errorf("expected a leader of an older term to be refused")
+	}
+	if m.AdoptLeader("node1", 3) || !m.AdoptLeader("node0", 3) {
+		t.Errorf("expected only the current leader to be adopted for the current term")
+	}
+	if m.ClearLeader("node1") || !m.ClearLeader("node0") || m.IsLeader("node0") {
+		t.Errorf("expected only the current leader to be cleared")
+	}
+	if leaderId, term := m.Leader(); leaderId != NO_LEADER || term != 3 {
+		t.Errorf("expected no leader in term 3, got %s term %d", leaderId, term)
+	}
+}
+
+func TestSnapshotIsImmutable(t *testing.T) {
+	m := New(node.NodesInfo{Nodes: map[string]*node.Node{"node0": node.InitNode("node0", "localhost", 8080, 5005)}})
+	snapshot := m.Snapshot()
+
+	m.Add(node.InitNode("node1", "localhost", 8081, 5006))
+	m.Update("node0", func(n *node.Node) { n.Zone = "zone-a" })
+	if len(snapshot.Nodes) != 1 || snapshot.Nodes["node0"].Zone != "" {
+		t.Errorf("snapshot changed after updates: %v", snapshot.Nodes)
+	}
+	if n, _ := m.Get("node0"); n.Zone != "zone-a" || len(m.Ids()) != 2 {
+		t.Errorf("expected the update to be visible in new reads")
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	m := New(node.NodesInfo{Nodes: map[string]*node.Node{"node0": node.InitNode("node0", "localhost", 8080, 5005)}})
+
+	// concurrent changes never get the same epoch
+	epochs := make(chan int64, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, epoch := m.NextEpoch()
+			epochs <- epoch
+		}()
+	}
+	wg.Wait()
+	close(epochs)
+	seen := make(map[int64]bool)
+	for epoch := range epochs {
+		if seen[epoch] {
+			t.Errorf("epoch %d allocated twice", epoch)
+		}
+		seen[epoch] = true
+	}
+	if m.Epoch() != 100 {
+		t.Errorf("expected epoch 100, got %d", m.Epoch())
+	}
+
+	// an adopted config brings its epoch along with its members
+	m.Replace([]*node.Node{node.InitNode("node1", "localhost", 8081, 5006)}, nil, 7)
+	if info, epoch := m.Versioned(); epoch != 7 || len(info.Nodes) != 1 || info.Nodes["node1"] == nil {
+		t.Errorf("expected node1 at epoch 7, got %v at %d", info.Nodes, epoch)
+	}
+}
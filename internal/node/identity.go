@@ -0,0 +1,73 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const IDENTITY_FILE = "identity.json"
+
+// Identity of a node that survives restarts, kept in its data directory
+type Identity struct {
+	NodeId    string `json:"nodeId"`
+	ClusterId string `json:"clusterId"`
+}
+
+// LoadIdentity reads the identity persisted in dir, it is empty on first boot
+// or when dir is empty
+func LoadIdentity(dir string) (Identity, error) {
+	var identity Identity
+	if dir == "" {
+		return identity, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, IDENTITY_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return identity, nil
+	}
+	if err != nil {
+		return identity, err
+	}
+	err = json.Unmarshal(data, &identity)
+	return identity, err
+}
+
+// Save writes the identity to dir, replacing the previous one atomically
+func (identity Identity) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, IDENTITY_FILE)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// NewClusterId generates the id of a new cluster
+func NewClusterId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
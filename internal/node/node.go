@@ -3,25 +3,50 @@ package node
 
 import (
 	"encoding/json"
+	"fmt"
 	"hash/crc32"
 	"math/rand"
+	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	RING  = "ring"
+	SLOTS = "slots"
 )
 
 type NodesInfo struct {
-	Nodes map[string]*Node `json:"nodes"`
+	// Id shared by the nodes of the cluster, generated by the first leader if empty
+	ClusterId string           `json:"clusterId"`
+	Nodes     map[string]*Node `json:"nodes"`
+	// Number of fixed hash slots, 0 places keys on the consistent hash ring
+	Slots int `json:"slots"`
+	// Virtual nodes per unit of node weight on the hash ring
+	VirtualNodes int `json:"virtualNodes"`
+	// Name of the ring hasher, empty for the default
+	Hasher string `json:"hasher"`
+	// Number of nodes holding a copy of each key
+	ReplicationFactor int `json:"replicationFactor"`
 }
 
 type Node struct {
-	Id         string `json:"id"`
-	Host       string `json:"host"`
-	RestPort   int32  `json:"restPort"`
-	GrpcPort   int32  `json:"grpcPort"`
-	HashId     uint32
-	GrpcClient pb.CacheServiceClient
+	Id          string `json:"id"`
+	Host        string `json:"host"`
+	RestPort    int32  `json:"restPort"`
+	GrpcPort    int32  `json:"grpcPort"`
+	Zone        string `json:"zone"`
+	Weight      int32  `json:"weight"`
+	Priority    int32  `json:"priority"`
+	NeverLeader bool   `json:"neverLeader"`
+	Maintenance bool   `json:"maintenance"`
+	RestHost    string `json:"restHost"`
+	HashId      uint32
+	GrpcClient  pb.CacheServiceClient
 }
 
 func InitNode(Id string, host string, restPort int32, grpcPort int32) *Node {
@@ -30,22 +55,137 @@ func InitNode(Id string, host string, restPort int32, grpcPort int32) *Node {
 		Host:     host,
 		RestPort: restPort,
 		GrpcPort: grpcPort,
+		Weight:   1,
 		HashId:   GetHashId(Id),
 	}
 }
 
+// Convert protobuf node config to node
+func FromProto(n *pb.Node) *Node {
+	node := InitNode(n.Id, n.Host, n.RestPort, n.GrpcPort)
+	node.Zone = n.Zone
+	node.Priority = n.Priority
+	node.NeverLeader = n.NeverLeader
+	node.Maintenance = n.Maintenance
+	node.RestHost = n.RestHost
+	if n.Weight > 0 {
+		node.Weight = n.Weight
+	}
+	return node
+}
+
+// Convert node to protobuf node config
+func (node *Node) ToProto() *pb.Node {
+	return &pb.Node{
+		Id:          node.Id,
+		Host:        node.Host,
+		RestPort:    node.RestPort,
+		GrpcPort:    node.GrpcPort,
+		Zone:        node.Zone,
+		Weight:      node.Weight,
+		Priority:    node.Priority,
+		NeverLeader: node.NeverLeader,
+		Maintenance: node.Maintenance,
+		RestHost:    node.RestHost,
+	}
+}
+
+// SameConfig reports whether two nodes carry the same config, ignoring
+// runtime state such as the gRPC client
+func (node *Node) SameConfig(other *Node) bool {
+	return proto.Equal(node.ToProto(), other.ToProto())
+}
+
+// RestAddr returns the host:port of the REST API, which is served on the
+// gRPC host unless the node advertises another one
+func (node *Node) RestAddr() string {
+	host := node.RestHost
+	if host == "" {
+		host = node.Host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(node.RestPort)))
+}
+
+// CanLead reports whether a node may become leader, nodes in maintenance
+// only follow until they are back
+func (node *Node) CanLead() bool {
+	return !node.NeverLeader && !node.Maintenance
+}
+
+// Outranks reports whether node should lead rather than other: nodes that
+// may lead come first, then the higher priority, then the higher node id
+func (node *Node) Outranks(other *Node) bool {
+	if node.CanLead() != other.CanLead() {
+		return node.CanLead()
+	}
+	if node.Priority != other.Priority {
+		return node.Priority > other.Priority
+	}
+	return node.Id > other.Id
+}
+
+// Placement parameters served with the cluster config so every client builds the same ring
+func (info NodesInfo) Placement() *pb.Placement {
+	algorithm := RING
+	if info.Slots > 0 {
+		algorithm = SLOTS
+	}
+	return &pb.Placement{
+		Algorithm:         algorithm,
+		VirtualNodes:      int32(info.VirtualNodes),
+		Hasher:            info.Hasher,
+		Slots:             int32(info.Slots),
+		ReplicationFactor: int32(info.GetReplicationFactor()),
+	}
+}
+
+// Replication factor, at least 1
+func (info NodesInfo) GetReplicationFactor() int {
+	if info.ReplicationFactor < 1 {
+		return 1
+	}
+	return info.ReplicationFactor
+}
+
+// Apply placement parameters received from the cluster
+func (info *NodesInfo) SetPlacement(p *pb.Placement) {
+	if p == nil {
+		return
+	}
+	info.VirtualNodes = int(p.VirtualNodes)
+	info.Hasher = p.Hasher
+	info.Slots = int(p.Slots)
+	info.ReplicationFactor = int(p.ReplicationFactor)
+}
+
+// Validate rejects node weights the ring would ignore, a weight multiplies
+// the virtual nodes of a node so it needs virtualNodes above 0
+func (info NodesInfo) Validate() error {
+	if info.VirtualNodes > 0 {
+		return nil
+	}
+	for _, n := range info.Nodes {
+		if n.Weight > 1 {
+			return fmt.Errorf("node %s has weight %d but virtualNodes is 0, weights need virtual nodes", n.Id, n.Weight)
+		}
+	}
+	return nil
+}
+
+// LoadNodesConfig reads a config file. A config without nodes keeps its
+// other fields and has no members, nodes then join through seed discovery.
 func LoadNodesConfig(configFile string) NodesInfo {
 	file, _ := os.ReadFile(configFile)
 	nodesInfo := NodesInfo{}
 	_ = json.Unmarshal([]byte(file), &nodesInfo)
 
-	if len(nodesInfo.Nodes) == 0 {
-		nodesInfo = NodesInfo{Nodes: make(map[string]*Node)}
-		defaultNode := InitNode("node0", "localhost", 8080, 5005)
-		nodesInfo.Nodes[defaultNode.Id] = defaultNode
-	} else {
-		for _, nodeInfo := range nodesInfo.Nodes {
-			nodeInfo.HashId = GetHashId(nodeInfo.Id)
+	if nodesInfo.Nodes == nil {
+		nodesInfo.Nodes = make(map[string]*Node)
+	}
+	for _, nodeInfo := range nodesInfo.Nodes {
+		nodeInfo.HashId = GetHashId(nodeInfo.Id)
+		if nodeInfo.Weight <= 0 {
+			nodeInfo.Weight = 1
 		}
 	}
 	return nodesInfo
@@ -97,3 +237,13 @@ func (n Nodes) Swap(i, j int) {
 func (n Nodes) Less(i, j int) bool {
 	return n[i].HashId < n[j].HashId
 }
+
+// IsStaleConfig reports whether a cluster config is older than the one with
+// the given leader term and epoch. Configs are ordered by the term of the
+// leader that issued them first, then by epoch.
+func IsStaleConfig(cfg *pb.ClusterConfig, leaderTerm int64, epoch int64) bool {
+	if cfg.LeaderTerm != leaderTerm {
+		return cfg.LeaderTerm < leaderTerm
+	}
+	return cfg.Epoch < epoch
+}
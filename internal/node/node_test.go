@@ -0,0 +1,35 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigWithoutNodes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nodes.json")
+	if err := os.WriteFile(file, []byte(`{"clusterId": "c1", "slots": 64, "replicationFactor": 2, "nodes": {}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info := LoadNodesConfig(file)
+	if info.ClusterId != "c1" || info.Slots != 64 || info.ReplicationFactor != 2 {
+		t.Errorf("expected the cluster id and placement to be kept, got %+v", info)
+	}
+	if info.Nodes == nil || len(info.Nodes) != 0 {
+		t.Errorf("expected an empty node map, got %v", info.Nodes)
+	}
+}
+
+func TestWeightsNeedVirtualNodes(t *testing.T) {
+	heavy := InitNode("node1", "localhost", 8081, 5006)
+	heavy.Weight = 3
+	info := NodesInfo{Nodes: map[string]*Node{"node0": InitNode("node0", "localhost", 8080, 5005), "node1": heavy}}
+	if err := info.Validate(); err == nil {
+		t.Errorf("expected a weighted node without virtual nodes to be rejected")
+	}
+	info.VirtualNodes = 10
+	if err := info.Validate(); err != nil {
+		t.Errorf("expected weights with virtual nodes to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,748 @@
+// Raft consensus for the cluster config: nodes elect a leader after a
+// randomized election timeout, and the leader replicates a log of entries
+// that every node applies in order once a majority has stored them. The
+// voters are set by the application, which derives them from the entries it
+// applies, so membership changes should add or remove one node at a time.
+// Once enough entries are applied, the log is compacted into a snapshot that
+// keeps the data of the last applied entry, which suits an application whose
+// entries each replace its whole state, like cluster configs.
+package raft
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+type State int32
+
+const (
+	FOLLOWER State = iota
+	CANDIDATE
+	LEADER
+)
+
+func (s State) String() string {
+	switch s {
+	case FOLLOWER:
+		return "follower"
+	case CANDIDATE:
+		return "candidate"
+	default:
+		return "leader"
+	}
+}
+
+// Max number of entries sent in a single append
+const MAX_APPEND_ENTRIES = 64
+
+var (
+	ErrNotLeader     = errors.New("raft: node is not the leader")
+	ErrLost          = errors.New("raft: entry was replaced by a newer leader")
+	ErrTransferring  = errors.New("raft: leadership transfer in progress")
+	ErrNoSuccessor   = errors.New("raft: no follower can take over leadership")
+	ErrNeverCampaign = errors.New("raft: node never campaigns")
+	ErrCompacted     = errors.New("raft: entry was compacted into a snapshot")
+)
+
+type Config struct {
+	// How often the leader sends appends to every follower
+	HeartbeatInterval time.Duration
+	// A follower that hears from no leader starts an election after a
+	// random timeout between this and twice this
+	ElectionTimeout time.Duration
+	// How long to wait for a vote or append reply
+	RPCTimeout time.Duration
+	// The node votes and follows but never starts an election
+	NeverCampaign bool
+	// Applied entries kept in the log before they are compacted into a
+	// snapshot, 0 never compacts
+	SnapshotThreshold int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HeartbeatInterval: 200 * time.Millisecond,
+		ElectionTimeout:   1500 * time.Millisecond,
+		RPCTimeout:        500 * time.Millisecond,
+		SnapshotThreshold: 64,
+	}
+}
+
+// Transport sends votes and appends to other nodes
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *pb.VoteRequest) (*pb.VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error)
+	TimeoutNow(ctx context.Context, peer string, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error)
+}
+
+// FSM receives committed entries and leader changes, in order and outside the node lock
+type FSM interface {
+	Apply(entry *pb.RaftEntry)
+	LeaderChanged(leaderId string, term int64)
+}
+
+type leaderChange struct {
+	leaderId string
+	term     int64
+}
+
+type Node struct {
+	mut       sync.Mutex
+	id        string
+	config    Config
+	transport Transport
+	storage   Storage
+	fsm       FSM
+
+	state    State
+	term     int64
+	votedFor string
+	// log[0] is the snapshot the log starts from, log[i] has index log[0].Index+i
+	log         []*pb.RaftEntry
+	commitIndex int64
+	lastApplied int64
+	leaderId    string
+	// voters other than this node
+	peers    []string
+	deadline time.Time
+	// the leader refuses proposals until then while it hands over leadership
+	transferUntil time.Time
+
+	nextIndex   map[string]int64
+	matchIndex  map[string]int64
+	lastContact map[string]time.Time
+	inflight    map[string]bool
+
+	// leader changes produced under the lock, delivered with the entries
+	pending   []leaderChange
+	applyMut  sync.Mutex
+	committed chan struct{}
+}
+
+// New restores a node from storage, it stays a follower until Run is called
+func New(id string, peers []string, transport Transport, storage Storage, fsm FSM, config Config) (*Node, error) {
+	state, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	// a snapshot only holds committed entries, the first flush applies it
+	snapshot := state.Snapshot
+	if snapshot == nil {
+		snapshot = &pb.RaftEntry{}
+	}
+	n := &Node{
+		id:          id,
+		config:      config,
+		transport:   transport,
+		storage:     storage,
+		fsm:         fsm,
+		term:        state.Term,
+		votedFor:    state.VotedFor,
+		log:         append([]*pb.RaftEntry{snapshot}, state.Entries...),
+		commitIndex: snapshot.Index,
+		nextIndex:   make(map[string]int64),
+		matchIndex:  make(map[string]int64),
+		lastContact: make(map[string]time.Time),
+		inflight:    make(map[string]bool),
+		committed:   make(chan struct{}),
+	}
+	n.setPeers(peers)
+	n.resetDeadline(time.Now())
+	return n, nil
+}
+
+// SetPeers replaces the voters, usually after applying a membership change
+func (n *Node) SetPeers(ids []string) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.setPeers(ids)
+}
+
+func (n *Node) setPeers(ids []string) {
+	n.peers = n.peers[:0]
+	for _, id := range ids {
+		if id == n.id {
+			continue
+		}
+		n.peers = append(n.peers, id)
+		if _, ok := n.nextIndex[id]; !ok {
+			n.nextIndex[id] = n.lastIndex() + 1
+			n.lastContact[id] = time.Now()
+		}
+	}
+}
+
+// Leader returns the current leader, empty if unknown, and the current term
+func (n *Node) Leader() (string, int64) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return n.leaderId, n.term
+}
+
+func (n *Node) State() State {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return n.state
+}
+
+// Run sends heartbeats as leader and starts elections as follower until stop is closed
+func (n *Node) Run(stop <-chan bool) {
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		n.Tick(time.Now())
+	}
+}
+
+// Tick replicates to every follower if this node leads, otherwise it starts
+// an election once the election timeout passed without hearing from a leader
+func (n *Node) Tick(now time.Time) {
+	n.mut.Lock()
+	if n.state == LEADER {
+		if !n.hasQuorumContact(now) {
+			// a leader cut off from the majority cannot commit, let the others move on
+			n.follow(n.term, "")
+			n.resetDeadline(now)
+			n.mut.Unlock()
+			n.flush()
+			return
+		}
+		n.mut.Unlock()
+		n.broadcast()
+		return
+	}
+	expired := now.After(n.deadline)
+	n.mut.Unlock()
+
+	if expired && !n.config.NeverCampaign {
+		n.campaign()
+	}
+}
+
+// Propose appends data to the log of the leader and returns its index and term.
+// The entry is applied once committed, use Wait to block until then.
+func (n *Node) Propose(data []byte) (int64, int64, error) {
+	n.mut.Lock()
+	if n.state != LEADER {
+		n.mut.Unlock()
+		return 0, 0, ErrNotLeader
+	}
+	if time.Now().Before(n.transferUntil) {
+		n.mut.Unlock()
+		return 0, 0, ErrTransferring
+	}
+	entry := &pb.RaftEntry{Term: n.term, Index: n.lastIndex() + 1, Data: data}
+	n.log = append(n.log, entry)
+	if err := n.storage.Append([]*pb.RaftEntry{entry}); err != nil {
+		n.log = n.log[:len(n.log)-1]
+		n.mut.Unlock()
+		return 0, 0, err
+	}
+	n.advanceCommit()
+	n.mut.Unlock()
+
+	n.flush()
+	go n.broadcast()
+	return entry.Index, entry.Term, nil
+}
+
+// Wait blocks until the entry at index is committed, or returns ErrLost if
+// a new leader replaced it. Returns ErrCompacted if the entry was compacted
+// before its term could be checked.
+func (n *Node) Wait(ctx context.Context, index int64, term int64) error {
+	for {
+		n.mut.Lock()
+		if index < n.log[0].Index {
+			n.mut.Unlock()
+			return ErrCompacted
+		}
+		if index > n.lastIndex() || n.entry(index).Term != term {
+			n.mut.Unlock()
+			return ErrLost
+		}
+		if n.commitIndex >= index {
+			n.mut.Unlock()
+			return nil
+		}
+		committed := n.committed
+		n.mut.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-committed:
+		}
+	}
+}
+
+// TransferLeadership hands leadership to the most caught up of candidates,
+// the first one on ties. The leader stops taking proposals, replicates until
+// that follower holds its whole log, then tells it to start an election
+// right away. Returns the id of the follower, which wins the election with
+// the next term unless it fails on the way.
+func (n *Node) TransferLeadership(ctx context.Context, candidates []string) (string, error) {
+	n.mut.Lock()
+	if n.state != LEADER {
+		n.mut.Unlock()
+		return "", ErrNotLeader
+	}
+	target := ""
+	for _, id := range candidates {
+		if slices.Contains(n.peers, id) && (target == "" || n.matchIndex[id] > n.matchIndex[target]) {
+			target = id
+		}
+	}
+	n.mut.Unlock()
+	if target == "" {
+		return "", ErrNoSuccessor
+	}
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	var req *pb.TimeoutNowRequest
+	for {
+		n.mut.Lock()
+		if n.state != LEADER {
+			n.mut.Unlock()
+			return "", ErrNotLeader
+		}
+		n.transferUntil = time.Now().Add(n.config.ElectionTimeout)
+		if n.matchIndex[target] >= n.lastIndex() {
+			req = &pb.TimeoutNowRequest{Term: n.term, LeaderId: n.id}
+		}
+		n.mut.Unlock()
+		if req != nil {
+			break
+		}
+
+		go n.replicate(target)
+		select {
+		case <-ctx.Done():
+			n.abortTransfer()
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	rpcCtx, cancel := context.WithTimeout(ctx, n.config.RPCTimeout)
+	res, err := n.transport.TimeoutNow(rpcCtx, target, req)
+	cancel()
+	if err != nil {
+		n.abortTransfer()
+		return "", err
+	}
+	if res.Term > req.Term {
+		n.mut.Lock()
+		n.follow(res.Term, "")
+		n.persistState()
+		n.mut.Unlock()
+		n.flush()
+		return "", ErrNotLeader
+	}
+	return target, nil
+}
+
+func (n *Node) abortTransfer() {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.transferUntil = time.Time{}
+}
+
+// HandleTimeoutNow starts an election right away on request of a leader
+// handing over leadership, without waiting for the election timeout
+func (n *Node) HandleTimeoutNow(req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	n.mut.Lock()
+	term := n.term
+	n.mut.Unlock()
+	if req.Term < term {
+		return &pb.TimeoutNowResponse{Term: term}, nil
+	}
+	if n.config.NeverCampaign {
+		return nil, ErrNeverCampaign
+	}
+	go n.campaign()
+	return &pb.TimeoutNowResponse{Term: term}, nil
+}
+
+func (n *Node) campaign() {
+	n.mut.Lock()
+	n.state = CANDIDATE
+	n.term++
+	n.votedFor = n.id
+	n.setLeader("")
+	n.resetDeadline(time.Now())
+	if err := n.persistState(); err != nil {
+		n.mut.Unlock()
+		return
+	}
+
+	term := n.term
+	req := &pb.VoteRequest{Term: term, CandidateId: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	peers := append([]string{}, n.peers...)
+	if len(peers) == 0 {
+		n.becomeLeader()
+	}
+	n.mut.Unlock()
+	n.flush()
+
+	votes := 1
+	for _, peer := range peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.config.RPCTimeout)
+			res, err := n.transport.RequestVote(ctx, peer, req)
+			cancel()
+			if err != nil {
+				return
+			}
+
+			n.mut.Lock()
+			if res.Term > n.term {
+				n.follow(res.Term, "")
+				n.persistState()
+			} else if n.state == CANDIDATE && n.term == term && res.Granted {
+				votes++
+				if votes >= n.quorum() {
+					n.becomeLeader()
+				}
+			}
+			n.mut.Unlock()
+			n.flush()
+		}(peer)
+	}
+}
+
+// becomeLeader takes over the followers and appends an empty entry so that
+// entries of earlier terms get committed. A candidate that cannot store that
+// entry could not commit anything, it steps down instead. Caller holds the lock.
+func (n *Node) becomeLeader() {
+	entry := &pb.RaftEntry{Term: n.term, Index: n.lastIndex() + 1}
+	if err := n.storage.Append([]*pb.RaftEntry{entry}); err != nil {
+		n.follow(n.term, "")
+		return
+	}
+
+	n.state = LEADER
+	n.transferUntil = time.Time{}
+	n.setLeader(n.id)
+	now := time.Now()
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		n.lastContact[peer] = now
+	}
+	n.log = append(n.log, entry)
+	n.advanceCommit()
+	go n.broadcast()
+}
+
+// follow steps down to follower of term. Caller holds the lock and persists.
+func (n *Node) follow(term int64, leaderId string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+	}
+	n.state = FOLLOWER
+	n.transferUntil = time.Time{}
+	n.setLeader(leaderId)
+}
+
+func (n *Node) setLeader(leaderId string) {
+	if n.leaderId == leaderId {
+		return
+	}
+	n.leaderId = leaderId
+	n.pending = append(n.pending, leaderChange{leaderId: leaderId, term: n.term})
+}
+
+func (n *Node) broadcast() {
+	n.mut.Lock()
+	peers := append([]string{}, n.peers...)
+	n.mut.Unlock()
+	for _, peer := range peers {
+		go n.replicate(peer)
+	}
+}
+
+// replicate sends the entries a follower is missing, or a heartbeat if it
+// has them all. A follower missing compacted entries gets the snapshot first.
+func (n *Node) replicate(peer string) {
+	n.mut.Lock()
+	if n.state != LEADER || n.inflight[peer] {
+		n.mut.Unlock()
+		return
+	}
+	base := n.log[0].Index
+	next := min(max(n.nextIndex[peer], 1), n.lastIndex()+1)
+	var snapshot *pb.RaftEntry
+	if next <= base {
+		snapshot, next = n.log[0], base+1
+	}
+	prev := next - 1
+	entries := n.log[next-base : min(next+MAX_APPEND_ENTRIES, n.lastIndex()+1)-base]
+	req := &pb.AppendEntriesRequest{
+		Term:         n.term,
+		LeaderId:     n.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.entry(prev).Term,
+		Entries:      append([]*pb.RaftEntry{}, entries...),
+		LeaderCommit: n.commitIndex,
+		Snapshot:     snapshot,
+	}
+	n.inflight[peer] = true
+	n.mut.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.RPCTimeout)
+	res, err := n.transport.AppendEntries(ctx, peer, req)
+	cancel()
+
+	n.mut.Lock()
+	n.inflight[peer] = false
+	switch {
+	case err != nil:
+	case res.Term > n.term:
+		n.follow(res.Term, "")
+		n.persistState()
+	case n.state != LEADER || n.term != req.Term:
+	case res.Success:
+		n.lastContact[peer] = time.Now()
+		match := prev + int64(len(req.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = match + 1
+		n.advanceCommit()
+	default:
+		// the follower's log diverges, back off to its last index and retry on the next tick
+		n.lastContact[peer] = time.Now()
+		n.nextIndex[peer] = max(min(res.LastIndex+1, next-1), 1)
+	}
+	n.mut.Unlock()
+	n.flush()
+}
+
+// advanceCommit commits the newest entry of the current term stored on a
+// majority, along with every entry before it. Caller holds the lock.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.entry(index).Term == n.term; index-- {
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commit(index)
+			return
+		}
+	}
+}
+
+func (n *Node) commit(index int64) {
+	n.commitIndex = index
+	close(n.committed)
+	n.committed = make(chan struct{})
+}
+
+// HandleRequestVote grants the vote to a candidate whose log is at least as
+// up to date, once per term
+func (n *Node) HandleRequestVote(req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	n.mut.Lock()
+	defer n.flush()
+	defer n.mut.Unlock()
+
+	changed := false
+	if req.Term > n.term {
+		n.follow(req.Term, "")
+		changed = true
+	}
+	res := &pb.VoteResponse{Term: n.term}
+	upToDate := req.LastLogTerm > n.lastTerm() || (req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if req.Term == n.term && (n.votedFor == "" || n.votedFor == req.CandidateId) && upToDate {
+		n.votedFor = req.CandidateId
+		n.resetDeadline(time.Now())
+		res.Granted = true
+		changed = true
+	}
+	if changed {
+		if err := n.persistState(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// HandleAppendEntries stores the entries of the leader after checking that
+// the follower's log matches up to them, dropping any conflicting suffix. A
+// follower whose log does not match the snapshot of the leader replaces its
+// log with that snapshot.
+func (n *Node) HandleAppendEntries(req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	n.mut.Lock()
+	defer n.flush()
+	defer n.mut.Unlock()
+
+	if req.Term < n.term {
+		return &pb.AppendEntriesResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+	changed := req.Term > n.term
+	n.follow(req.Term, req.LeaderId)
+	n.resetDeadline(time.Now())
+	if changed {
+		if err := n.persistState(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &pb.AppendEntriesResponse{Term: n.term}
+	if !n.matches(req.PrevLogIndex, req.PrevLogTerm) {
+		if req.Snapshot == nil {
+			res.LastIndex = min(n.lastIndex(), req.PrevLogIndex-1)
+			return res, nil
+		}
+		if err := n.storage.Compact(req.Snapshot, nil); err != nil {
+			return nil, err
+		}
+		n.log = []*pb.RaftEntry{req.Snapshot}
+		if req.Snapshot.Index > n.commitIndex {
+			n.commit(req.Snapshot.Index)
+		}
+	}
+
+	// only the entries from the first conflicting or missing one on are stored,
+	// compacted entries are committed and match
+	for i, entry := range req.Entries {
+		index := req.PrevLogIndex + 1 + int64(i)
+		if index <= n.log[0].Index {
+			continue
+		}
+		if index <= n.lastIndex() {
+			if n.entry(index).Term == entry.Term {
+				continue
+			}
+			n.log = n.log[:index-n.log[0].Index]
+		}
+		n.log = append(n.log, req.Entries[i:]...)
+		if err := n.storage.Append(req.Entries[i:]); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	if last := req.PrevLogIndex + int64(len(req.Entries)); req.LeaderCommit > n.commitIndex && last > n.commitIndex {
+		n.commit(min(req.LeaderCommit, last))
+	}
+	res.Success = true
+	res.LastIndex = n.lastIndex()
+	return res, nil
+}
+
+// flush delivers leader changes and committed entries to the FSM outside the node lock
+func (n *Node) flush() {
+	n.applyMut.Lock()
+	defer n.applyMut.Unlock()
+	for {
+		n.mut.Lock()
+		changes := n.pending
+		n.pending = nil
+		var entries []*pb.RaftEntry
+		if n.commitIndex > n.lastApplied {
+			// entries compacted before being applied are covered by the snapshot
+			base := n.log[0].Index
+			first := max(n.lastApplied+1, base)
+			entries = append(entries, n.log[first-base:n.commitIndex-base+1]...)
+			n.lastApplied = n.commitIndex
+			n.compact()
+		}
+		n.mut.Unlock()
+
+		if len(changes) == 0 && len(entries) == 0 {
+			return
+		}
+		for _, change := range changes {
+			n.fsm.LeaderChanged(change.leaderId, change.term)
+		}
+		for _, entry := range entries {
+			if len(entry.Data) > 0 {
+				n.fsm.Apply(entry)
+			}
+		}
+	}
+}
+
+// compact replaces the applied entries with a snapshot once more than
+// SnapshotThreshold of them are kept. Caller holds the lock.
+func (n *Node) compact() {
+	base := n.log[0].Index
+	if n.config.SnapshotThreshold <= 0 || n.lastApplied-base <= n.config.SnapshotThreshold {
+		return
+	}
+	applied := n.log[:n.lastApplied-base+1]
+	last := applied[len(applied)-1]
+	snapshot := &pb.RaftEntry{Term: last.Term, Index: last.Index}
+	for _, entry := range applied {
+		if len(entry.Data) > 0 {
+			snapshot.Data = entry.Data
+		}
+	}
+	rest := n.log[len(applied):]
+	if err := n.storage.Compact(snapshot, rest); err != nil {
+		// the log stays whole, compaction is retried after the next commit
+		return
+	}
+	n.log = append([]*pb.RaftEntry{snapshot}, rest...)
+}
+
+// hasQuorumContact reports whether a majority answered within the election timeout
+func (n *Node) hasQuorumContact(now time.Time) bool {
+	count := 1
+	for _, peer := range n.peers {
+		if now.Sub(n.lastContact[peer]) < n.config.ElectionTimeout {
+			count++
+		}
+	}
+	return count >= n.quorum()
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) entry(index int64) *pb.RaftEntry {
+	return n.log[index-n.log[0].Index]
+}
+
+// matches reports whether the log holds an entry of term at index. Compacted
+// entries were committed, so they match the log of any leader.
+func (n *Node) matches(index int64, term int64) bool {
+	if index < n.log[0].Index {
+		return true
+	}
+	return index <= n.lastIndex() && n.entry(index).Term == term
+}
+
+func (n *Node) lastIndex() int64 {
+	return n.log[0].Index + int64(len(n.log)-1)
+}
+
+func (n *Node) lastTerm() int64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) resetDeadline(now time.Time) {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.deadline = now.Add(timeout)
+}
+
+func (n *Node) persistState() error {
+	return n.storage.SaveState(n.term, n.votedFor)
+}
@@ -0,0 +1,452 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+// in-memory network connecting nodes, nodes marked down drop every message
+type network struct {
+	mut   sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool
+}
+
+type memTransport struct {
+	net  *network
+	from string
+}
+
+func (n *network) get(from string, to string) (*Node, error) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if n.down[from] || n.down[to] {
+		return nil, errors.New("unreachable")
+	}
+	return n.nodes[to], nil
+}
+
+func (n *network) setDown(id string, down bool) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.down[id] = down
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, peer string, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	node, err := t.net.get(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(req)
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, peer string, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	node, err := t.net.get(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(req)
+}
+
+func (t *memTransport) TimeoutNow(ctx context.Context, peer string, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	node, err := t.net.get(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleTimeoutNow(req)
+}
+
+type testFSM struct {
+	mut     sync.Mutex
+	applied []string
+}
+
+func (f *testFSM) Apply(entry *pb.RaftEntry) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.applied = append(f.applied, string(entry.Data))
+}
+
+func (f *testFSM) LeaderChanged(leaderId string, term int64) {}
+
+func (f *testFSM) get() []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return append([]string{}, f.applied...)
+}
+
+func testConfig() Config {
+	return Config{HeartbeatInterval: 5 * time.Millisecond, ElectionTimeout: 50 * time.Millisecond, RPCTimeout: 20 * time.Millisecond}
+}
+
+func testCluster(t *testing.T, n int) (*network, []*Node, []*testFSM) {
+	return testClusterWith(t, n, testConfig())
+}
+
+func testClusterWith(t *testing.T, n int, config Config) (*network, []*Node, []*testFSM) {
+	net := &network{nodes: make(map[string]*Node), down: make(map[string]bool)}
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("node%d", i))
+	}
+	var nodes []*Node
+	var fsms []*testFSM
+	stop := make(chan bool)
+	for _, id := range ids {
+		fsm := &testFSM{}
+		node, err := New(id, ids, &memTransport{net: net, from: id}, &MemoryStorage{}, fsm, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.nodes[id] = node
+		nodes = append(nodes, node)
+		fsms = append(fsms, fsm)
+	}
+	for _, node := range nodes {
+		go node.Run(stop)
+	}
+	t.Cleanup(func() { close(stop) })
+	return net, nodes, fsms
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// leader returns the single node that leads among the reachable nodes, nil if there is none yet
+func leader(net *network, nodes []*Node) *Node {
+	var found *Node
+	for _, node := range nodes {
+		if net.down[node.id] || node.State() != LEADER {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = node
+	}
+	return found
+}
+
+func TestElectLeader(t *testing.T) {
+	net, nodes, _ := testCluster(t, 3)
+	waitFor(t, "a leader", func() bool { return leader(net, nodes) != nil })
+
+	id, term := leader(net, nodes).Leader()
+	waitFor(t, "every node to follow the leader", func() bool {
+		for _, node := range nodes {
+			if leaderId, _ := node.Leader(); leaderId != id {
+				return false
+			}
+		}
+		return true
+	})
+	if term < 1 {
+		t.Errorf("expected a term above 0, got %d", term)
+	}
+}
+
+func TestReplicateEntries(t *testing.T) {
+	net, nodes, fsms := testCluster(t, 3)
+	waitFor(t, "a leader", func() bool { return leader(net, nodes) != nil })
+
+	l := leader(net, nodes)
+	for _, data := range []string{"a", "b", "c"} {
+		index, term, err := l.Propose([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = l.Wait(ctx, index, term)
+		cancel()
+		if err != nil {
+			t.Fatalf("entry %s not committed: %v", data, err)
+		}
+	}
+
+	waitFor(t, "every node to apply the entries", func() bool {
+		for _, fsm := range fsms {
+			if fmt.Sprint(fsm.get()) != "[a b c]" {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, node := range nodes {
+		if node != l {
+			if _, _, err := node.Propose([]byte("d")); err != ErrNotLeader {
+				t.Errorf("expected follower %s to refuse proposals, got %v", node.id, err)
+			}
+		}
+	}
+}
+
+func TestPartitionedLeaderStepsDown(t *testing.T) {
+	net, nodes, fsms := testCluster(t, 3)
+	waitFor(t, "a leader", func() bool { return leader(net, nodes) != nil })
+
+	old := leader(net, nodes)
+	_, oldTerm := old.Leader()
+	net.setDown(old.id, true)
+
+	// the cut off leader cannot commit anything
+	index, term, err := old.Propose([]byte("lost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "a new leader", func() bool { return leader(net, nodes) != nil })
+	l := leader(net, nodes)
+	if _, newTerm := l.Leader(); newTerm <= oldTerm {
+		t.Errorf("expected the new leader term %d to be above %d", newTerm, oldTerm)
+	}
+	if _, _, err := l.Propose([]byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+
+	net.setDown(old.id, false)
+	waitFor(t, "the old leader to follow", func() bool {
+		leaderId, _ := old.Leader()
+		return leaderId == l.id && old.State() == FOLLOWER
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := old.Wait(ctx, index, term); err != ErrLost {
+		t.Errorf("expected the entry of the old leader to be lost, got %v", err)
+	}
+	waitFor(t, "every node to apply the new leader's entry", func() bool {
+		for _, fsm := range fsms {
+			if fmt.Sprint(fsm.get()) != "[kept]" {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestTransferLeadership(t *testing.T) {
+	net, nodes, fsms := testCluster(t, 3)
+	waitFor(t, "a leader", func() bool { return leader(net, nodes) != nil })
+
+	old := leader(net, nodes)
+	_, oldTerm := old.Leader()
+	index, term, err := old.Propose([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := old.Wait(ctx, index, term); err != nil {
+		t.Fatal(err)
+	}
+
+	var candidates []string
+	for _, node := range nodes {
+		if node != old {
+			candidates = append(candidates, node.id)
+		}
+	}
+	successor, err := old.TransferLeadership(ctx, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the successor to lead", func() bool {
+		l := leader(net, nodes)
+		return l != nil && l.id == successor
+	})
+	if _, newTerm := old.Leader(); newTerm <= oldTerm || old.State() != FOLLOWER {
+		t.Errorf("expected the old leader to follow a term above %d, got %v term %d", oldTerm, old.State(), newTerm)
+	}
+	if _, _, err := old.Propose([]byte("b")); err != ErrNotLeader {
+		t.Errorf("expected the old leader to refuse proposals, got %v", err)
+	}
+
+	l := leader(net, nodes)
+	if _, _, err := l.Propose([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "every node to apply the entries of both leaders", func() bool {
+		for _, fsm := range fsms {
+			if fmt.Sprint(fsm.get()) != "[a b]" {
+				return false
+			}
+		}
+		return true
+	})
+
+	if _, err := l.TransferLeadership(ctx, []string{l.id, "node9"}); err != ErrNoSuccessor {
+		t.Errorf("expected no successor among the leader and unknown nodes, got %v", err)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*FileStorage, *pb.RaftState) {
+		storage, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { storage.Close() })
+		state, err := storage.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storage, state
+	}
+	logOf := func(state *pb.RaftState) string {
+		var out []string
+		for _, entry := range state.Entries {
+			out = append(out, fmt.Sprintf("%d/%d:%s", entry.Index, entry.Term, entry.Data))
+		}
+		return fmt.Sprint(out)
+	}
+
+	storage, state := open()
+	if state.Term != 0 || len(state.Entries) != 0 {
+		t.Fatalf("expected empty state, got %v", state)
+	}
+
+	if err := storage.SaveState(3, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	entries := []*pb.RaftEntry{{Term: 1, Index: 1, Data: []byte("a")}, {Term: 1, Index: 2, Data: []byte("b")}, {Term: 1, Index: 3, Data: []byte("c")}}
+	for _, entry := range entries {
+		if err := storage.Append([]*pb.RaftEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a new leader replaces the entries from index 2 on
+	if err := storage.Append([]*pb.RaftEntry{{Term: 3, Index: 2, Data: []byte("d")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, state = open()
+	if state.Term != 3 || state.VotedFor != "node1" || logOf(state) != "[1/1:a 2/3:d]" {
+		t.Errorf("expected term 3, the vote and the replaced log back, got %v %s", state, logOf(state))
+	}
+
+	// a record torn by a crash is dropped, later appends follow the last whole entry
+	info, err := os.Stat(filepath.Join(dir, LOG_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, LOG_FILE), info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	storage, state = open()
+	if logOf(state) != "[1/1:a]" {
+		t.Errorf("expected the torn entry to be dropped, got %s", logOf(state))
+	}
+	if err := storage.Append([]*pb.RaftEntry{{Term: 3, Index: 2, Data: []byte("e")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, state = open(); logOf(state) != "[1/1:a 2/3:e]" {
+		t.Errorf("expected the entry appended after the torn one, got %s", logOf(state))
+	}
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if err := storage.Append([]*pb.RaftEntry{{Term: 1, Index: i, Data: []byte(fmt.Sprint(i))}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Compact(&pb.RaftEntry{Term: 1, Index: 2, Data: []byte("2")}, []*pb.RaftEntry{{Term: 1, Index: 3, Data: []byte("3")}}); err != nil {
+		t.Fatal(err)
+	}
+	// appends after compaction replace entries by index past the snapshot
+	if err := storage.Append([]*pb.RaftEntry{{Term: 2, Index: 3, Data: []byte("a")}, {Term: 2, Index: 4, Data: []byte("b")}}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// a node restarted from the compacted log applies the snapshot, then the entries after it
+	storage, err = NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	fsm := &testFSM{}
+	node, err := New("node0", []string{"node0"}, &memTransport{}, storage, fsm, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.log[0].Index != 2 || node.lastIndex() != 4 {
+		t.Fatalf("expected the log to start from the snapshot at 2 and end at 4, got %d to %d", node.log[0].Index, node.lastIndex())
+	}
+	stop := make(chan bool)
+	defer close(stop)
+	go node.Run(stop)
+	waitFor(t, "the restarted node to apply its log", func() bool { return fmt.Sprint(fsm.get()) == "[2 a b]" })
+}
+
+func TestCompactLog(t *testing.T) {
+	config := testConfig()
+	config.SnapshotThreshold = 4
+	net, nodes, fsms := testClusterWith(t, 3, config)
+	waitFor(t, "a leader", func() bool { return leader(net, nodes) != nil })
+
+	l := leader(net, nodes)
+	var lagging *Node
+	var laggingFSM *testFSM
+	for i, node := range nodes {
+		if node != l {
+			lagging, laggingFSM = node, fsms[i]
+			break
+		}
+	}
+	net.setDown(lagging.id, true)
+
+	for i := 0; i < 20; i++ {
+		index, term, err := l.Propose([]byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = l.Wait(ctx, index, term)
+		cancel()
+		if err != nil {
+			t.Fatalf("entry %d not committed: %v", i, err)
+		}
+	}
+	l.mut.Lock()
+	base, kept := l.log[0].Index, len(l.log)
+	l.mut.Unlock()
+	if base == 0 || kept > int(config.SnapshotThreshold)+2 {
+		t.Errorf("expected the leader log to be compacted, got %d entries after index %d", kept, base)
+	}
+
+	// the follower missed compacted entries, it catches up from the snapshot
+	net.setDown(lagging.id, false)
+	waitFor(t, "the lagging follower to apply the last entry", func() bool {
+		applied := laggingFSM.get()
+		return len(applied) > 0 && applied[len(applied)-1] == "19"
+	})
+	lagging.mut.Lock()
+	defer lagging.mut.Unlock()
+	if lagging.log[0].Index == 0 {
+		t.Errorf("expected the lagging follower to start its log from a snapshot")
+	}
+}
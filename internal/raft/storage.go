@@ -0,0 +1,299 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	STATE_FILE = "raft.state"
+	LOG_FILE   = "raft.log"
+)
+
+// Storage keeps the term, vote and log, which must survive restarts for
+// elections to stay safe. The log starts from a snapshot, an empty one at
+// index 0 until the log is first compacted.
+type Storage interface {
+	Load() (*pb.RaftState, error)
+	// SaveState stores the term and vote
+	SaveState(term int64, votedFor string) error
+	// Append stores entries, replacing every stored entry from the index of
+	// the first one on
+	Append(entries []*pb.RaftEntry) error
+	// Compact replaces the whole log with the snapshot followed by entries
+	Compact(snapshot *pb.RaftEntry, entries []*pb.RaftEntry) error
+}
+
+// MemoryStorage loses its state with the process, a restarted node rejoins
+// with an empty log and catches up from the leader
+type MemoryStorage struct {
+	mut      sync.Mutex
+	term     int64
+	votedFor string
+	snapshot *pb.RaftEntry
+	entries  []*pb.RaftEntry
+}
+
+func (m *MemoryStorage) Load() (*pb.RaftState, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	state := &pb.RaftState{Term: m.term, VotedFor: m.votedFor, Snapshot: &pb.RaftEntry{}}
+	if m.snapshot != nil {
+		state.Snapshot = proto.Clone(m.snapshot).(*pb.RaftEntry)
+	}
+	for _, entry := range m.entries {
+		state.Entries = append(state.Entries, proto.Clone(entry).(*pb.RaftEntry))
+	}
+	return state, nil
+}
+
+func (m *MemoryStorage) SaveState(term int64, votedFor string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.term, m.votedFor = term, votedFor
+	return nil
+}
+
+func (m *MemoryStorage) Append(entries []*pb.RaftEntry) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	m.entries = m.entries[:min(int(entries[0].Index-m.snapshot.GetIndex())-1, len(m.entries))]
+	for _, entry := range entries {
+		m.entries = append(m.entries, proto.Clone(entry).(*pb.RaftEntry))
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Compact(snapshot *pb.RaftEntry, entries []*pb.RaftEntry) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.snapshot = proto.Clone(snapshot).(*pb.RaftEntry)
+	m.entries = m.entries[:0]
+	for _, entry := range entries {
+		m.entries = append(m.entries, proto.Clone(entry).(*pb.RaftEntry))
+	}
+	return nil
+}
+
+// FileStorage keeps the term and vote in a file that is replaced atomically,
+// and the log in a file that entries are appended to. The first record of the
+// log file is the snapshot the log starts from, and each record is the length
+// of the entry as 4 bytes big endian followed by the entry. Compacting writes
+// a new log file and renames it over the old one.
+type FileStorage struct {
+	mut       sync.Mutex
+	statePath string
+	log       *os.File
+	// index of the snapshot the log starts from
+	base int64
+	// offsets[i] is where the record with index base+i starts in the log file
+	offsets []int64
+	size    int64
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, LOG_FILE), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{statePath: filepath.Join(dir, STATE_FILE), log: log}, nil
+}
+
+func (f *FileStorage) Close() error {
+	return f.log.Close()
+}
+
+func (f *FileStorage) Load() (*pb.RaftState, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	state := &pb.RaftState{}
+	data, err := os.ReadFile(f.statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := proto.Unmarshal(data, state); err != nil {
+			return nil, err
+		}
+	}
+
+	records, err := f.readLog()
+	if err != nil {
+		return nil, err
+	}
+	state.Snapshot, state.Entries = &pb.RaftEntry{}, nil
+	if len(records) > 0 {
+		state.Snapshot, state.Entries = records[0], records[1:]
+	}
+	f.base = state.Snapshot.Index
+	return state, nil
+}
+
+func (f *FileStorage) SaveState(term int64, votedFor string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.saveState(term, votedFor)
+}
+
+func (f *FileStorage) Append(entries []*pb.RaftEntry) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.append(entries)
+}
+
+func (f *FileStorage) Compact(snapshot *pb.RaftEntry, entries []*pb.RaftEntry) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	buf, offsets, err := encodeRecords(append([]*pb.RaftEntry{snapshot}, entries...), 0)
+	if err != nil {
+		return err
+	}
+	tmp := f.log.Name() + ".tmp"
+	log, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := log.Write(buf); err != nil {
+		log.Close()
+		return err
+	}
+	if err := log.Sync(); err != nil {
+		log.Close()
+		return err
+	}
+	// the open file stays valid through the rename and becomes the log file
+	if err := os.Rename(tmp, f.log.Name()); err != nil {
+		log.Close()
+		return err
+	}
+	f.log.Close()
+	f.log = log
+	f.base, f.offsets, f.size = snapshot.Index, offsets, int64(len(buf))
+	return nil
+}
+
+func (f *FileStorage) saveState(term int64, votedFor string) error {
+	data, err := proto.Marshal(&pb.RaftState{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+
+	tmp := f.statePath + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.statePath)
+}
+
+// append writes the entries after the ones they do not replace. Caller holds the lock.
+func (f *FileStorage) append(entries []*pb.RaftEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	offsets, size := f.offsets, f.size
+	if first := entries[0].Index - f.base; first < int64(len(offsets)) {
+		offsets, size = offsets[:first], offsets[first]
+	}
+	if len(offsets) == 0 {
+		// a new log starts with the empty snapshot at index 0
+		entries = append([]*pb.RaftEntry{{}}, entries...)
+	}
+
+	buf, added, err := encodeRecords(entries, size)
+	if err != nil {
+		return err
+	}
+
+	if size < f.size {
+		if err := f.log.Truncate(size); err != nil {
+			return err
+		}
+		f.offsets, f.size = offsets, size
+	}
+	if _, err := f.log.WriteAt(buf, size); err != nil {
+		return err
+	}
+	if err := f.log.Sync(); err != nil {
+		return err
+	}
+	f.offsets = append(offsets, added...)
+	f.size = size + int64(len(buf))
+	return nil
+}
+
+// encodeRecords encodes entries as log records written at offset, and
+// returns where each of them starts
+func encodeRecords(entries []*pb.RaftEntry, offset int64) ([]byte, []int64, error) {
+	var buf []byte
+	var offsets []int64
+	for _, entry := range entries {
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		offsets = append(offsets, offset+int64(len(buf)))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, offsets, nil
+}
+
+// readLog reads every record of the log file, the snapshot first. Caller holds the lock.
+func (f *FileStorage) readLog() ([]*pb.RaftEntry, error) {
+	data, err := os.ReadFile(f.log.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*pb.RaftEntry
+	f.offsets = f.offsets[:0]
+	offset := int64(0)
+	for int64(len(data))-offset >= 4 {
+		end := offset + 4 + int64(binary.BigEndian.Uint32(data[offset:]))
+		if end > int64(len(data)) {
+			break
+		}
+		entry := &pb.RaftEntry{}
+		if err := proto.Unmarshal(data[offset+4:end], entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		f.offsets = append(f.offsets, offset)
+		offset = end
+	}
+
+	// a record torn by a crash was never synced and acknowledged, drop it
+	f.size = offset
+	if offset < int64(len(data)) {
+		if err := f.log.Truncate(offset); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
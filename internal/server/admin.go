@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Admin requests carry "Bearer <token>" in this gRPC metadata key or HTTP header
+const ADMIN_AUTH_HEADER = "authorization"
+
+// Admin service for operators. Every operation runs on the leader, other
+// nodes forward it there.
+type adminServer struct {
+	s *CacheServer
+	pb.UnimplementedAdminServiceServer
+}
+
+// Set the token admin requests must present, the admin API refuses every
+// request while no token is set
+func (s *CacheServer) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// authorize checks the bearer token of an admin request
+func (s *CacheServer) authorize(header string) error {
+	if s.adminToken == "" {
+		return status.Errorf(codes.PermissionDenied, "admin API is disabled on node %s", s.nodeId)
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		return status.Errorf(codes.Unauthenticated, "invalid admin token")
+	}
+	return nil
+}
+
+func (a *adminServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := ""
+	if values := md.Get(ADMIN_AUTH_HEADER); len(values) > 0 {
+		header = values[0]
+	}
+	return a.s.authorize(header)
+}
+
+func (a *adminServer) AddNode(ctx context.Context, req *pb.Node) (*pb.ClusterConfig, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return a.s.adminAddNode(ctx, req)
+}
+
+func (a *adminServer) RemoveNode(ctx context.Context, req *pb.RemoveNodeRequest) (*pb.ClusterConfig, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return a.s.adminRemoveNode(ctx, req)
+}
+
+func (a *adminServer) SetMaintenance(ctx context.Context, req *pb.MaintenanceRequest) (*pb.ClusterConfig, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return a.s.adminSetMaintenance(ctx, req)
+}
+
+func (a *adminServer) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.LeaderResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return a.s.adminTransferLeadership(ctx, req)
+}
+
+// forwardAdmin runs an admin request on the leader, unless this node is the
+// leader. Reports whether the request was forwarded.
+func forwardAdmin[Req any, Res any](s *CacheServer, ctx context.Context, req Req, call func(pb.AdminServiceClient, context.Context, Req, ...grpc.CallOption) (Res, error)) (Res, bool, error) {
+	var res Res
+	leaderId, _ := s.members.Leader()
+	if leaderId == s.nodeId {
+		return res, false, nil
+	}
+	leader, ok := s.members.Get(leaderId)
+	if !ok {
+		return res, true, status.Errorf(codes.Unavailable, "no leader to run the admin request")
+	}
+	conn, err := s.dialNode(leader.Host, int(leader.GrpcPort))
+	if err != nil {
+		return res, true, status.Errorf(codes.Unavailable, "unable to reach leader %s: %v", leader.Id, err)
+	}
+	defer conn.Close()
+
+	s.logger.Infof("Forwarding admin request to leader %s", leader.Id)
+	ctx = metadata.AppendToOutgoingContext(ctx, ADMIN_AUTH_HEADER, "Bearer "+s.adminToken)
+	res, err = call(pb.NewAdminServiceClient(conn), ctx, req)
+	return res, true, err
+}
+
+// adminAddNode adds a node to the cluster config
+func (s *CacheServer) adminAddNode(ctx context.Context, req *pb.Node) (*pb.ClusterConfig, error) {
+	if res, forwarded, err := forwardAdmin(s, ctx, req, pb.AdminServiceClient.AddNode); forwarded {
+		return res, err
+	}
+	if req.Id == "" || req.Host == "" || req.GrpcPort <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "node needs an id, a host and a gRPC port")
+	}
+	if !s.members.Add(node.FromProto(req)) {
+		return nil, status.Errorf(codes.AlreadyExists, "node %s is already part of the cluster", req.Id)
+	}
+	s.logger.Infof("Admin added node %s", req.Id)
+	s.updateClusterConfigInternal()
+	return s.clusterConfig(), nil
+}
+
+// adminRemoveNode removes a node from the cluster config. A decommissioned
+// node first hands its keys off to the nodes that take them over.
+func (s *CacheServer) adminRemoveNode(ctx context.Context, req *pb.RemoveNodeRequest) (*pb.ClusterConfig, error) {
+	if res, forwarded, err := forwardAdmin(s, ctx, req, pb.AdminServiceClient.RemoveNode); forwarded {
+		return res, err
+	}
+	target, ok := s.members.Get(req.NodeId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node %s is not part of the cluster", req.NodeId)
+	}
+	if target.Id == s.nodeId {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is the leader, transfer leadership before removing it", target.Id)
+	}
+
+	if req.Decommission {
+		c, err := s.getNodeClient(target)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "unable to reach node %s: %v", target.Id, err)
+		}
+		// the node leaves the cluster config itself once it started handing off
+		drainCtx, cancel := context.WithTimeout(ctx, DEFAULT_DRAIN_TIMEOUT)
+		defer cancel()
+		if _, err := c.Decommission(drainCtx, &pb.DecommissionRequest{CallerNodeId: s.nodeId}); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "decommissioning node %s failed: %v", target.Id, err)
+		}
+		s.logger.Infof("Admin decommissioned node %s", target.Id)
+	}
+
+	if s.members.Remove(target.Id) {
+		s.logger.Infof("Admin removed node %s", target.Id)
+		s.updateClusterConfigInternal()
+	}
+	return s.clusterConfig(), nil
+}
+
+// adminSetMaintenance puts a node in maintenance or takes it out again. A node
+// in maintenance is not removed when it stops answering and does not lead.
+func (s *CacheServer) adminSetMaintenance(ctx context.Context, req *pb.MaintenanceRequest) (*pb.ClusterConfig, error) {
+	if res, forwarded, err := forwardAdmin(s, ctx, req, pb.AdminServiceClient.SetMaintenance); forwarded {
+		return res, err
+	}
+	target, ok := s.members.Get(req.NodeId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node %s is not part of the cluster", req.NodeId)
+	}
+	if target.Id == s.nodeId && req.Enabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is the leader, transfer leadership before maintenance", target.Id)
+	}
+
+	if target.Maintenance != req.Enabled {
+		s.members.Update(target.Id, func(n *node.Node) { n.Maintenance = req.Enabled })
+		s.logger.Infof("Admin set maintenance of node %s to %t", target.Id, req.Enabled)
+		s.updateClusterConfigInternal()
+	}
+	return s.clusterConfig(), nil
+}
+
+// adminTransferLeadership hands leadership to another node, the healthiest
+// best ranked node when none is named
+func (s *CacheServer) adminTransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.LeaderResponse, error) {
+	if res, forwarded, err := forwardAdmin(s, ctx, req, pb.AdminServiceClient.TransferLeadership); forwarded {
+		return res, err
+	}
+	targetId := req.NodeId
+	if targetId == "" {
+		successor, err := s.pickSuccessor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		targetId = successor.Id
+	}
+	if err := s.transferLeadership(ctx, targetId); err != nil {
+		return nil, err
+	}
+	leaderId, term := s.members.Leader()
+	return &pb.LeaderResponse{Id: leaderId, Term: term}, nil
+}
+
+// REST admin routes, authenticated with the same bearer token
+func (s *CacheServer) registerAdminRoutes() {
+	admin := s.router.Group("/admin", s.adminAuthMiddleware)
+	admin.POST("/nodes", s.AdminAddNodeHandler)
+	admin.DELETE("/nodes/:id", s.AdminRemoveNodeHandler)
+	admin.POST("/nodes/:id/maintenance", s.AdminMaintenanceHandler(true))
+	admin.DELETE("/nodes/:id/maintenance", s.AdminMaintenanceHandler(false))
+	admin.POST("/leader", s.AdminTransferLeadershipHandler)
+}
+
+func (s *CacheServer) adminAuthMiddleware(client *gin.Context) {
+	if err := s.authorize(client.GetHeader(ADMIN_AUTH_HEADER)); err != nil {
+		adminError(client, err)
+		client.Abort()
+	}
+}
+
+// AdminAddNodeHandler adds the node in the JSON body
+func (s *CacheServer) AdminAddNodeHandler(client *gin.Context) {
+	var n node.Node
+	if err := client.BindJSON(&n); err != nil {
+		return
+	}
+	cfg, err := s.adminAddNode(client.Request.Context(), n.ToProto())
+	if err != nil {
+		adminError(client, err)
+		return
+	}
+	client.IndentedJSON(http.StatusOK, cfg)
+}
+
+// AdminRemoveNodeHandler removes a node, decommission=true hands its keys off first
+func (s *CacheServer) AdminRemoveNodeHandler(client *gin.Context) {
+	req := &pb.RemoveNodeRequest{NodeId: client.Param("id"), Decommission: client.Query("decommission") == "true"}
+	cfg, err := s.adminRemoveNode(client.Request.Context(), req)
+	if err != nil {
+		adminError(client, err)
+		return
+	}
+	client.IndentedJSON(http.StatusOK, cfg)
+}
+
+// AdminMaintenanceHandler puts a node in maintenance or takes it out again
+func (s *CacheServer) AdminMaintenanceHandler(enabled bool) gin.HandlerFunc {
+	return func(client *gin.Context) {
+		cfg, err := s.adminSetMaintenance(client.Request.Context(), &pb.MaintenanceRequest{NodeId: client.Param("id"), Enabled: enabled})
+		if err != nil {
+			adminError(client, err)
+			return
+		}
+		client.IndentedJSON(http.StatusOK, cfg)
+	}
+}
+
+// AdminTransferLeadershipHandler hands leadership to the node in the JSON body
+func (s *CacheServer) AdminTransferLeadershipHandler(client *gin.Context) {
+	var body struct {
+		NodeId string `json:"nodeId"`
+	}
+	if err := client.BindJSON(&body); err != nil {
+		return
+	}
+	res, err := s.adminTransferLeadership(client.Request.Context(), &pb.TransferLeadershipRequest{NodeId: body.NodeId})
+	if err != nil {
+		adminError(client, err)
+		return
+	}
+	client.IndentedJSON(http.StatusOK, gin.H{"leader": res.Id, "term": res.Term})
+}
+
+// adminError answers an admin request with the HTTP status matching a gRPC error
+func adminError(client *gin.Context, err error) {
+	st := status.Convert(err)
+	code := http.StatusServiceUnavailable
+	switch st.Code() {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		code = http.StatusConflict
+	}
+	client.IndentedJSON(code, gin.H{"message": st.Message()})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminMembership(t *testing.T) {
+	s := newTestServer(t, "node0")
+	election := s.election.(*recordElection)
+	s.members.SetLeader("node0", 1)
+	ctx := context.Background()
+
+	if _, err := s.adminAddNode(ctx, &pb.Node{Id: "node1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a node without address to be refused, got %v", err)
+	}
+	cfg, err := s.adminAddNode(ctx, node.InitNode("node1", "localhost", 8081, 5006).ToProto())
+	if err != nil || len(cfg.Nodes) != 2 || len(election.published) != 1 {
+		t.Fatalf("expected node1 to be added and published, got %v %v", cfg, err)
+	}
+	if _, err := s.adminAddNode(ctx, node.InitNode("node1", "localhost", 8081, 5006).ToProto()); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected node1 to be added only once, got %v", err)
+	}
+
+	// a node in maintenance may not lead and is kept when it stops answering
+	if _, err := s.adminSetMaintenance(ctx, &pb.MaintenanceRequest{NodeId: "node1", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.members.Get("node1"); !n.Maintenance || n.CanLead() {
+		t.Errorf("expected node1 to be in maintenance")
+	}
+	if _, err := s.adminSetMaintenance(ctx, &pb.MaintenanceRequest{NodeId: "node0", Enabled: true}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the leader to refuse maintenance, got %v", err)
+	}
+
+	if _, err := s.adminRemoveNode(ctx, &pb.RemoveNodeRequest{NodeId: "node0"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the leader to refuse removing itself, got %v", err)
+	}
+	if _, err := s.adminRemoveNode(ctx, &pb.RemoveNodeRequest{NodeId: "node9"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected an unknown node to be reported, got %v", err)
+	}
+	cfg, err = s.adminRemoveNode(ctx, &pb.RemoveNodeRequest{NodeId: "node1"})
+	if err != nil || len(cfg.Nodes) != 1 || len(election.published) != 3 {
+		t.Errorf("expected node1 to be removed and published, got %v %v", cfg, err)
+	}
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	s := newTestServer(t, "node0")
+	s.members.SetLeader("node0", 1)
+	s.registerAdminRoutes()
+	admin := &adminServer{s: s}
+
+	// without a token the admin API stays disabled
+	req := &pb.MaintenanceRequest{NodeId: "node9"}
+	if _, err := admin.SetMaintenance(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the admin API to be disabled, got %v", err)
+	}
+
+	s.SetAdminToken("secret")
+	wrong := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ADMIN_AUTH_HEADER, "Bearer guess"))
+	if _, err := admin.SetMaintenance(wrong, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected a wrong token to be refused, got %v", err)
+	}
+	right := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ADMIN_AUTH_HEADER, "Bearer secret"))
+	if _, err := admin.SetMaintenance(right, req); status.Code(err) != codes.NotFound {
+		t.Errorf("expected an authorized request to reach the leader, got %v", err)
+	}
+
+	for header, code := range map[string]int{"": http.StatusUnauthorized, "Bearer secret": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/admin/nodes/node9/maintenance", nil)
+		r.Header.Set(ADMIN_AUTH_HEADER, header)
+		s.router.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("expected status %d with header %q, got %d", code, header, w.Code)
+		}
+	}
+}
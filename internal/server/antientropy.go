@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DEFAULT_ANTI_ENTROPY_INTERVAL = time.Minute
+	DEFAULT_ANTI_ENTROPY_RATE     = 1000
+)
+
+type antiEntropyStats struct {
+	rounds     atomic.Uint64
+	ranges     atomic.Uint64
+	mismatched atomic.Uint64
+	repaired   atomic.Uint64
+	failures   atomic.Uint64
+}
+
+// Anti-entropy metrics
+type AntiEntropyMetrics struct {
+	Interval   string `json:"interval"`
+	Rate       int    `json:"rate"`
+	Rounds     uint64 `json:"rounds"`
+	Ranges     uint64 `json:"ranges"`
+	Mismatched uint64 `json:"mismatched"`
+	Repaired   uint64 `json:"repaired"`
+	Failures   uint64 `json:"failures"`
+}
+
+// Set how often anti-entropy runs (0 disables it) and how many keys per
+// second it may pull from peers (0 means unlimited)
+func (s *CacheServer) SetAntiEntropy(interval time.Duration, rate int) {
+	s.antiEntropyInterval = interval
+	s.antiEntropyRate = rate
+}
+
+// Periodically compare the Merkle trees of every token range this node
+// replicates with the other replicas of the range and pull the keys that
+// differ. Every node runs the same loop, so pulling is enough to converge.
+func (s *CacheServer) RunAntiEntropy() {
+	if s.antiEntropyInterval <= 0 {
+		s.logger.Info("Anti-entropy disabled")
+		return
+	}
+	s.logger.Info("Anti-entropy starting...")
+
+	ticker := time.NewTicker(s.antiEntropyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdownChannel:
+			return
+		case <-ticker.C:
+		}
+		s.antiEntropyRound()
+	}
+}
+
+func (s *CacheServer) antiEntropyRound() {
+	// with fixed-partition placement the ring ranges do not match the replica sets
+	s.slotMutex.RLock()
+	slotMode := s.slotTable != nil
+	s.slotMutex.RUnlock()
+	if slotMode {
+		return
+	}
+
+	n := s.members.Snapshot().GetReplicationFactor()
+	if n < 2 {
+		return
+	}
+	ring, err := s.getRing()
+	if err != nil || ring.Nodes.Len() == 0 {
+		return
+	}
+	s.antiEntropyStats.rounds.Add(1)
+
+	var limiter <-chan time.Time
+	if s.antiEntropyRate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(s.antiEntropyRate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	ranges := ring.TokenRanges(n)
+	buckets := bucketByRange(s.cache.Entries(), ranges, ring.KeyHash)
+	for i, tr := range ranges {
+		if !containsId(tr.Replicas, s.nodeId) {
+			continue
+		}
+		local := buildMerkleTree(buckets[i], tr, DEFAULT_MERKLE_DEPTH)
+		for _, peerId := range tr.Replicas {
+			if peerId == s.nodeId {
+				continue
+			}
+			s.antiEntropyStats.ranges.Add(1)
+			if err := s.syncRange(peerId, tr, local, limiter); err != nil {
+				s.logger.Infof("anti-entropy with node %s failed: %v", peerId, err)
+				s.antiEntropyStats.failures.Add(1)
+			}
+		}
+	}
+}
+
+// syncRange compares a range with one peer and pulls the entries of the leaves that differ
+func (s *CacheServer) syncRange(peerId string, tr ch.TokenRange, local *merkleTree, limiter <-chan time.Time) error {
+	peer, ok := s.members.Get(peerId)
+	if !ok {
+		return nil
+	}
+	c, err := s.getNodeClient(peer)
+	if err != nil {
+		return err
+	}
+
+	pbRange := &pb.TokenRange{Start: tr.Start, End: tr.End}
+	ctx, cancel := context.WithTimeout(context.Background(), REPLICA_TIMEOUT)
+	res, err := c.GetMerkleTree(ctx, &pb.MerkleTreeRequest{CallerNodeId: s.nodeId, Range: pbRange, Depth: int32(local.depth)})
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	leaves := diffLeaves(local, &merkleTree{depth: int(res.Depth), hashes: res.Hashes})
+	if len(leaves) == 0 {
+		return nil
+	}
+	s.antiEntropyStats.mismatched.Add(1)
+
+	req := &pb.RangeEntriesRequest{CallerNodeId: s.nodeId, Range: pbRange, Depth: int32(local.depth)}
+	for _, leaf := range leaves {
+		req.Leaves = append(req.Leaves, int32(leaf))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.StreamRangeEntries(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if limiter != nil {
+			<-limiter
+		}
+		if s.cache.PutVersioned(entry.Key, entry.Value, entry.Version) {
+			s.antiEntropyStats.repaired.Add(1)
+		}
+	}
+}
+
+func containsId(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func merkleDepth(depth int32) (int, error) {
+	if depth <= 0 {
+		return DEFAULT_MERKLE_DEPTH, nil
+	}
+	if depth > MAX_MERKLE_DEPTH {
+		return 0, status.Errorf(codes.InvalidArgument, "merkle depth %d exceeds %d", depth, MAX_MERKLE_DEPTH)
+	}
+	return int(depth), nil
+}
+
+// GetMerkleTree returns the Merkle tree of the local entries in a token range
+func (s *CacheServer) GetMerkleTree(ctx context.Context, req *pb.MerkleTreeRequest) (*pb.MerkleTree, error) {
+	depth, err := merkleDepth(req.Depth)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := s.getRing()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to build ring: %v", err)
+	}
+
+	tr := ch.TokenRange{Start: req.GetRange().GetStart(), End: req.GetRange().GetEnd()}
+	t := buildMerkleTree(bucketByRange(s.cache.Entries(), []ch.TokenRange{tr}, ring.KeyHash)[0], tr, depth)
+	return &pb.MerkleTree{Hashes: t.hashes, Depth: int32(t.depth)}, nil
+}
+
+// StreamRangeEntries streams the local entries that fall into the requested leaves of a token range
+func (s *CacheServer) StreamRangeEntries(req *pb.RangeEntriesRequest, stream pb.CacheService_StreamRangeEntriesServer) error {
+	depth, err := merkleDepth(req.Depth)
+	if err != nil {
+		return err
+	}
+	ring, err := s.getRing()
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "unable to build ring: %v", err)
+	}
+
+	tr := ch.TokenRange{Start: req.GetRange().GetStart(), End: req.GetRange().GetEnd()}
+	leaves := make(map[int]bool, len(req.Leaves))
+	for _, leaf := range req.Leaves {
+		leaves[int(leaf)] = true
+	}
+
+	sent := 0
+	for _, entry := range s.cache.Entries() {
+		h := ring.KeyHash(entry.Key)
+		if !tr.Contains(h) || !leaves[merkleLeaf(tr, h, depth)] {
+			continue
+		}
+		if err := stream.Send(&pb.Entry{Key: entry.Key, Value: entry.Value, Version: entry.Version}); err != nil {
+			return err
+		}
+		sent++
+	}
+	s.logger.Infof("Streamed %d entries of %d leaves to node %s", sent, len(leaves), req.CallerNodeId)
+	return nil
+}
+
+func (s *CacheServer) AntiEntropyMetrics() AntiEntropyMetrics {
+	return AntiEntropyMetrics{
+		Interval:   s.antiEntropyInterval.String(),
+		Rate:       s.antiEntropyRate,
+		Rounds:     s.antiEntropyStats.rounds.Load(),
+		Ranges:     s.antiEntropyStats.ranges.Load(),
+		Mismatched: s.antiEntropyStats.mismatched.Load(),
+		Repaired:   s.antiEntropyStats.repaired.Load(),
+		Failures:   s.antiEntropyStats.failures.Load(),
+	}
+}
+
+// AntiEntropyMetricsHandler Implementation
+func (s *CacheServer) AntiEntropyMetricsHandler(client *gin.Context) {
+	client.IndentedJSON(http.StatusOK, s.AntiEntropyMetrics())
+}
@@ -3,93 +3,268 @@ package server
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/internal/membership"
 	"github.com/nathang15/go-tinystore/internal/node"
 	"github.com/nathang15/go-tinystore/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-func (s *CacheServer) RegisterNodeToCluster(ctx context.Context, nodeInfo *pb.Node) (*pb.GenericResponse, error) {
-	if _, ok := s.nodesInfo.Nodes[nodeInfo.Id]; ok {
+// RegisterNodeWithCluster adds a node to the cluster config, or updates the
+// address of a member that restarted elsewhere. Followers hand the
+// registration to the leader so only the leader issues new config epochs,
+// and refuse it when the leader cannot be reached.
+func (s *CacheServer) RegisterNodeWithCluster(ctx context.Context, nodeInfo *pb.Node) (*pb.GenericResponse, error) {
+	// discovered seeds can include the registering node itself
+	if nodeInfo.Id == s.nodeId {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s cannot register with itself", s.nodeId)
+	}
+	current, known := s.members.Get(nodeInfo.Id)
+	if known && !addressChanged(current, nodeInfo) {
 		s.logger.Infof("Node %s already part of cluster", nodeInfo.Id)
 		return &pb.GenericResponse{Data: SUCCESS}, nil
 	}
 
-	s.nodesInfo.Nodes[nodeInfo.Id] = node.InitNode(nodeInfo.Id, nodeInfo.Host, nodeInfo.RestPort, nodeInfo.GrpcPort)
+	if !s.members.IsLeader(s.nodeId) {
+		// only the leader changes the members, the node registers through another seed otherwise
+		leaderId, _ := s.members.Leader()
+		leader, ok := s.members.Get(leaderId)
+		if !ok {
+			return nil, status.Errorf(codes.Unavailable, "node %s knows no leader to register node %s with", s.nodeId, nodeInfo.Id)
+		}
+		res, err := s.forwardRegistration(leader, nodeInfo)
+		if err != nil {
+			s.logger.Infof("unable to forward registration of node %s to leader %s: %v", nodeInfo.Id, leaderId, err)
+			return nil, status.Errorf(codes.Unavailable, "unable to forward registration of node %s to leader %s: %v", nodeInfo.Id, leaderId, err)
+		}
+		return res, nil
+	}
 
-	var nodes []*pb.Node
-	for _, node := range s.nodesInfo.Nodes {
-		nodes = append(nodes, &pb.Node{Id: node.Id, Host: node.Host, RestPort: node.RestPort, GrpcPort: node.GrpcPort})
+	if known {
+		// keep the weight, zone and maintenance state the cluster set for the node
+		s.logger.Infof("Node %s re-registered from %s:%d", nodeInfo.Id, nodeInfo.Host, nodeInfo.GrpcPort)
+		s.dropNodeClient(current)
+		s.members.Update(nodeInfo.Id, func(n *node.Node) {
+			n.Host = nodeInfo.Host
+			n.RestHost = nodeInfo.RestHost
+			n.RestPort = nodeInfo.RestPort
+			n.GrpcPort = nodeInfo.GrpcPort
+			n.GrpcClient = nil
+		})
+	} else if !s.members.Add(node.FromProto(nodeInfo)) {
+		return &pb.GenericResponse{Data: SUCCESS}, nil
 	}
-	for _, node := range s.nodesInfo.Nodes {
-		if node.Id == s.nodeId {
-			continue
-		}
-		s.logger.Infof("Sending updated cluster config to node %s with grpc client %v", node.Id, node.GrpcClient)
-		req_ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+	s.updateClusterConfigInternal()
+	return &pb.GenericResponse{Data: SUCCESS}, nil
+}
 
-		cfg := pb.ClusterConfig{Nodes: nodes}
+// addressChanged reports whether a member registers from a new address
+func addressChanged(current *node.Node, nodeInfo *pb.Node) bool {
+	return current.Host != nodeInfo.Host || current.RestHost != nodeInfo.RestHost ||
+		current.RestPort != nodeInfo.RestPort || current.GrpcPort != nodeInfo.GrpcPort
+}
 
-		c, err := s.ServerInitCacheClient(nodeInfo.Host, int(nodeInfo.GrpcPort))
-		if err != nil {
-			s.logger.Errorf("unable to connect to node %s", nodeInfo.Id)
-			return nil, status.Errorf(
-				codes.InvalidArgument,
-				fmt.Sprintf("Unable to connect to node being registered: %s", nodeInfo.Id),
-			)
-		}
-		c.UpdateClusterConfig(req_ctx, &cfg)
+func (s *CacheServer) forwardRegistration(leader *node.Node, nodeInfo *pb.Node) (*pb.GenericResponse, error) {
+	c, err := s.getNodeClient(leader)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return c.RegisterNodeWithCluster(ctx, nodeInfo)
+}
+
+// LeaveCluster removes a node that is shutting down from the cluster config.
+// Only the leader accepts it and pushes the new config to the other nodes.
+func (s *CacheServer) LeaveCluster(ctx context.Context, nodeInfo *pb.Node) (*pb.GenericResponse, error) {
+	if !s.members.IsLeader(s.nodeId) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is not the leader", s.nodeId)
 	}
+	if !s.members.Remove(nodeInfo.Id) {
+		return &pb.GenericResponse{Data: SUCCESS}, nil
+	}
+
+	s.logger.Infof("Node %s is leaving the cluster", nodeInfo.Id)
+	s.updateClusterConfigInternal()
 	return &pb.GenericResponse{Data: SUCCESS}, nil
 }
 
 func (s *CacheServer) GetClusterConfig(ctx context.Context, req *pb.ClusterConfigRequest) (*pb.ClusterConfig, error) {
-	var nodes []*pb.Node
-	for _, node := range s.nodesInfo.Nodes {
-		nodes = append(nodes, &pb.Node{Id: node.Id, Host: node.Host, RestPort: node.RestPort, GrpcPort: node.GrpcPort})
-	}
-	s.logger.Infof("Returning cluster config to node %s: %v", req.CallerNodeId, nodes)
-	return &pb.ClusterConfig{Nodes: nodes}, nil
+	cfg := s.clusterConfig()
+	s.logger.Infof("Returning cluster config to node %s: %v", req.CallerNodeId, cfg.Nodes)
+	return cfg, nil
 }
 
 func (s *CacheServer) UpdateClusterConfig(ctx context.Context, req *pb.ClusterConfig) (*empty.Empty, error) {
-	s.logger.Info("Updating cluster config")
-	s.nodesInfo.Nodes = make(map[string]*node.Node)
-	for _, nodecfg := range req.Nodes {
-		s.nodesInfo.Nodes[nodecfg.Id] = node.InitNode(nodecfg.Id, nodecfg.Host, nodecfg.RestPort, nodecfg.GrpcPort)
+	if _, ok := s.election.(*raftElection); ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "cluster config of node %s is replicated through the raft log", s.nodeId)
+	}
+	if err := s.adoptClusterId(req.ClusterId); err != nil {
+		s.logger.Infof("Rejecting cluster config: %v", err)
+		return nil, err
+	}
+	s.configMut.Lock()
+	defer s.configMut.Unlock()
+
+	leaderId, term := s.members.Leader()
+	if req.LeaderTerm < term {
+		s.logger.Infof("Rejecting cluster config from leader term %d, current term %d", req.LeaderTerm, term)
+		return nil, staleTermError(leaderId, term, fmt.Sprintf("stale cluster config: leader term %d is older than term %d", req.LeaderTerm, term))
+	}
+	if req.LeaderTerm == term && req.LeaderId != "" && leaderId != NO_LEADER && req.LeaderId != leaderId {
+		s.logger.Infof("Rejecting cluster config from node %s, node %s leads term %d", req.LeaderId, leaderId, term)
+		return nil, staleTermError(leaderId, term, fmt.Sprintf("stale cluster config: node %s leads term %d", leaderId, term))
+	}
+	if epoch := s.members.Epoch(); node.IsStaleConfig(req, term, epoch) {
+		s.logger.Infof("Rejecting stale cluster config with epoch %d and term %d, current epoch %d and term %d", req.Epoch, req.LeaderTerm, epoch, term)
+		return nil, status.Errorf(codes.FailedPrecondition, "stale cluster config: epoch %d term %d is older than epoch %d term %d", req.Epoch, req.LeaderTerm, epoch, term)
+	}
+
+	s.logger.Infof("Updating cluster config to epoch %d", req.Epoch)
+	if req.LeaderId != "" && req.LeaderId != leaderId {
+		// the config is fenced with a term at least as new as ours, follow its leader
+		if leaderId == s.nodeId {
+			s.logger.Infof("Stepping down, node %s leads term %d", req.LeaderId, req.LeaderTerm)
+		}
+		leaderId = req.LeaderId
+	}
+	s.members.SetLeader(leaderId, req.LeaderTerm)
+	s.applyClusterConfig(req)
+
+	// membership changed behind the leader's back, reassign slots and push them out
+	if s.members.IsLeader(s.nodeId) && s.members.Snapshot().Slots > 0 {
+		go s.updateClusterConfigInternal()
 	}
 	return &empty.Empty{}, nil
 }
 
-func (s *CacheServer) updateClusterConfigInternal() {
-	s.logger.Info("Sending out updated cluster config")
-	var nodes []*pb.Node
-	for _, node := range s.nodesInfo.Nodes {
-		nodes = append(nodes, &pb.Node{Id: node.Id, Host: node.Host, RestPort: node.RestPort, GrpcPort: node.GrpcPort})
+// Adopt the members, placement, epoch and slot table of a cluster config
+func (s *CacheServer) applyClusterConfig(cfg *pb.ClusterConfig) {
+	nodes := make([]*node.Node, len(cfg.Nodes))
+	for i, nodecfg := range cfg.Nodes {
+		nodes[i] = node.FromProto(nodecfg)
+		if current, ok := s.members.Get(nodecfg.Id); ok && (current.Host != nodecfg.Host || current.GrpcPort != nodecfg.GrpcPort) {
+			s.dropNodeClient(current)
+		}
 	}
-	for _, node := range s.nodesInfo.Nodes {
-		if node.Id == s.nodeId {
-			continue
+	s.members.Replace(nodes, cfg.Placement, cfg.Epoch)
+	s.invalidateRing()
+
+	if table := ch.SlotTableFromProto(cfg.SlotTable); table != nil {
+		s.slotMutex.Lock()
+		if s.slotTable == nil || table.Version > s.slotTable.Version {
+			s.slotTable = table
 		}
-		reqCtx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+		s.slotMutex.Unlock()
+	}
+	s.configChanged.notify()
+}
+
+// Build the cluster config served to nodes and clients
+func (s *CacheServer) clusterConfig() *pb.ClusterConfig {
+	return s.buildClusterConfig(s.members.Versioned())
+}
 
-		cfg := pb.ClusterConfig{Nodes: nodes}
+// Build the cluster config of a snapshot of the members at epoch
+func (s *CacheServer) buildClusterConfig(info node.NodesInfo, epoch int64) *pb.ClusterConfig {
+	leaderId, term := s.members.Leader()
+
+	var nodes []*pb.Node
+	for _, node := range info.Nodes {
+		nodes = append(nodes, node.ToProto())
+	}
+	cfg := &pb.ClusterConfig{Nodes: nodes, Placement: info.Placement(), Epoch: epoch, LeaderTerm: term, ClusterId: s.clusterId()}
+	if leaderId != NO_LEADER {
+		cfg.LeaderId = leaderId
+	}
+
+	s.slotMutex.RLock()
+	if s.slotTable != nil {
+		cfg.SlotTable = s.slotTable.ToProto()
+	}
+	s.slotMutex.RUnlock()
+	return cfg
+}
+
+// Reassign hash slots to the current members. Only the leader computes the
+// slot table, every new assignment gets a higher version.
+func (s *CacheServer) refreshSlotTable() {
+	numSlots := s.members.Snapshot().Slots
+	if numSlots <= 0 || !s.members.IsLeader(s.nodeId) {
+		return
+	}
+	nodeIds := s.members.Ids()
 
-		c, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
+	s.slotMutex.Lock()
+	defer s.slotMutex.Unlock()
 
+	if s.slotTable != nil && len(s.slotTable.Slots) == numSlots && slices.Equal(s.slotTable.Owners(), nodeIds) {
+		return
+	}
+	s.slotTable = ch.AssignSlots(s.slotTable, nodeIds, numSlots)
+	s.logger.Infof("Assigned %d slots to %d nodes, slot table version %d", numSlots, len(nodeIds), s.slotTable.Version)
+}
+
+// updateClusterConfigInternal publishes the current members under a new
+// epoch. Only the leader versions configs, other nodes leave it to the leader.
+func (s *CacheServer) updateClusterConfigInternal() {
+	if !s.members.IsLeader(s.nodeId) {
+		s.logger.Infof("Node %s is not the leader, not publishing a cluster config", s.nodeId)
+		return
+	}
+	// the published config carries the epoch allocated for its members
+	info, epoch := s.members.NextEpoch()
+	s.logger.Infof("Sending out cluster config epoch %d", epoch)
+	s.invalidateRing()
+	s.refreshSlotTable()
+	s.configChanged.notify()
+
+	s.election.Publish(s.buildClusterConfig(info, epoch))
+}
+
+// pushClusterConfig sends a config to every other node
+func (s *CacheServer) pushClusterConfig(cfg *pb.ClusterConfig) {
+	for _, node := range s.members.Snapshot().Nodes {
+		if node.Id == s.nodeId {
+			continue
+		}
+		c, err := s.getNodeClient(node)
 		if err != nil {
 			s.logger.Errorf("unable to connect to node %s", node.Id)
 			continue
 		}
 
-		_, err = c.UpdateClusterConfig(reqCtx, &cfg)
+		reqCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.UpdateClusterConfig(reqCtx, cfg)
+		cancel()
+		if s.stepDown(err) {
+			// the node has seen a newer leader term, this node is no longer leader
+			return
+		}
 		if err != nil {
 			s.logger.Infof("error sending cluster config to node %s: %v", node.Id, err)
 		}
 	}
 }
+
+// followMembership cleans up after nodes that leave, logs membership changes
+// and tells config watchers about them
+func (s *CacheServer) followMembership(events <-chan membership.Event) {
+	for event := range events {
+		switch event.Type {
+		case membership.EVENT_JOIN:
+			s.logger.Infof("Node %s joined the cluster", event.Node.Id)
+		case membership.EVENT_LEAVE:
+			s.logger.Infof("Node %s left the cluster", event.Node.Id)
+			s.detector.forget(event.Node.Id)
+		case membership.EVENT_LEADER:
+			s.logger.Infof("Node %s leads term %d", event.LeaderId, event.Term)
+		}
+		s.configChanged.notify()
+	}
+}
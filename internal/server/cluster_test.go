@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/discovery"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUpdateClusterConfigRejectsStale(t *testing.T) {
+	s := newTestServer(t, "node0")
+	nodes := []*pb.Node{node.InitNode("node0", "localhost", 8080, 5005).ToProto(), node.InitNode("node1", "localhost", 8081, 5006).ToProto()}
+
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 5, LeaderTerm: 2}); err != nil {
+		t.Fatalf("expected newer config to be applied, got %v", err)
+	}
+	if _, term := s.members.Leader(); len(s.members.Ids()) != 2 || s.members.Epoch() != 5 || term != 2 {
+		t.Errorf("expected epoch 5 term 2 with 2 nodes, got epoch %d term %d %v", s.members.Epoch(), term, s.members.Ids())
+	}
+
+	// an older epoch, or any epoch from an earlier leader, must not undo the membership
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes[:1], Epoch: 4, LeaderTerm: 2}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected config of an older epoch to be rejected, got %v", err)
+	}
+	_, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes[:1], Epoch: 9, LeaderTerm: 1})
+	if term, ok := newerTerm(err); !ok || term != 2 {
+		t.Errorf("expected config of an earlier leader to be rejected with term 2, got %v", err)
+	}
+	if len(s.members.Ids()) != 2 {
+		t.Errorf("stale config changed membership to %v", s.members.Ids())
+	}
+
+	// a new leader term wins even with a lower epoch
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes[:1], Epoch: 1, LeaderTerm: 3}); err != nil {
+		t.Errorf("expected config from a newer term to be applied, got %v", err)
+	}
+}
+
+func TestRegisterWithItself(t *testing.T) {
+	self := node.InitNode("node7", "node7-host", 8080, 5005)
+	s := newTestServer(t, "node7", self)
+
+	// a discovered seed can be the node itself, under its address or its id
+	seeds := []discovery.Seed{{Host: "node7-host", Port: 5005}, {Id: "node7", Host: "10.0.0.7", Port: 5005}}
+	if s.registerWithSeeds(seeds) {
+		t.Error("expected the node not to register with itself")
+	}
+	if _, err := s.RegisterNodeWithCluster(context.Background(), self.ToProto()); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a registration with itself to be refused, got %v", err)
+	}
+}
+
+func TestReregisterWithNewAddress(t *testing.T) {
+	member := node.InitNode("node1", "node1-host", 8081, 5006)
+	member.Weight = 3
+	s := newTestServer(t, "node0", node.InitNode("node0", "localhost", 8080, 5005), member)
+	s.members.SetLeader("node0", 1)
+	epoch := s.members.Epoch()
+
+	// registering again from the same address changes nothing
+	if _, err := s.RegisterNodeWithCluster(context.Background(), member.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	if s.members.Epoch() != epoch {
+		t.Errorf("expected epoch %d to be kept, got %d", epoch, s.members.Epoch())
+	}
+
+	// a node restarted elsewhere under the same id takes its new address, and
+	// the client to its old address is dropped
+	s.clients["node1-host:5006"] = downClient{}
+	moved := node.InitNode("node1", "10.0.0.9", 9081, 6006).ToProto()
+	if _, err := s.RegisterNodeWithCluster(context.Background(), moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.clients["node1-host:5006"]; ok {
+		t.Errorf("expected the client to the old address of node1 to be dropped")
+	}
+	n, _ := s.members.Get("node1")
+	if n.Host != "10.0.0.9" || n.RestPort != 9081 || n.GrpcPort != 6006 || n.Weight != 3 {
+		t.Errorf("expected node1 at its new address with weight 3, got %+v", n)
+	}
+	if s.members.Epoch() <= epoch {
+		t.Errorf("expected a new config epoch after epoch %d, got %d", epoch, s.members.Epoch())
+	}
+}
+
+func TestFollowerRefusesRegistrationWithoutLeader(t *testing.T) {
+	s := newTestServer(t, "node0", node.InitNode("node0", "localhost", 8080, 5005), node.InitNode("node1", "localhost", 8081, 5006))
+	joiner := node.InitNode("node2", "localhost", 8082, 5007).ToProto()
+
+	// no leader is known, or the known leader cannot be reached: the joiner
+	// has to try another seed, the follower changes nothing itself
+	epoch := s.members.Epoch()
+	for _, leaderId := range []string{NO_LEADER, "node1"} {
+		s.members.SetLeader(leaderId, 1)
+		s.clients["localhost:5006"] = downClient{}
+		if _, err := s.RegisterNodeWithCluster(context.Background(), joiner); status.Code(err) != codes.Unavailable {
+			t.Errorf("expected the registration to be refused with leader %q, got %v", leaderId, err)
+		}
+		if _, ok := s.members.Get("node2"); ok || s.members.Epoch() != epoch {
+			t.Errorf("expected the follower to keep its members, got %v epoch %d", s.members.Ids(), s.members.Epoch())
+		}
+	}
+}
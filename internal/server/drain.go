@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nathang15/go-tinystore/pb"
+	"github.com/nathang15/go-tinystore/pkg/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DEFAULT_DRAIN_TIMEOUT = 30 * time.Second
+
+// Drain hands leadership and then this node's keys off before it shuts down.
+// Stops the background loops once done or when ctx expires.
+func (s *CacheServer) Drain(ctx context.Context) {
+	s.logger.Info("Draining node before shutdown...")
+	defer s.stop()
+	s.handOverLeadership(ctx)
+	s.handOff(ctx)
+}
+
+// Decommission hands this node's keys off on request of the leader, which
+// removes the node for good. The node stops its background loops and only
+// waits to be shut down.
+func (s *CacheServer) Decommission(ctx context.Context, req *pb.DecommissionRequest) (*pb.GenericResponse, error) {
+	if !s.members.IsLeader(req.CallerNodeId) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is not the leader", req.CallerNodeId)
+	}
+	s.logger.Infof("Decommissioning node on request of leader %s", req.CallerNodeId)
+	s.leaving.Store(true)
+	s.handOff(ctx)
+	s.stop()
+	return &pb.GenericResponse{Data: SUCCESS}, nil
+}
+
+// stop ends the background loops of the node
+func (s *CacheServer) stop() {
+	s.stopOnce.Do(func() { close(s.shutdownChannel) })
+}
+
+// handOff asks the leader to remove this node from the cluster config and
+// streams its keys, most recently used first, to the replicas that take over
+// from it
+func (s *CacheServer) handOff(ctx context.Context) {
+	prev, err := s.currentPlacement()
+	if err != nil {
+		s.logger.Errorf("unable to drain: %v", err)
+		return
+	}
+	next, err := s.placementWithout(s.nodeId)
+	if err != nil {
+		s.logger.Errorf("unable to drain: %v", err)
+		return
+	}
+
+	if s.gossip != nil {
+		s.gossip.Leave(ctx)
+	}
+	if err := s.announceLeave(ctx); err != nil {
+		s.logger.Infof("unable to announce leaving the cluster: %v", err)
+	}
+
+	byTarget := planDrain(s.cache.Entries(), prev, next)
+	s.rebalancer.mut.Lock()
+	s.rebalancer.startProgress(byTarget)
+	s.rebalancer.mut.Unlock()
+
+	// hand off to every target in parallel so the hottest keys of each go first
+	var wg sync.WaitGroup
+	for target, entries := range byTarget {
+		wg.Add(1)
+		go func(target string, entries []store.Entry) {
+			defer wg.Done()
+			if err := s.migrateKeys(ctx, target, entries); err != nil {
+				s.logger.Infof("handing off %d keys to node %s failed: %v", len(entries), target, err)
+				s.rebalancer.mut.Lock()
+				s.rebalancer.progress.Failures++
+				s.rebalancer.mut.Unlock()
+			}
+		}(target, entries)
+	}
+	wg.Wait()
+
+	progress := s.RebalanceProgress()
+	s.logger.Infof("Hand-off finished, handed off %d of %d keys", progress.KeysSent, progress.KeysToMove)
+}
+
+// planDrain groups the entries of a leaving node by the replicas that newly
+// take them over, keeping the recency order of the entries
+func planDrain(entries []store.Entry, prev *placementSnapshot, next *placementSnapshot) map[string][]store.Entry {
+	byTarget := make(map[string][]store.Entry)
+	for _, entry := range entries {
+		before := prev.replicas(entry.Key)
+		for _, id := range next.replicas(entry.Key) {
+			if !slices.Contains(before, id) {
+				byTarget[id] = append(byTarget[id], entry)
+			}
+		}
+	}
+	return byTarget
+}
+
+// announceLeave asks the leader to remove this node from the cluster config
+func (s *CacheServer) announceLeave(ctx context.Context) error {
+	self, ok := s.members.Get(s.nodeId)
+	if !ok {
+		return nil
+	}
+	leaderId, _ := s.members.Leader()
+	if leaderId == s.nodeId {
+		s.members.Remove(s.nodeId)
+		s.updateClusterConfigInternal()
+		return nil
+	}
+
+	leader, ok := s.members.Get(leaderId)
+	if !ok {
+		return fmt.Errorf("leader %s unknown", leaderId)
+	}
+	c, err := s.getNodeClient(leader)
+	if err != nil {
+		return err
+	}
+	_, err = c.LeaveCluster(ctx, self.ToProto())
+	return err
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+func TestPlanDrain(t *testing.T) {
+	prev := testPlacement(2, "node0", "node1", "node2")
+	next := testPlacement(2, "node1", "node2")
+	entries := testEntries(prev, "node0")
+
+	byTarget := planDrain(entries, prev, next)
+	handedOff := 0
+	for target, moved := range byTarget {
+		handedOff += len(moved)
+		for _, entry := range moved {
+			if slices.Contains(prev.replicas(entry.Key), target) || !slices.Contains(next.replicas(entry.Key), target) {
+				t.Errorf("%s should only go to a new replica, got %s", entry.Key, target)
+			}
+		}
+	}
+	// with two of three nodes left every key of the leaving node gains exactly one new replica
+	if handedOff != len(entries) {
+		t.Errorf("expected all %d keys to be handed off once, got %d", len(entries), handedOff)
+	}
+}
+
+func TestPlanDrainWithSlots(t *testing.T) {
+	nodes := []*node.Node{
+		node.InitNode("node0", "localhost", 8080, 5005),
+		node.InitNode("node1", "localhost", 8081, 5006),
+		node.InitNode("node2", "localhost", 8082, 5007),
+	}
+	s := newTestServer(t, "node0", nodes...)
+	s.members.Replace(nodes, &pb.Placement{Algorithm: node.SLOTS, Slots: 64, ReplicationFactor: 1}, 1)
+	s.members.SetLeader("node0", 1)
+	s.refreshSlotTable()
+
+	prev, err := s.currentPlacement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := s.placementWithout("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := testEntries(prev, "node2")
+	byTarget := planDrain(entries, prev, next)
+
+	// the leader reassigns the slots of node2 once it left, the keys have to be there
+	s.members.Remove("node2")
+	s.refreshSlotTable()
+	handedOff := 0
+	for target, moved := range byTarget {
+		for _, entry := range moved {
+			if owner := s.slotTable.Get(entry.Key); owner != target {
+				t.Errorf("%s went to %s, its slot is owned by %s", entry.Key, target, owner)
+			}
+		}
+		handedOff += len(moved)
+	}
+	if len(entries) == 0 || handedOff != len(entries) {
+		t.Errorf("expected all %d keys of node2 to be handed off, got %d", len(entries), handedOff)
+	}
+}
@@ -2,11 +2,17 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"sort"
 	"time"
 
 	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/nathang15/go-tinystore/internal/membership"
+	"github.com/nathang15/go-tinystore/internal/node"
 	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -14,11 +20,46 @@ const (
 	FOLLOWER    = "FOLLOWER"
 	RUNNING     = true
 	NO_ELECTION = false
-	NO_LEADER   = "NO LEADER"
+	NO_LEADER   = membership.NO_LEADER
 )
 
-// Leader Election with bully algorithm
+// A node that outranks the leader waits this long after starting before it
+// takes leadership back, so the cluster sees it as healthy first
+const LEADER_PREEMPT_DELAY = 10 * time.Second
+
+// Election elects the cluster leader and publishes the cluster configs it issues
+type Election interface {
+	// Elect runs an election if the cluster has no leader
+	Elect()
+	// Monitor keeps a leader elected until shutdown
+	Monitor()
+	// Publish makes cfg the cluster config of every node, called on the leader
+	Publish(cfg *pb.ClusterConfig)
+}
+
+// Bully election, the leader pushes configs to every node itself
+type bullyElection struct {
+	s *CacheServer
+}
+
+func (e bullyElection) Elect() {
+	e.s.runBullyElection()
+}
+
+func (e bullyElection) Monitor() {
+	e.s.monitorBully()
+}
+
+func (e bullyElection) Publish(cfg *pb.ClusterConfig) {
+	e.s.pushClusterConfig(cfg)
+}
+
 func (s *CacheServer) RunElection() {
+	s.election.Elect()
+}
+
+// Leader Election with bully algorithm
+func (s *CacheServer) runBullyElection() {
 	if s.electionStatus {
 		s.logger.Info("Election already running")
 		return
@@ -27,49 +68,51 @@ func (s *CacheServer) RunElection() {
 	s.electionStatus = RUNNING
 
 	pid := int32(os.Getpid())
-	s.logger.Infof("Starting election with pid %d", pid)
+	self, _ := s.members.Get(s.nodeId)
+	// the candidate term is above every term seen in the cluster
+	_, term := s.members.Leader()
+	term++
+	s.logger.Infof("Starting election with priority %d for term %d", self.Priority, term)
 
-	for _, node := range s.nodesInfo.Nodes {
+	for _, node := range s.members.Snapshot().Nodes {
 		if node.Id == s.nodeId {
 			continue
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		c, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
+		c, err := s.getNodeClient(node)
 		if err != nil {
 			s.logger.Infof("error creating grpc client to node %s: %v", node.Id, err)
+			continue
 		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		res, err := c.GetPid(ctx, &pb.PidRequest{CallerPid: pid})
+		cancel()
 
 		if err != nil {
-			s.logger.Infof("Error getting pid from node %d: %v", node.Id, err)
+			s.logger.Infof("Error getting pid from node %s: %v", node.Id, err)
 			continue
 		}
 
-		s.logger.Infof("Got pid %d from node %d", res.Pid, node.Id)
-		if (pid < res.Pid) || (res.Pid == pid && s.nodeId < node.Id) {
+		s.logger.Infof("Got pid %d from node %s", res.Pid, node.Id)
+		if res.Term >= term {
+			term = res.Term + 1
+		}
+		if node.Outranks(self) {
 
 			s.logger.Infof("Sending election request to node %s", node.Id)
 
-			c, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
-			if err != nil {
-				s.logger.Infof("error creating grpc client to node node %s: %v", node.Id, err)
-			}
-
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-			defer cancel()
-
-			_, err = c.RequestElection(ctx, &pb.ElectionRequest{CallerPid: pid, CallerNodeId: s.nodeId})
+			_, err = c.RequestElection(ctx, &pb.ElectionRequest{CallerPid: pid, CallerNodeId: s.nodeId, Term: term})
+			cancel()
 			if err != nil {
 				s.logger.Infof("Error requesting node %s run an election: %v", node.Id, err)
 			}
 
 			s.logger.Info("Waiting for decision")
 			select {
-			case s.leaderId = <-s.decisionChannel:
-				s.logger.Infof("Received decision: Leader is node %s", s.leaderId)
+			case leaderId := <-s.decisionChannel:
+				s.logger.Infof("Received decision: Leader is node %s", leaderId)
 				s.electionStatus = NO_ELECTION
 				return
 			case <-time.After(5 * time.Second):
@@ -81,9 +124,22 @@ func (s *CacheServer) RunElection() {
 		}
 	}
 
-	s.leaderId = s.nodeId
+	if !self.CanLead() || s.leaving.Load() {
+		s.logger.Info("No node that may lead is reachable, waiting for one")
+		s.electionStatus = NO_ELECTION
+		return
+	}
+	if _, current := s.members.Leader(); term <= current {
+		// a newer leader was announced while the election ran
+		term = current + 1
+	}
+	s.members.SetLeader(s.nodeId, term)
+	s.ensureClusterId()
+
+	s.SetNewLeader(s.nodeId)
 
-	s.SetNewLeader(s.leaderId)
+	// publish the new leader term, configs from earlier leaders are rejected from now on
+	s.updateClusterConfigInternal()
 
 	s.electionStatus = NO_ELECTION
 
@@ -91,99 +147,295 @@ func (s *CacheServer) RunElection() {
 
 // Notify new leader to all nodes
 func (s *CacheServer) SetNewLeader(newLeader string) {
-	s.logger.Infof("Announcing node %s won election", newLeader)
+	_, term := s.members.Leader()
+	s.logger.Infof("Announcing node %s won election for term %d", newLeader, term)
 
-	for _, node := range s.nodesInfo.Nodes {
+	for _, node := range s.members.Snapshot().Nodes {
 		if node.Id == s.nodeId {
 			continue
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-
-		c, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
+		c, err := s.getNodeClient(node)
 		if err != nil {
 			s.logger.Infof("error creating grpc client to node node %s: %v", node.Id, err)
+			continue
 		}
 
-		_, err = c.UpdateLeader(ctx, &pb.NewLeaderAnnouncement{LeaderId: newLeader})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = c.UpdateLeader(ctx, &pb.NewLeaderAnnouncement{LeaderId: newLeader, Term: term})
+		cancel()
 
+		if s.stepDown(err) {
+			// the node already follows a leader with a newer term
+			return
+		}
 		if err != nil {
 			s.logger.Infof("Election leader announcement to node %s error: %v", node.Id, err)
 		}
-		cancel()
 	}
 }
 
 func (s *CacheServer) GetLeader(ctx context.Context, request *pb.LeaderRequest) (*pb.LeaderResponse, error) {
 	for {
-		if s.leaderId != NO_LEADER {
-			break
+		if s.members.IsLeader(NO_LEADER) {
+			s.RunElection()
 		}
-		s.RunElection()
-
-		if s.leaderId == NO_LEADER {
-			s.logger.Info("No leader elected, waiting 3 seconds before trying again...")
-			time.Sleep(3 * time.Second)
+		if leaderId, term := s.members.Leader(); leaderId != NO_LEADER {
+			return &pb.LeaderResponse{Id: leaderId, Term: term}, nil
 		}
+		s.logger.Info("No leader elected, waiting 3 seconds before trying again...")
+		time.Sleep(3 * time.Second)
 	}
-	return &pb.LeaderResponse{Id: s.leaderId}, nil
 }
 
 // leader status monitoring
 func (s *CacheServer) MonitorLeaderStatus() {
 	s.logger.Info("Leader status monitor starting...")
+	s.election.Monitor()
+}
+
+func (s *CacheServer) monitorBully() {
+	started := time.Now()
 
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-s.shutdownChannel:
+			s.logger.Info("Received shutdown signal")
+			return
+		}
+		if s.leaving.Load() {
+			s.logger.Info("Node is leaving the cluster, stopping leader monitor")
+			return
+		}
 
-		if s.leaderId != s.nodeId {
+		if !s.members.IsLeader(s.nodeId) {
 			if !s.IsLeaderUp() {
 				s.logger.Info("Leader status lost, running new election")
 				s.RunElection()
 				s.logger.Info("Selected new leader! Continue monitoring")
+			} else if time.Since(started) > LEADER_PREEMPT_DELAY && s.outranksLeader() {
+				s.takeLeadership()
 			}
-			select {
-			case <-s.shutdownChannel:
-				s.logger.Info("Received shutdown signal")
-				break
-			case <-time.After(time.Second):
-				continue
-			}
+		} else if s.gossip == nil {
+			s.checkNodes()
+		}
+	}
+}
 
-		} else {
-			modified := false
-			for _, node := range s.nodesInfo.Nodes {
-				if node.Id == s.nodeId {
-					continue
-				}
-
-				client, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
-				if err != nil {
-					s.logger.Infof("error creating grpc client to node node %s: %v", node.Id, err)
-					delete(s.nodesInfo.Nodes, node.Id)
-					modified = true
-					continue
-				}
-				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-				defer cancel()
-
-				s.logger.Infof("Checking status of node %s", node.Id)
-
-				_, err = client.GetStatus(ctx, &pb.StatusRequest{CallerNodeId: s.nodeId})
-
-				if err != nil {
-					s.logger.Infof("Node %s status returned error, removing from cluster", node.Id, err)
-					delete(s.nodesInfo.Nodes, node.Id)
-					modified = true
-				}
-			}
+// handOverLeadership moves leadership to a healthy successor before this
+// node leaves the cluster, so heartbeats are checked without a gap and the
+// followers do not have to elect a leader at once
+func (s *CacheServer) handOverLeadership(ctx context.Context) {
+	s.leaving.Store(true)
+	if !s.members.IsLeader(s.nodeId) {
+		return
+	}
+	if e, ok := s.election.(*raftElection); ok {
+		if err := e.handOver(ctx, s.successorCandidates()); err != nil {
+			s.logger.Errorf("unable to hand over leadership, the followers elect a new leader once the heartbeats stop: %v", err)
+		}
+		return
+	}
 
-			if modified {
-				s.logger.Info("Detected node config change, sending update to other nodes")
-				s.updateClusterConfigInternal()
-			}
+	successor, err := s.pickSuccessor(ctx)
+	if err == nil {
+		err = s.transferLeadership(ctx, successor.Id)
+	}
+	if err != nil {
+		s.logger.Errorf("unable to hand over leadership: %v", err)
+	}
+}
+
+// successorCandidates returns the other nodes that may lead, best ranked first
+func (s *CacheServer) successorCandidates() []*node.Node {
+	var candidates []*node.Node
+	for _, n := range s.members.Snapshot().Nodes {
+		if n.Id != s.nodeId && n.CanLead() {
+			candidates = append(candidates, n)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Outranks(candidates[j]) })
+	return candidates
+}
+
+// pickSuccessor returns the best ranked node that may lead and answers a status check
+func (s *CacheServer) pickSuccessor(ctx context.Context) (*node.Node, error) {
+	for _, candidate := range s.successorCandidates() {
+		c, err := s.getNodeClient(candidate)
+		if err == nil {
+			statusCtx, cancel := context.WithTimeout(ctx, time.Second)
+			_, err = c.GetStatus(statusCtx, &pb.StatusRequest{CallerNodeId: s.nodeId})
+			cancel()
+		}
+		if err == nil {
+			return candidate, nil
 		}
+		s.logger.Infof("Node %s cannot take over leadership: %v", candidate.Id, err)
+	}
+	return nil, status.Errorf(codes.Unavailable, "no healthy node may take over leadership")
+}
+
+// transferLeadership asks another node to lead with a new term and follows
+// it once it accepted. With raft, the node catches up on the log and wins an
+// election instead. Called on the leader.
+func (s *CacheServer) transferLeadership(ctx context.Context, targetId string) error {
+	if targetId == s.nodeId {
+		return nil
+	}
+	target, ok := s.members.Get(targetId)
+	if !ok {
+		return status.Errorf(codes.NotFound, "node %s is not part of the cluster", targetId)
+	}
+	if !target.CanLead() {
+		return status.Errorf(codes.FailedPrecondition, "node %s may not lead", targetId)
+	}
+	if e, ok := s.election.(*raftElection); ok {
+		return e.handOver(ctx, []*node.Node{target})
+	}
+	c, err := s.getNodeClient(target)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "unable to reach node %s: %v", targetId, err)
+	}
+
+	_, term := s.members.Leader()
+	s.logger.Infof("Transferring leadership to node %s for term %d", targetId, term+1)
+	_, err = c.TransferLeadership(ctx, &pb.LeadershipTransfer{LeaderId: s.nodeId, Term: term + 1, Config: s.clusterConfig()})
+	if err != nil {
+		return err
+	}
+	s.members.AdoptLeader(targetId, term+1)
+	return nil
+}
+
+// TransferLeadership makes this node leader on request of the outgoing
+// leader. The node adopts the config of the outgoing leader and takes over
+// checking nodes right away, then announces itself with the new term.
+func (s *CacheServer) TransferLeadership(ctx context.Context, req *pb.LeadershipTransfer) (*pb.GenericResponse, error) {
+	if _, ok := s.election.(*raftElection); ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "leadership transfer is not supported with raft elections")
+	}
+	self, ok := s.members.Get(s.nodeId)
+	if !ok || !self.CanLead() || s.leaving.Load() {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s may not lead", s.nodeId)
+	}
+	_, term := s.members.Leader()
+	if req.Term <= term {
+		return nil, status.Errorf(codes.FailedPrecondition, "stale leader term %d, current term %d", req.Term, term)
+	}
+	if err := s.adoptClusterId(req.Config.GetClusterId()); err != nil {
+		return nil, err
+	}
+	s.configMut.Lock()
+	if req.Config != nil && !node.IsStaleConfig(req.Config, term, s.members.Epoch()) {
+		s.applyClusterConfig(req.Config)
+	}
+	s.configMut.Unlock()
+	if !s.members.AdoptLeader(s.nodeId, req.Term) {
+		return nil, status.Errorf(codes.FailedPrecondition, "stale leader term %d", req.Term)
+	}
+	s.logger.Infof("Taking over leadership from node %s for term %d", req.LeaderId, req.Term)
+	s.ensureClusterId()
+
+	go func() {
+		s.SetNewLeader(s.nodeId)
+		// publish the new leader term, configs from earlier leaders are rejected from now on
+		s.updateClusterConfigInternal()
+	}()
+	return &pb.GenericResponse{Data: SUCCESS}, nil
+}
+
+// outranksLeader reports whether this node should lead instead of the current leader
+func (s *CacheServer) outranksLeader() bool {
+	self, ok := s.members.Get(s.nodeId)
+	if !ok {
+		return false
+	}
+	leaderId, _ := s.members.Leader()
+	leader, ok := s.members.Get(leaderId)
+	return ok && self.Outranks(leader)
+}
+
+// takeLeadership adopts the config of the current leader and then runs an
+// election with a newer term, which the leader accepts and steps down for
+func (s *CacheServer) takeLeadership() {
+	leaderId, term := s.members.Leader()
+	leader, ok := s.members.Get(leaderId)
+	if !ok {
+		return
+	}
+	c, err := s.getNodeClient(leader)
+	if err != nil {
+		s.logger.Infof("error creating grpc client to leader %s: %v", leader.Id, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cfg, err := c.GetClusterConfig(ctx, &pb.ClusterConfigRequest{CallerNodeId: s.nodeId})
+	if err != nil {
+		s.logger.Infof("error getting cluster config from leader %s: %v", leader.Id, err)
+		return
+	}
+	if err := s.adoptClusterId(cfg.ClusterId); err != nil {
+		s.logger.Infof("not taking over leadership: %v", err)
+		return
+	}
+	s.configMut.Lock()
+	if !node.IsStaleConfig(cfg, term, s.members.Epoch()) {
+		s.members.AdoptLeader(leaderId, cfg.LeaderTerm)
+		s.applyClusterConfig(cfg)
+	}
+	s.configMut.Unlock()
+
+	s.logger.Infof("Node %s outranks leader %s, taking over leadership", s.nodeId, leader.Id)
+	s.RunElection()
+}
+
+// checkNodes removes the nodes the failure detector suspects for long
+// enough, without gossip the leader checks every node itself
+func (s *CacheServer) checkNodes() {
+	modified := false
+	for _, node := range s.members.Snapshot().Nodes {
+		if node.Id == s.nodeId {
+			continue
+		}
+
+		client, err := s.getNodeClient(node)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			s.logger.Infof("Checking status of node %s", node.Id)
+			_, err = client.GetStatus(ctx, &pb.StatusRequest{CallerNodeId: s.nodeId})
+			cancel()
+		}
+
+		now := time.Now()
+		if err == nil {
+			s.detector.heartbeat(node.Id, now)
+			continue
+		}
+
+		// a single missed heartbeat is not enough, the node has to stay suspected
+		phi, remove := s.detector.check(node.Id, now)
+		if !remove {
+			s.logger.Infof("Node %s missed a heartbeat, phi %.2f: %v", node.Id, phi, err)
+			continue
+		}
+		if node.Maintenance {
+			s.logger.Infof("Node %s suspected with phi %.2f, keeping it while in maintenance", node.Id, phi)
+			continue
+		}
+		s.logger.Infof("Node %s suspected with phi %.2f, removing from cluster", node.Id, phi)
+		if s.members.Remove(node.Id) {
+			modified = true
+		}
+	}
+
+	if modified {
+		s.logger.Info("Detected node config change, sending update to other nodes")
+		s.updateClusterConfigInternal()
 	}
 }
 
@@ -193,27 +445,28 @@ func (s *CacheServer) GetStatus(ctx context.Context, req *pb.StatusRequest) (*em
 }
 
 func (s *CacheServer) IsLeaderUp() bool {
-	if s.leaderId == NO_LEADER {
+	leaderId, _ := s.members.Leader()
+	if leaderId == NO_LEADER {
 		s.logger.Info("No leader existed!")
 		return false
 	}
 
-	if s.nodeId == s.leaderId {
+	if s.nodeId == leaderId {
 		return true
 	}
 
-	s.logger.Infof("leader is %s", s.leaderId)
+	s.logger.Infof("leader is %s", leaderId)
 
-	leader, exists := s.nodesInfo.Nodes[s.leaderId]
+	leader, exists := s.members.Get(leaderId)
 	if !exists {
-		s.logger.Infof("Leader node %s not found in nodesInfo", s.leaderId)
+		s.logger.Infof("Leader node %s not found in cluster members", leaderId)
 		return false
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	c, err := s.ServerInitCacheClient(leader.Host, int(leader.GrpcPort))
+	c, err := s.getNodeClient(leader)
 	if err != nil {
 		s.logger.Infof("error creating grpc client to node %s: %v", leader.Id, err)
 		return false
@@ -230,15 +483,68 @@ func (s *CacheServer) IsLeaderUp() bool {
 }
 
 func (s *CacheServer) UpdateLeader(ctx context.Context, req *pb.NewLeaderAnnouncement) (*pb.GenericResponse, error) {
-	s.logger.Infof("Received announcement leader is %s", req.LeaderId)
-	s.leaderId = req.LeaderId
-	s.decisionChannel <- s.leaderId
+	wasLeader := s.members.IsLeader(s.nodeId)
+	if !s.members.AdoptLeader(req.LeaderId, req.Term) {
+		leaderId, term := s.members.Leader()
+		s.logger.Infof("Rejecting announcement of leader %s for term %d, node %s leads term %d", req.LeaderId, req.Term, leaderId, term)
+		return nil, staleTermError(leaderId, term, fmt.Sprintf("stale leader term %d, node %s leads term %d", req.Term, leaderId, term))
+	}
+
+	s.logger.Infof("Received announcement leader is %s for term %d", req.LeaderId, req.Term)
+	if wasLeader && req.LeaderId != s.nodeId {
+		s.logger.Infof("Stepping down, node %s leads term %d", req.LeaderId, req.Term)
+	}
+
+	// only a running election waits for the decision
+	select {
+	case s.decisionChannel <- req.LeaderId:
+	default:
+	}
 	return &pb.GenericResponse{Data: SUCCESS}, nil
 }
 
+// staleTermError rejects a request fenced with an older leader term. It
+// carries the current leader and term, so a leader that sent the request can
+// tell it was replaced from any other rejection.
+func staleTermError(leaderId string, term int64, msg string) error {
+	st, err := status.New(codes.Aborted, msg).WithDetails(&pb.LeaderResponse{Id: leaderId, Term: term})
+	if err != nil {
+		return status.Error(codes.Aborted, msg)
+	}
+	return st.Err()
+}
+
+// newerTerm returns the term carried by a staleTermError
+func newerTerm(err error) (int64, bool) {
+	if status.Code(err) != codes.Aborted {
+		return 0, false
+	}
+	for _, detail := range status.Convert(err).Details() {
+		if leader, ok := detail.(*pb.LeaderResponse); ok {
+			return leader.Term, true
+		}
+	}
+	return 0, false
+}
+
+// stepDown gives up leadership when a node rejected a request because it
+// knows a newer term than ours, the leader monitor then finds the current
+// leader or elects one. Reports whether the error carried a newer term.
+func (s *CacheServer) stepDown(err error) bool {
+	newer, ok := newerTerm(err)
+	if _, term := s.members.Leader(); !ok || newer <= term {
+		return false
+	}
+	if s.members.ClearLeader(s.nodeId) {
+		s.logger.Infof("Stepping down, a node knows the newer term %d: %v", newer, err)
+	}
+	return true
+}
+
 func (s *CacheServer) GetPid(ctx context.Context, req *pb.PidRequest) (*pb.PidResponse, error) {
 	localPid := int32(os.Getpid())
-	return &pb.PidResponse{Pid: localPid}, nil
+	_, term := s.members.Leader()
+	return &pb.PidResponse{Pid: localPid, Term: term}, nil
 }
 
 func (s *CacheServer) RequestElection(ctx context.Context, req *pb.ElectionRequest) (*pb.GenericResponse, error) {
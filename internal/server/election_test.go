@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLeaderFencing(t *testing.T) {
+	s := newTestServer(t, "node0")
+	s.members.SetLeader("node0", 3)
+
+	// an old leader coming back with an earlier term is refused
+	if _, err := s.UpdateLeader(context.Background(), &pb.NewLeaderAnnouncement{LeaderId: "node1", Term: 2}); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected stale announcement to be rejected, got %v", err)
+	}
+	if leaderId, term := s.members.Leader(); leaderId != "node0" || term != 3 {
+		t.Errorf("stale announcement changed leader to %s term %d", leaderId, term)
+	}
+
+	// a config issued by the leader of a newer term makes this node step down
+	nodes := []*pb.Node{node.InitNode("node0", "localhost", 8080, 5005).ToProto(), node.InitNode("node2", "localhost", 8082, 5007).ToProto()}
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 1, LeaderTerm: 4, LeaderId: "node2"}); err != nil {
+		t.Fatalf("expected config from a newer term to be applied, got %v", err)
+	}
+	if leaderId, term := s.members.Leader(); leaderId != "node2" || term != 4 {
+		t.Errorf("expected node2 to lead term 4, got %s term %d", leaderId, term)
+	}
+
+	// another node claiming the current term is refused, its leader is accepted again
+	if _, err := s.UpdateLeader(context.Background(), &pb.NewLeaderAnnouncement{LeaderId: "node0", Term: 4}); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected a second leader for term 4 to be rejected, got %v", err)
+	}
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 2, LeaderTerm: 4, LeaderId: "node0"}); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected a config of a second leader for term 4 to be rejected, got %v", err)
+	}
+	if _, err := s.UpdateLeader(context.Background(), &pb.NewLeaderAnnouncement{LeaderId: "node2", Term: 4}); err != nil {
+		t.Fatalf("expected the leader of term 4 to be accepted again, got %v", err)
+	}
+	if leaderId, term := s.members.Leader(); leaderId != "node2" || term != 4 {
+		t.Errorf("expected node2 to lead term 4, got %s term %d", leaderId, term)
+	}
+
+	// announcements of a newer term are accepted
+	if _, err := s.UpdateLeader(context.Background(), &pb.NewLeaderAnnouncement{LeaderId: "node0", Term: 5}); err != nil {
+		t.Fatalf("expected newer announcement to be accepted, got %v", err)
+	}
+	if leaderId, term := s.members.Leader(); leaderId != "node0" || term != 5 {
+		t.Errorf("expected node0 to lead term 5, got %s term %d", leaderId, term)
+	}
+}
+
+func TestLeaderPriority(t *testing.T) {
+	low := node.InitNode("node2", "localhost", 8082, 5007)
+	high := node.InitNode("node0", "localhost", 8080, 5005)
+	high.Priority = 10
+	tie := node.InitNode("node1", "localhost", 8081, 5006)
+	tie.Priority = 10
+	never := node.InitNode("node3", "localhost", 8083, 5008)
+	never.Priority = 100
+	never.NeverLeader = true
+
+	// priority first, then node id, and nodes that never lead rank last
+	for _, c := range []struct{ a, b *node.Node }{{high, low}, {tie, high}, {low, never}} {
+		if !c.a.Outranks(c.b) || c.b.Outranks(c.a) {
+			t.Errorf("expected %s to outrank %s", c.a.Id, c.b.Id)
+		}
+	}
+
+	s := newTestServer(t, "node0", high, low)
+	s.members.SetLeader("node2", 0)
+	if !s.outranksLeader() {
+		t.Errorf("expected node0 to outrank leader node2")
+	}
+	s.SetNeverLeader()
+	if s.outranksLeader() {
+		t.Errorf("expected a never leader node not to take over")
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	s := newTestServer(t, "node0")
+	s.members.SetLeader("node1", 2)
+
+	// the outgoing leader has to hand over with a newer term
+	if _, err := s.TransferLeadership(context.Background(), &pb.LeadershipTransfer{LeaderId: "node1", Term: 2}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a transfer for the current term to be refused, got %v", err)
+	}
+
+	cfg := &pb.ClusterConfig{Nodes: []*pb.Node{node.InitNode("node0", "localhost", 8080, 5005).ToProto()}, Epoch: 5, LeaderTerm: 2, LeaderId: "node1"}
+	if _, err := s.TransferLeadership(context.Background(), &pb.LeadershipTransfer{LeaderId: "node1", Term: 3, Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+	if leaderId, term := s.members.Leader(); leaderId != "node0" || term != 3 {
+		t.Errorf("expected node0 to lead term 3, got %s term %d", leaderId, term)
+	}
+
+	// a node that is shutting down does not take over
+	other := newTestServer(t, "node2")
+	other.leaving.Store(true)
+	if _, err := other.TransferLeadership(context.Background(), &pb.LeadershipTransfer{LeaderId: "node0", Term: 4}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a leaving node to refuse leadership, got %v", err)
+	}
+}
+
+func TestStepDownOnlyForNewerTerm(t *testing.T) {
+	s := newTestServer(t, "node0")
+	s.members.SetLeader("node0", 3)
+
+	// rejections that do not carry a newer term keep this node leader
+	for _, err := range []error{
+		nil,
+		status.Error(codes.FailedPrecondition, "stale cluster config"),
+		status.Error(codes.Aborted, "aborted without a term"),
+		staleTermError("node0", 3, "same term"),
+		staleTermError("node1", 2, "older term"),
+	} {
+		if s.stepDown(err) {
+			t.Errorf("expected %v not to make the leader step down", err)
+		}
+		if leaderId, _ := s.members.Leader(); leaderId != "node0" {
+			t.Fatalf("leader stepped down on %v", err)
+		}
+	}
+
+	if !s.stepDown(staleTermError("node1", 4, "newer term")) {
+		t.Error("expected a newer term to make the leader step down")
+	}
+	if leaderId, _ := s.members.Leader(); leaderId == "node0" {
+		t.Error("expected node0 to give up leadership")
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/nathang15/go-tinystore/internal/gossip"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sends gossip probes over the gRPC clients of the server
+type gossipTransport struct {
+	s *CacheServer
+}
+
+func (t gossipTransport) Ping(ctx context.Context, target *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error) {
+	c, err := t.s.getNodeClient(node.FromProto(target))
+	if err != nil {
+		return nil, err
+	}
+	return c.Ping(ctx, msg)
+}
+
+func (t gossipTransport) PingReq(ctx context.Context, via *pb.Node, msg *pb.GossipPing) (*pb.GossipAck, error) {
+	c, err := t.s.getNodeClient(node.FromProto(via))
+	if err != nil {
+		return nil, err
+	}
+	return c.PingReq(ctx, msg)
+}
+
+// Use SWIM gossip instead of the leader's status checks to track membership
+func (s *CacheServer) EnableGossip(config gossip.Config) {
+	self, _ := s.members.Get(s.nodeId)
+	s.gossip = gossip.New(self.ToProto(), gossipTransport{s: s}, config)
+}
+
+// Join the gossip group through the known nodes and keep the cluster
+// membership in sync with its events until shutdown
+func (s *CacheServer) RunGossip() {
+	if s.gossip == nil {
+		return
+	}
+	s.logger.Info("Gossip membership starting...")
+
+	go s.applyMembershipEvents(s.gossip.Subscribe())
+
+	var seeds []*pb.Node
+	for id, n := range s.members.Snapshot().Nodes {
+		if id != s.nodeId {
+			seeds = append(seeds, n.ToProto())
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	joined := s.gossip.Join(ctx, seeds)
+	cancel()
+	s.logger.Infof("Joined gossip group through %d of %d nodes", joined, len(seeds))
+
+	s.gossip.Run(s.shutdownChannel)
+}
+
+func (s *CacheServer) applyMembershipEvents(events <-chan gossip.Event) {
+	for event := range events {
+		id := event.Member.Node.Id
+		switch event.Type {
+		case gossip.EVENT_JOIN:
+			if !s.members.Add(node.FromProto(event.Member.Node)) {
+				continue
+			}
+			s.logger.Infof("Node %s joined through gossip", id)
+		case gossip.EVENT_LEAVE:
+			if n, ok := s.members.Get(id); ok && n.Maintenance && event.Member.State == gossip.DEAD {
+				s.logger.Infof("Node %s is dead, keeping it while in maintenance", id)
+				continue
+			}
+			if !s.members.Remove(id) {
+				continue
+			}
+			s.logger.Infof("Node %s is %s, removing from cluster", id, event.Member.State)
+		case gossip.EVENT_SUSPECT:
+			s.logger.Infof("Node %s is suspected to have failed", id)
+			continue
+		case gossip.EVENT_ALIVE:
+			s.logger.Infof("Node %s refuted its suspicion", id)
+			continue
+		}
+
+		// every node follows the gossip view, the leader versions it for clients
+		s.invalidateRing()
+		if s.members.IsLeader(s.nodeId) {
+			if s.members.Snapshot().Slots > 0 {
+				// the hash slots of the node move, the pushed config carries the new slot table
+				s.updateClusterConfigInternal()
+				continue
+			}
+			s.members.NextEpoch()
+		}
+		s.configChanged.notify()
+	}
+}
+
+// Ping answers a gossip probe
+func (s *CacheServer) Ping(ctx context.Context, req *pb.GossipPing) (*pb.GossipAck, error) {
+	if s.gossip == nil {
+		return nil, status.Errorf(codes.Unavailable, "gossip is disabled on node %s", s.nodeId)
+	}
+	return s.gossip.HandlePing(req), nil
+}
+
+// PingReq probes another node on behalf of the caller
+func (s *CacheServer) PingReq(ctx context.Context, req *pb.GossipPing) (*pb.GossipAck, error) {
+	if s.gossip == nil {
+		return nil, status.Errorf(codes.Unavailable, "gossip is disabled on node %s", s.nodeId)
+	}
+	return s.gossip.HandlePingReq(ctx, req)
+}
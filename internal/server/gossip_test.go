@@ -0,0 +1,35 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/gossip"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+func TestGossipLeaveReassignsSlots(t *testing.T) {
+	nodes := []*node.Node{
+		node.InitNode("node0", "localhost", 8080, 5005),
+		node.InitNode("node1", "localhost", 8081, 5006),
+		node.InitNode("node2", "localhost", 8082, 5007),
+	}
+	s := newTestServer(t, "node0", nodes...)
+	s.members.Replace(nodes, &pb.Placement{Algorithm: node.SLOTS, Slots: 16, ReplicationFactor: 1}, 1)
+	s.members.SetLeader("node0", 1)
+	s.refreshSlotTable()
+
+	events := make(chan gossip.Event, 1)
+	events <- gossip.Event{Type: gossip.EVENT_LEAVE, Member: gossip.Member{Node: nodes[2].ToProto(), State: gossip.DEAD}}
+	close(events)
+	s.applyMembershipEvents(events)
+
+	if owners := s.slotTable.Owners(); slices.Contains(owners, "node2") || len(owners) != 2 {
+		t.Errorf("expected the slots of node2 to move to node0 and node1, got owners %v", owners)
+	}
+	published := s.election.(*recordElection).published
+	if len(published) != 1 || published[0].SlotTable == nil || published[0].SlotTable.Version != s.slotTable.Version {
+		t.Errorf("expected the new slot table to be published, got %v", published)
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+const (
+	DEFAULT_MAX_HINTS  = 10000
+	DEFAULT_HINT_TTL   = time.Hour
+	HINT_BATCH_SIZE    = 500
+	HINT_REPLAY_PERIOD = time.Second
+)
+
+// Writes kept by a coordinator for a replica that could not be reached
+type hint struct {
+	key     string
+	value   string
+	version int64
+	created time.Time
+}
+
+// Bounded per-node hint queues. When a queue is full the oldest hint is
+// dropped, hints older than ttl are discarded instead of replayed.
+type hintStore struct {
+	mut      sync.Mutex
+	hints    map[string][]hint
+	maxHints int
+	ttl      time.Duration
+
+	stored   uint64
+	replayed uint64
+	dropped  uint64
+	expired  uint64
+}
+
+// Hint queue metrics
+type HintMetrics struct {
+	Pending  map[string]int `json:"pending"`
+	Stored   uint64         `json:"stored"`
+	Replayed uint64         `json:"replayed"`
+	Dropped  uint64         `json:"dropped"`
+	Expired  uint64         `json:"expired"`
+}
+
+func newHintStore(maxHints int, ttl time.Duration) *hintStore {
+	return &hintStore{hints: make(map[string][]hint), maxHints: maxHints, ttl: ttl}
+}
+
+func (h *hintStore) add(nodeId string, key string, value string, version int64) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	queue := h.expire(nodeId, time.Now())
+	if len(queue) >= h.maxHints {
+		drop := len(queue) - h.maxHints + 1
+		queue = queue[drop:]
+		h.dropped += uint64(drop)
+	}
+	h.hints[nodeId] = append(queue, hint{key: key, value: value, version: version, created: time.Now()})
+	h.stored++
+}
+
+// expire drops hints older than ttl from the queue of a node, caller holds the lock
+func (h *hintStore) expire(nodeId string, now time.Time) []hint {
+	queue := h.hints[nodeId]
+	i := 0
+	for i < len(queue) && now.Sub(queue[i].created) > h.ttl {
+		i++
+	}
+	h.expired += uint64(i)
+	queue = queue[i:]
+	if len(queue) == 0 {
+		delete(h.hints, nodeId)
+	}
+	return queue
+}
+
+// nodes returns the ids of nodes with pending hints
+func (h *hintStore) nodes() []string {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	var ids []string
+	for id := range h.hints {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// peek returns up to n of the oldest unexpired hints for a node
+func (h *hintStore) peek(nodeId string, n int) []hint {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	queue := h.expire(nodeId, time.Now())
+	if len(queue) > n {
+		queue = queue[:n]
+	}
+	return append([]hint{}, queue...)
+}
+
+// ack removes the delivered hints from the front of the queue of a node and
+// counts them as replayed
+func (h *hintStore) ack(nodeId string, delivered []hint) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	queue := h.hints[nodeId]
+	n := 0
+	// hints may have been dropped or expired while delivering, only remove what is still queued
+	for n < len(queue) && n < len(delivered) && queue[n] == delivered[n] {
+		n++
+	}
+	h.replayed += uint64(n)
+	if n == len(queue) {
+		delete(h.hints, nodeId)
+	} else {
+		h.hints[nodeId] = queue[n:]
+	}
+}
+
+func (h *hintStore) metrics() HintMetrics {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	pending := make(map[string]int, len(h.hints))
+	for id, queue := range h.hints {
+		pending[id] = len(queue)
+	}
+	return HintMetrics{Pending: pending, Stored: h.stored, Replayed: h.replayed, Dropped: h.dropped, Expired: h.expired}
+}
+
+// Set bounds of the hinted handoff queues
+func (s *CacheServer) SetHintLimits(maxHints int, ttl time.Duration) {
+	s.hints.mut.Lock()
+	defer s.hints.mut.Unlock()
+	s.hints.maxHints = maxHints
+	s.hints.ttl = ttl
+}
+
+func (s *CacheServer) HintMetrics() HintMetrics {
+	return s.hints.metrics()
+}
+
+// HintMetricsHandler Implementation
+func (s *CacheServer) HintMetricsHandler(client *gin.Context) {
+	client.IndentedJSON(http.StatusOK, s.hints.metrics())
+}
+
+// Replay stored hints to their nodes once they answer status checks again
+func (s *CacheServer) RunHintedHandoff() {
+	s.logger.Info("Hinted handoff starting...")
+
+	ticker := time.NewTicker(HINT_REPLAY_PERIOD)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdownChannel:
+			return
+		case <-ticker.C:
+		}
+
+		for _, nodeId := range s.hints.nodes() {
+			target, ok := s.members.Get(nodeId)
+			if !ok {
+				continue
+			}
+			c, err := s.getNodeClient(target)
+			if err != nil {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err = c.GetStatus(ctx, &pb.StatusRequest{CallerNodeId: s.nodeId})
+			cancel()
+			if err != nil {
+				continue
+			}
+
+			s.deliverHints(nodeId, c)
+		}
+	}
+}
+
+func (s *CacheServer) deliverHints(nodeId string, c pb.CacheServiceClient) {
+	for {
+		batch := s.hints.peek(nodeId, HINT_BATCH_SIZE)
+		if len(batch) == 0 {
+			return
+		}
+
+		req := &pb.HintBatch{CallerNodeId: s.nodeId}
+		for _, h := range batch {
+			req.Hints = append(req.Hints, &pb.Hint{Key: h.key, Value: h.value, Version: h.version})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), REPLICA_TIMEOUT)
+		_, err := c.DeliverHints(ctx, req)
+		cancel()
+		if err != nil {
+			s.logger.Infof("error delivering hints to node %s: %v", nodeId, err)
+			return
+		}
+
+		s.logger.Infof("Delivered %d hints to node %s", len(batch), nodeId)
+		s.hints.ack(nodeId, batch)
+	}
+}
+
+// DeliverHints applies writes that a coordinator held while this node was unreachable
+func (s *CacheServer) DeliverHints(ctx context.Context, req *pb.HintBatch) (*pb.GenericResponse, error) {
+	s.logger.Infof("Received %d hints from node %s", len(req.Hints), req.CallerNodeId)
+	for _, h := range req.Hints {
+		// the hint timestamp is the version of the write, newer local writes win
+		s.cache.PutVersioned(h.Key, h.Value, h.Version)
+	}
+	return &pb.GenericResponse{Data: SUCCESS}, nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHintStore(t *testing.T) {
+	h := newHintStore(2, time.Hour)
+	h.add("node1", "a", "1", 1)
+	h.add("node1", "b", "2", 2)
+	h.add("node1", "c", "3", 3)
+
+	batch := h.peek("node1", 10)
+	if len(batch) != 2 || batch[0].key != "b" || batch[1].key != "c" {
+		t.Errorf("expected full queue to drop the oldest hint, got %v", batch)
+	}
+
+	h.ack("node1", batch[:1])
+	if pending := h.metrics().Pending["node1"]; pending != 1 {
+		t.Errorf("expected 1 pending hint after ack, got %d", pending)
+	}
+
+	m := h.metrics()
+	if m.Stored != 3 || m.Dropped != 1 || m.Replayed != 1 {
+		t.Errorf("unexpected hint metrics %+v", m)
+	}
+
+	// a hint dropped while its batch was delivered is not acked, nor counted as replayed
+	h.add("node1", "d", "4", 4)
+	h.add("node1", "e", "5", 5)
+	h.ack("node1", batch[1:])
+	if m := h.metrics(); m.Replayed != 1 || m.Pending["node1"] != 2 {
+		t.Errorf("expected only acked hints to count as replayed, got %+v", m)
+	}
+
+	h.ttl = 0
+	if batch := h.peek("node1", 10); len(batch) != 0 {
+		t.Errorf("expected expired hints to be discarded, got %v", batch)
+	}
+	if m := h.metrics(); m.Expired != 2 || len(m.Pending) != 0 {
+		t.Errorf("unexpected hint metrics after expiry %+v", m)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"github.com/nathang15/go-tinystore/internal/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PersistIdentity keeps the node id and cluster id of this node in dir, so it
+// comes back under the same identity after a restart. The cluster id is
+// pinned, persisted, taken from the config file or adopted from the first
+// cluster config the node accepts.
+func (s *CacheServer) PersistIdentity(dir string, persisted node.Identity, clusterId string) error {
+	s.identityMut.Lock()
+	defer s.identityMut.Unlock()
+
+	if persisted.NodeId != "" && persisted.NodeId != s.nodeId {
+		s.logger.Infof("Node id pinned to %s, replacing persisted id %s", s.nodeId, persisted.NodeId)
+	}
+	identity := node.Identity{NodeId: s.nodeId, ClusterId: persisted.ClusterId}
+	if clusterId != "" {
+		identity.ClusterId = clusterId
+	} else if identity.ClusterId == "" {
+		identity.ClusterId = s.identity.ClusterId
+	}
+
+	s.dataDir = dir
+	s.identity = identity
+	return s.saveIdentity()
+}
+
+// saveIdentity writes the identity to the data directory, if there is one. Caller holds identityMut.
+func (s *CacheServer) saveIdentity() error {
+	if s.dataDir == "" {
+		return nil
+	}
+	return s.identity.Save(s.dataDir)
+}
+
+// Id of the cluster this node belongs to, empty until it is known
+func (s *CacheServer) clusterId() string {
+	s.identityMut.Lock()
+	defer s.identityMut.Unlock()
+	return s.identity.ClusterId
+}
+
+// adoptClusterId refuses a cluster config of another cluster. A node that
+// does not know its cluster yet joins the one of the config.
+func (s *CacheServer) adoptClusterId(clusterId string) error {
+	s.identityMut.Lock()
+	defer s.identityMut.Unlock()
+
+	if clusterId == "" || clusterId == s.identity.ClusterId {
+		return nil
+	}
+	if s.identity.ClusterId != "" {
+		return status.Errorf(codes.FailedPrecondition, "node %s belongs to cluster %s, not %s", s.nodeId, s.identity.ClusterId, clusterId)
+	}
+	s.identity.ClusterId = clusterId
+	s.logger.Infof("Joined cluster %s", clusterId)
+	if err := s.saveIdentity(); err != nil {
+		s.logger.Errorf("unable to persist cluster id: %v", err)
+	}
+	return nil
+}
+
+// ensureClusterId names the cluster when a node wins leadership without
+// knowing its cluster yet. Only the leader calls it, a follower learns the id
+// from the configs of the leader.
+func (s *CacheServer) ensureClusterId() {
+	s.identityMut.Lock()
+	defer s.identityMut.Unlock()
+
+	if s.identity.ClusterId != "" {
+		return
+	}
+	s.identity.ClusterId = node.NewClusterId()
+	s.logger.Infof("Starting new cluster %s", s.identity.ClusterId)
+	if err := s.saveIdentity(); err != nil {
+		s.logger.Errorf("unable to persist cluster id: %v", err)
+	}
+}
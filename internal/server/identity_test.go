@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPersistIdentity(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, "node7")
+	if err := s.PersistIdentity(dir, node.Identity{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if identity, err := node.LoadIdentity(dir); err != nil || identity.NodeId != "node7" || identity.ClusterId != "" {
+		t.Fatalf("expected node7 without a cluster to be persisted, got %v %v", identity, err)
+	}
+
+	// the first config names the cluster, configs of other clusters are refused from then on
+	nodes := []*pb.Node{node.InitNode("node7", "localhost", 8080, 5005).ToProto()}
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 1, ClusterId: "blue"}); err != nil {
+		t.Fatal(err)
+	}
+	if identity, _ := node.LoadIdentity(dir); identity.ClusterId != "blue" {
+		t.Errorf("expected cluster blue to be persisted, got %v", identity)
+	}
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 2, ClusterId: "green"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a config of another cluster to be refused, got %v", err)
+	}
+	if cfg := s.clusterConfig(); cfg.ClusterId != "blue" {
+		t.Errorf("expected served config to name cluster blue, got %s", cfg.ClusterId)
+	}
+
+	// a restarted node comes back under its persisted identity unless it is pinned
+	persisted, _ := node.LoadIdentity(dir)
+	restarted := newTestServer(t, "node8")
+	if err := restarted.PersistIdentity(dir, persisted, "red"); err != nil {
+		t.Fatal(err)
+	}
+	if identity, _ := node.LoadIdentity(dir); identity.NodeId != "node8" || identity.ClusterId != "red" {
+		t.Errorf("expected pinned node8 in cluster red, got %v", identity)
+	}
+}
+
+func TestOnlyLeaderNamesCluster(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, "node1", node.InitNode("node0", "localhost", 8080, 5005), node.InitNode("node1", "localhost", 8081, 5006))
+	if err := s.PersistIdentity(dir, node.Identity{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.members.SetLeader("node0", 1)
+
+	// a follower neither names the cluster nor versions a config
+	epoch := s.members.Epoch()
+	s.updateClusterConfigInternal()
+	if identity, _ := node.LoadIdentity(dir); identity.ClusterId != "" || s.members.Epoch() != epoch {
+		t.Errorf("expected the follower to publish nothing, got cluster %q epoch %d", identity.ClusterId, s.members.Epoch())
+	}
+
+	// the config of the leader names the cluster
+	cfg := &pb.ClusterConfig{Nodes: s.clusterConfig().Nodes, Epoch: epoch + 1, LeaderId: "node0", LeaderTerm: 1, ClusterId: "blue"}
+	if _, err := s.UpdateClusterConfig(context.Background(), cfg); err != nil {
+		t.Fatalf("expected the config of the leader to be applied, got %v", err)
+	}
+	if identity, _ := node.LoadIdentity(dir); identity.ClusterId != "blue" {
+		t.Errorf("expected cluster blue from the leader, got %v", identity)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/pkg/store"
+)
+
+const (
+	DEFAULT_MERKLE_DEPTH = 6
+	MAX_MERKLE_DEPTH     = 16
+)
+
+// Merkle tree over the keys of one token range. The range is split into
+// 2^depth equal leaves, a leaf hash is the XOR of the hashes of its entries so
+// it does not depend on iteration order, and every inner node hashes its two
+// children. Nodes are stored as a flat heap: the children of i are 2i+1 and
+// 2i+2 and the leaves start at 2^depth-1.
+type merkleTree struct {
+	depth  int
+	hashes []uint64
+}
+
+// merkleLeaf returns the leaf of a tree over tr that a key hash falls into
+func merkleLeaf(tr ch.TokenRange, h uint32, depth int) int {
+	return int(tr.Offset(h) << depth / tr.Width())
+}
+
+// Cache entry with the position of its key on the ring
+type hashedEntry struct {
+	store.Entry
+	hash uint32
+}
+
+// bucketByRange hashes the key of every entry once and groups the entries by
+// the range they fall into. The ranges are in ring order as TokenRanges
+// returns them, range i ends where range i+1 starts.
+func bucketByRange(entries []store.Entry, ranges []ch.TokenRange, keyHash func(string) uint32) [][]hashedEntry {
+	buckets := make([][]hashedEntry, len(ranges))
+	if len(ranges) == 0 {
+		return buckets
+	}
+	for _, entry := range entries {
+		h := keyHash(entry.Key)
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].End >= h })
+		if i == len(ranges) {
+			// past the last range end, the first range wraps around
+			i = 0
+		}
+		buckets[i] = append(buckets[i], hashedEntry{Entry: entry, hash: h})
+	}
+	return buckets
+}
+
+func entryHash(entry store.Entry) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(entry.Key))
+	hasher.Write([]byte{0})
+	binary.Write(hasher, binary.BigEndian, entry.Version)
+	hasher.Write([]byte(entry.Value))
+	return hasher.Sum64()
+}
+
+// buildMerkleTree builds the tree of tr from the entries of its bucket,
+// entries outside of tr are skipped
+func buildMerkleTree(entries []hashedEntry, tr ch.TokenRange, depth int) *merkleTree {
+	leaves := 1 << depth
+	t := &merkleTree{depth: depth, hashes: make([]uint64, 2*leaves-1)}
+	for _, entry := range entries {
+		if tr.Contains(entry.hash) {
+			t.hashes[leaves-1+merkleLeaf(tr, entry.hash, depth)] ^= entryHash(entry.Entry)
+		}
+	}
+
+	buf := make([]byte, 16)
+	for i := leaves - 2; i >= 0; i-- {
+		left, right := t.hashes[2*i+1], t.hashes[2*i+2]
+		if left == 0 && right == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint64(buf, left)
+		binary.BigEndian.PutUint64(buf[8:], right)
+		hasher := fnv.New64a()
+		hasher.Write(buf)
+		t.hashes[i] = hasher.Sum64()
+	}
+	return t
+}
+
+// diffLeaves walks both trees from the root and returns the leaves whose
+// hashes differ, only descending into subtrees that do not match
+func diffLeaves(a *merkleTree, b *merkleTree) []int {
+	if a.depth != b.depth || len(a.hashes) != len(b.hashes) {
+		return nil
+	}
+
+	leaves := 1 << a.depth
+	var diff []int
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if a.hashes[i] == b.hashes[i] {
+			continue
+		}
+		if i >= leaves-1 {
+			diff = append(diff, i-(leaves-1))
+			continue
+		}
+		stack = append(stack, 2*i+2, 2*i+1)
+	}
+	return diff
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/pkg/store"
+)
+
+func TestMerkleDiff(t *testing.T) {
+	r := ch.InitRing(10)
+	for _, id := range []string{"node0", "node1", "node2"} {
+		r.Add(id, "localhost", 8080, 5005)
+	}
+	tr := ch.TokenRange{Start: 0, End: 0}
+
+	a, b := store.New(100), store.New(100)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		a.PutVersioned(key, "v", 1)
+		b.PutVersioned(key, "v", 1)
+	}
+	ranges := []ch.TokenRange{tr}
+	ta := buildMerkleTree(bucketByRange(a.Entries(), ranges, r.KeyHash)[0], tr, DEFAULT_MERKLE_DEPTH)
+	tb := buildMerkleTree(bucketByRange(b.Entries(), ranges, r.KeyHash)[0], tr, DEFAULT_MERKLE_DEPTH)
+	if diff := diffLeaves(ta, tb); len(diff) != 0 {
+		t.Errorf("expected equal trees for equal entries regardless of order, got diff %v", diff)
+	}
+
+	b.PutVersioned("key7", "w", 2)
+	tb = buildMerkleTree(bucketByRange(b.Entries(), ranges, r.KeyHash)[0], tr, DEFAULT_MERKLE_DEPTH)
+	diff := diffLeaves(ta, tb)
+	if len(diff) != 1 || diff[0] != merkleLeaf(tr, r.KeyHash("key7"), DEFAULT_MERKLE_DEPTH) {
+		t.Errorf("expected only the leaf of key7 to differ, got %v", diff)
+	}
+}
+
+func TestBucketByRange(t *testing.T) {
+	r := ch.InitRing(10)
+	for _, id := range []string{"node0", "node1", "node2"} {
+		r.Add(id, "localhost", 8080, 5005)
+	}
+	ranges := r.TokenRanges(2)
+
+	cache := store.New(1000)
+	for i := 0; i < 500; i++ {
+		cache.PutVersioned(fmt.Sprintf("key%d", i), "v", 1)
+	}
+	buckets := bucketByRange(cache.Entries(), ranges, r.KeyHash)
+
+	// every entry lands in the one range that contains its key
+	total := 0
+	for i, bucket := range buckets {
+		for _, entry := range bucket {
+			if entry.hash != r.KeyHash(entry.Key) || !ranges[i].Contains(entry.hash) {
+				t.Errorf("key %s in range %d (%d, %d]", entry.Key, i, ranges[i].Start, ranges[i].End)
+			}
+		}
+		total += len(bucket)
+	}
+	if total != 500 {
+		t.Errorf("expected 500 bucketed entries, got %d", total)
+	}
+}
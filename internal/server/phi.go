@@ -0,0 +1,136 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_PHI_THRESHOLD = 8.0
+	DEFAULT_PHI_SUSTAIN   = 5 * time.Second
+	// number of heartbeat intervals kept per node
+	PHI_WINDOW = 100
+	// lower bound of the interval deviation so a very regular history does not make phi jumpy
+	PHI_MIN_STD_DEV = 100 * time.Millisecond
+	// interval assumed for a node until its first heartbeats arrive
+	PHI_FIRST_INTERVAL = time.Second
+)
+
+// Phi-accrual failure detector. Instead of a binary up/down answer it
+// estimates from the history of heartbeat inter-arrival times how unlikely
+// it is that a heartbeat is still on its way: phi = -log10(P(interval > now - last)).
+// A phi of 8 means the chance of a false positive is about 1e-8.
+type phiDetector struct {
+	mut       sync.Mutex
+	threshold float64
+	// how long phi has to stay above the threshold before a node is removed
+	sustain time.Duration
+	nodes   map[string]*heartbeatHistory
+}
+
+type heartbeatHistory struct {
+	// inter-arrival times in milliseconds, oldest first
+	intervals    []float64
+	last         time.Time
+	suspectSince time.Time
+}
+
+func newPhiDetector(threshold float64, sustain time.Duration) *phiDetector {
+	return &phiDetector{threshold: threshold, sustain: sustain, nodes: make(map[string]*heartbeatHistory)}
+}
+
+// Set the phi threshold and how long a node has to stay above it before it is removed
+func (s *CacheServer) SetFailureDetector(threshold float64, sustain time.Duration) {
+	s.detector.mut.Lock()
+	defer s.detector.mut.Unlock()
+	s.detector.threshold = threshold
+	s.detector.sustain = sustain
+}
+
+// history returns the history of a node, starting one at now for unknown nodes. Caller holds the lock.
+func (d *phiDetector) history(id string, now time.Time) *heartbeatHistory {
+	h, ok := d.nodes[id]
+	if !ok {
+		h = &heartbeatHistory{last: now}
+		d.nodes[id] = h
+	}
+	return h
+}
+
+// heartbeat records a successful status check of a node
+func (d *phiDetector) heartbeat(id string, now time.Time) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	h, ok := d.nodes[id]
+	if !ok {
+		d.nodes[id] = &heartbeatHistory{last: now}
+		return
+	}
+	h.intervals = append(h.intervals, float64(now.Sub(h.last).Milliseconds()))
+	if len(h.intervals) > PHI_WINDOW {
+		h.intervals = h.intervals[1:]
+	}
+	h.last = now
+	h.suspectSince = time.Time{}
+}
+
+// phi returns the suspicion level of a node at now
+func (d *phiDetector) phi(id string, now time.Time) float64 {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.history(id, now).phi(now)
+}
+
+func (h *heartbeatHistory) phi(now time.Time) float64 {
+	mean := float64(PHI_FIRST_INTERVAL.Milliseconds())
+	stdDev := mean / 4
+	if len(h.intervals) > 0 {
+		sum := 0.0
+		for _, interval := range h.intervals {
+			sum += interval
+		}
+		mean = sum / float64(len(h.intervals))
+		variance := 0.0
+		for _, interval := range h.intervals {
+			variance += (interval - mean) * (interval - mean)
+		}
+		stdDev = math.Sqrt(variance / float64(len(h.intervals)))
+	}
+	stdDev = math.Max(stdDev, float64(PHI_MIN_STD_DEV.Milliseconds()))
+
+	// logistic approximation of the normal CDF
+	elapsed := float64(now.Sub(h.last).Milliseconds())
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+// check records a missed heartbeat and reports the current phi of a node and
+// whether it stayed above the threshold for long enough to be removed
+func (d *phiDetector) check(id string, now time.Time) (float64, bool) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	h := d.history(id, now)
+	phi := h.phi(now)
+	if phi < d.threshold {
+		h.suspectSince = time.Time{}
+		return phi, false
+	}
+	if h.suspectSince.IsZero() {
+		h.suspectSince = now
+	}
+	return phi, now.Sub(h.suspectSince) >= d.sustain
+}
+
+// forget drops the history of a node that left the cluster
+func (d *phiDetector) forget(id string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	delete(d.nodes, id)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhiDetector(t *testing.T) {
+	d := newPhiDetector(DEFAULT_PHI_THRESHOLD, 5*time.Second)
+	start := time.Now()
+	now := start
+	for i := 0; i < 20; i++ {
+		d.heartbeat("node1", now)
+		now = now.Add(time.Second)
+	}
+	last := now.Add(-time.Second)
+
+	if phi := d.phi("node1", last.Add(time.Second)); phi > 1 {
+		t.Errorf("expected low phi for a heartbeat on time, got %.2f", phi)
+	}
+	if low, high := d.phi("node1", last.Add(1500*time.Millisecond)), d.phi("node1", last.Add(3*time.Second)); low >= high {
+		t.Errorf("expected phi to grow with the time since the last heartbeat, got %.2f then %.2f", low, high)
+	}
+
+	// a single late heartbeat suspects the node but does not remove it
+	if _, remove := d.check("node1", last.Add(4*time.Second)); remove {
+		t.Errorf("expected a short pause not to remove the node")
+	}
+	if phi, remove := d.check("node1", last.Add(8*time.Second)); remove || phi < DEFAULT_PHI_THRESHOLD {
+		t.Errorf("expected the node to be suspected but not yet removed, got phi %.2f remove %v", phi, remove)
+	}
+	if _, remove := d.check("node1", last.Add(14*time.Second)); !remove {
+		t.Errorf("expected sustained suspicion to remove the node")
+	}
+
+	// a heartbeat clears the suspicion
+	d.heartbeat("node1", last.Add(15*time.Second))
+	if _, remove := d.check("node1", last.Add(16*time.Second)); remove {
+		t.Errorf("expected a heartbeat to reset the suspicion")
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/internal/raft"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ELECTION_BULLY = "bully"
+	ELECTION_RAFT  = "raft"
+	// how often a leader handing over checks whether its successor took over
+	RAFT_HANDOVER_POLL = 20 * time.Millisecond
+)
+
+// Raft election, the leader appends every cluster config to a replicated
+// log and nodes adopt a config once a majority stored it
+type raftElection struct {
+	s    *CacheServer
+	node *raft.Node
+}
+
+// Sends raft messages over the gRPC clients of the server
+type raftTransport struct {
+	s *CacheServer
+}
+
+func (t raftTransport) client(peer string) (pb.CacheServiceClient, error) {
+	n, ok := t.s.members.Get(peer)
+	if !ok {
+		return nil, fmt.Errorf("node %s not in cluster config", peer)
+	}
+	return t.s.getNodeClient(n)
+}
+
+func (t raftTransport) RequestVote(ctx context.Context, peer string, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestVote(ctx, req)
+}
+
+func (t raftTransport) AppendEntries(ctx context.Context, peer string, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.AppendEntries(ctx, req)
+}
+
+func (t raftTransport) TimeoutNow(ctx context.Context, peer string, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.TimeoutNow(ctx, req)
+}
+
+// Elect the leader and replicate cluster configs with raft instead of the
+// bully algorithm. The raft state is kept in dir, or only in memory if empty.
+func (s *CacheServer) EnableRaft(config raft.Config, dir string) error {
+	var storage raft.Storage = &raft.MemoryStorage{}
+	if dir != "" {
+		fileStorage, err := raft.NewFileStorage(dir)
+		if err != nil {
+			return err
+		}
+		storage = fileStorage
+	}
+
+	if self, ok := s.members.Get(s.nodeId); ok {
+		config.NeverCampaign = self.NeverLeader
+	}
+	e := &raftElection{s: s}
+	node, err := raft.New(s.nodeId, s.members.Ids(), raftTransport{s: s}, storage, e, config)
+	if err != nil {
+		return err
+	}
+	e.node = node
+	s.election = e
+	return nil
+}
+
+// Raft elects a leader on its own timer once Monitor runs
+func (e *raftElection) Elect() {}
+
+func (e *raftElection) Monitor() {
+	go e.node.Run(e.s.shutdownChannel)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.s.shutdownChannel:
+			return
+		case <-ticker.C:
+		}
+		if e.s.members.IsLeader(e.s.nodeId) && e.s.gossip == nil {
+			e.s.checkNodes()
+		}
+	}
+}
+
+func (e *raftElection) Publish(cfg *pb.ClusterConfig) {
+	data, err := proto.Marshal(cfg)
+	if err != nil {
+		e.s.logger.Errorf("unable to encode cluster config: %v", err)
+		return
+	}
+	index, term, err := e.node.Propose(data)
+	if err != nil {
+		e.s.logger.Infof("unable to append cluster config epoch %d: %v", cfg.Epoch, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), REPLICA_TIMEOUT)
+	defer cancel()
+	if err := e.node.Wait(ctx, index, term); err != nil {
+		e.s.logger.Infof("cluster config epoch %d not committed: %v", cfg.Epoch, err)
+	}
+}
+
+// Apply adopts a committed cluster config and its members as raft voters
+func (e *raftElection) Apply(entry *pb.RaftEntry) {
+	cfg := &pb.ClusterConfig{}
+	if err := proto.Unmarshal(entry.Data, cfg); err != nil {
+		e.s.logger.Errorf("unable to decode cluster config at raft index %d: %v", entry.Index, err)
+		return
+	}
+	if err := e.s.adoptClusterId(cfg.ClusterId); err != nil {
+		e.s.logger.Errorf("skipping cluster config at raft index %d: %v", entry.Index, err)
+		return
+	}
+	e.s.logger.Infof("Applying cluster config epoch %d from raft index %d", cfg.Epoch, entry.Index)
+	e.s.configMut.Lock()
+	e.s.applyClusterConfig(cfg)
+	e.s.configMut.Unlock()
+	e.node.SetPeers(e.s.members.Ids())
+}
+
+// handOver hands raft leadership to the most caught up of candidates, ranked
+// best first, and waits until this node follows the new leader
+func (e *raftElection) handOver(ctx context.Context, candidates []*node.Node) error {
+	var ids []string
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.Id)
+	}
+	successor, err := e.node.TransferLeadership(ctx, ids)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "unable to hand over raft leadership: %v", err)
+	}
+	e.s.logger.Infof("Handing raft leadership over to node %s", successor)
+
+	ticker := time.NewTicker(RAFT_HANDOVER_POLL)
+	defer ticker.Stop()
+	for {
+		if leaderId, _ := e.node.Leader(); leaderId == successor {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return status.Errorf(codes.DeadlineExceeded, "node %s did not take over raft leadership: %v", successor, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *raftElection) LeaderChanged(leaderId string, term int64) {
+	if leaderId == "" {
+		e.s.logger.Infof("No leader in raft term %d", term)
+		e.s.members.SetLeader(NO_LEADER, term)
+		return
+	}
+	e.s.logger.Infof("Node %s leads raft term %d", leaderId, term)
+	e.s.members.SetLeader(leaderId, term)
+	if leaderId == e.s.nodeId {
+		e.s.ensureClusterId()
+	}
+}
+
+// RequestVote answers a raft candidate
+func (s *CacheServer) RequestVote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	e, ok := s.election.(*raftElection)
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "raft is disabled on node %s", s.nodeId)
+	}
+	return e.node.HandleRequestVote(req)
+}
+
+// TimeoutNow starts a raft election at once on request of the leader handing over leadership
+func (s *CacheServer) TimeoutNow(ctx context.Context, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	e, ok := s.election.(*raftElection)
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "raft is disabled on node %s", s.nodeId)
+	}
+	s.logger.Infof("Starting a raft election on request of leader %s", req.LeaderId)
+	return e.node.HandleTimeoutNow(req)
+}
+
+// AppendEntries stores raft log entries sent by the leader
+func (s *CacheServer) AppendEntries(ctx context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	e, ok := s.election.(*raftElection)
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "raft is disabled on node %s", s.nodeId)
+	}
+	return e.node.HandleAppendEntries(req)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/internal/raft"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRaftAppliesCommittedConfigs(t *testing.T) {
+	s := newTestServer(t, "node0")
+	if err := s.EnableRaft(raft.DefaultConfig(), ""); err != nil {
+		t.Fatal(err)
+	}
+	e := s.election.(*raftElection)
+
+	nodes := []*pb.Node{node.InitNode("node0", "localhost", 8080, 5005).ToProto(), node.InitNode("node1", "localhost", 8081, 5006).ToProto()}
+	data, err := proto.Marshal(&pb.ClusterConfig{Nodes: nodes, Epoch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Apply(&pb.RaftEntry{Term: 1, Index: 2, Data: data})
+	if len(s.members.Ids()) != 2 || s.members.Epoch() != 2 {
+		t.Errorf("expected committed config with 2 nodes at epoch 2, got epoch %d %v", s.members.Epoch(), s.members.Ids())
+	}
+
+	e.LeaderChanged("node1", 3)
+	if leaderId, term := s.members.Leader(); leaderId != "node1" || term != 3 {
+		t.Errorf("expected node1 to lead term 3, got %s term %d", leaderId, term)
+	}
+	e.LeaderChanged("", 4)
+	if leaderId, term := s.members.Leader(); leaderId != NO_LEADER || term != 4 {
+		t.Errorf("expected no leader in term 4, got %s term %d", leaderId, term)
+	}
+
+	// configs only change through the log
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes[:1], Epoch: 9, LeaderTerm: 9}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected pushed config to be rejected with raft, got %v", err)
+	}
+}
+
+func TestRaftTransferLeadership(t *testing.T) {
+	s := newTestServer(t, "node0", node.InitNode("node0", "localhost", 8080, 5005))
+	if err := s.EnableRaft(raft.DefaultConfig(), ""); err != nil {
+		t.Fatal(err)
+	}
+	e := s.election.(*raftElection)
+	e.node.Tick(time.Now().Add(time.Hour))
+	if !s.members.IsLeader("node0") {
+		t.Fatal("expected the single raft node to lead")
+	}
+
+	// node1 is not a raft voter yet, it cannot catch up and take over
+	s.members.Add(node.InitNode("node1", "localhost", 8081, 5006))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.transferLeadership(ctx, "node1"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected no raft voter to take over, got %v", err)
+	}
+	if !s.members.IsLeader("node0") {
+		t.Error("expected node0 to keep leading after a failed transfer")
+	}
+	if _, _, err := e.node.Propose(nil); err != nil {
+		t.Errorf("expected proposals to be accepted after a failed transfer, got %v", err)
+	}
+}
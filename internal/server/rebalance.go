@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/pb"
+	"github.com/nathang15/go-tinystore/pkg/store"
+)
+
+const (
+	DEFAULT_REBALANCE_PROXY_WINDOW = 30 * time.Second
+	REBALANCE_BATCH_SIZE           = 500
+	REBALANCE_TIMEOUT              = time.Minute
+	// wait for the rest of a config update to land before comparing placements
+	REBALANCE_DELAY = 500 * time.Millisecond
+)
+
+type rebalancer struct {
+	mut     sync.Mutex
+	trigger chan bool
+	// placement the local data was last balanced for
+	last *placementSnapshot
+	// placement before the last change, consulted by proxy reads until previousUntil
+	previous      *placementSnapshot
+	previousUntil time.Time
+	proxyWindow   time.Duration
+	// set once this node registered with a running cluster and starts out empty
+	joined       atomic.Bool
+	progress     RebalanceProgress
+	proxiedReads atomic.Uint64
+}
+
+// Progress of the latest rebalance round
+type RebalanceProgress struct {
+	Running      bool           `json:"running"`
+	Rounds       uint64         `json:"rounds"`
+	Started      time.Time      `json:"started"`
+	Finished     time.Time      `json:"finished"`
+	KeysToMove   int            `json:"keysToMove"`
+	KeysSent     int            `json:"keysSent"`
+	KeysDeleted  int            `json:"keysDeleted"`
+	Failures     int            `json:"failures"`
+	Pending      map[string]int `json:"pending"`
+	ProxyUntil   time.Time      `json:"proxyUntil"`
+	ProxiedReads uint64         `json:"proxiedReads"`
+}
+
+// A local entry that has to be copied to new replicas after a placement
+// change, and dropped once copied if this node no longer replicates it
+type keyMove struct {
+	entry   store.Entry
+	targets []string
+	drop    bool
+}
+
+func newRebalancer() *rebalancer {
+	return &rebalancer{trigger: make(chan bool, 1), proxyWindow: DEFAULT_REBALANCE_PROXY_WINDOW}
+}
+
+// Set how long reads that miss are proxied to the previous owners of a key
+// after a placement change, 0 disables proxy reads
+func (s *CacheServer) SetRebalanceProxyWindow(window time.Duration) {
+	s.rebalancer.mut.Lock()
+	defer s.rebalancer.mut.Unlock()
+	s.rebalancer.proxyWindow = window
+}
+
+// Schedule a rebalance after the cluster config changed
+func (s *CacheServer) triggerRebalance() {
+	select {
+	case s.rebalancer.trigger <- true:
+	default:
+	}
+}
+
+// Move keys to their new owners whenever membership or placement changes.
+// Old owners stream the keys that moved to the new replicas and then drop
+// the ones they no longer replicate.
+func (s *CacheServer) RunRebalancer() {
+	s.logger.Info("Rebalancer starting...")
+
+	p, err := s.currentPlacement()
+	if err != nil {
+		s.logger.Errorf("unable to start rebalancer: %v", err)
+		return
+	}
+	s.rebalancer.mut.Lock()
+	s.rebalancer.last = p
+	if s.rebalancer.joined.Load() {
+		// a node that just joined owns ranges it has no data for yet
+		if prev, err := s.placementWithout(s.nodeId); err == nil {
+			s.rebalancer.previous = prev
+			s.rebalancer.previousUntil = time.Now().Add(s.rebalancer.proxyWindow)
+		}
+	}
+	s.rebalancer.mut.Unlock()
+
+	for {
+		select {
+		case <-s.shutdownChannel:
+			return
+		case <-s.rebalancer.trigger:
+		}
+		time.Sleep(REBALANCE_DELAY)
+		s.rebalance()
+	}
+}
+
+func (s *CacheServer) rebalance() {
+	next, err := s.currentPlacement()
+	if err != nil {
+		s.logger.Errorf("unable to rebalance: %v", err)
+		return
+	}
+
+	r := s.rebalancer
+	r.mut.Lock()
+	prev := r.last
+	if prev == nil || (prev.ring == next.ring && prev.slots == next.slots && prev.n == next.n) {
+		r.mut.Unlock()
+		return
+	}
+	r.last = next
+	r.previous = prev
+	r.previousUntil = time.Now().Add(r.proxyWindow)
+
+	moves := planMoves(s.nodeId, s.cache.Entries(), prev, next)
+	byTarget := make(map[string][]store.Entry)
+	for _, move := range moves {
+		for _, target := range move.targets {
+			byTarget[target] = append(byTarget[target], move.entry)
+		}
+	}
+
+	r.startProgress(byTarget)
+	keysToMove := r.progress.KeysToMove
+	r.mut.Unlock()
+
+	s.logger.Infof("Rebalancing %d keys to %d nodes", keysToMove, len(byTarget))
+
+	ctx, cancel := context.WithTimeout(context.Background(), REBALANCE_TIMEOUT)
+	defer cancel()
+	failed := make(map[string]bool)
+	for target, entries := range byTarget {
+		if err := s.migrateKeys(ctx, target, entries); err != nil {
+			s.logger.Infof("migrating %d keys to node %s failed: %v", len(entries), target, err)
+			failed[target] = true
+			r.mut.Lock()
+			r.progress.Failures++
+			r.mut.Unlock()
+		}
+	}
+
+	deleted := 0
+	for _, move := range moves {
+		if !move.drop || slices.ContainsFunc(move.targets, func(id string) bool { return failed[id] }) {
+			continue
+		}
+		// a newer write that arrived meanwhile is kept
+		if s.cache.DeleteVersioned(move.entry.Key, move.entry.Version) {
+			deleted++
+		}
+	}
+
+	r.mut.Lock()
+	r.progress.KeysDeleted = deleted
+	r.progress.Running = false
+	r.progress.Finished = time.Now()
+	r.mut.Unlock()
+	s.logger.Infof("Rebalance finished, dropped %d keys no longer owned", deleted)
+}
+
+// startProgress resets the progress for a new round, caller holds the lock
+func (r *rebalancer) startProgress(byTarget map[string][]store.Entry) {
+	r.progress = RebalanceProgress{
+		Running:    true,
+		Rounds:     r.progress.Rounds + 1,
+		Started:    time.Now(),
+		Pending:    make(map[string]int, len(byTarget)),
+		ProxyUntil: r.previousUntil,
+	}
+	for target, entries := range byTarget {
+		r.progress.KeysToMove += len(entries)
+		r.progress.Pending[target] = len(entries)
+	}
+}
+
+// planMoves works out which local entries have to be copied to which nodes
+// after the placement changed from prev to next. Of the previous replicas of
+// a key only the first one that is still a member sends it, a node holding a
+// key it did not replicate sends it to all new replicas.
+func planMoves(selfId string, entries []store.Entry, prev *placementSnapshot, next *placementSnapshot) []keyMove {
+	var moves []keyMove
+	for _, entry := range entries {
+		before := prev.replicas(entry.Key)
+		after := next.replicas(entry.Key)
+
+		move := keyMove{entry: entry, drop: !slices.Contains(after, selfId)}
+		if isSender(selfId, before, next.members) {
+			for _, id := range after {
+				if id != selfId && (!slices.Contains(before, id) || !slices.Contains(before, selfId)) {
+					move.targets = append(move.targets, id)
+				}
+			}
+		}
+		if move.drop || len(move.targets) > 0 {
+			moves = append(moves, move)
+		}
+	}
+	return moves
+}
+
+func isSender(selfId string, before []string, members map[string]bool) bool {
+	if !slices.Contains(before, selfId) {
+		return true
+	}
+	for _, id := range before {
+		if members[id] {
+			return id == selfId
+		}
+	}
+	return false
+}
+
+// migrateKeys streams entries to a node in batches
+func (s *CacheServer) migrateKeys(ctx context.Context, targetId string, entries []store.Entry) error {
+	target, ok := s.members.Get(targetId)
+	if !ok {
+		return nil
+	}
+	c, err := s.getNodeClient(target)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.MigrateKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(entries); start += REBALANCE_BATCH_SIZE {
+		end := min(start+REBALANCE_BATCH_SIZE, len(entries))
+		batch := &pb.MigrationBatch{CallerNodeId: s.nodeId}
+		for _, entry := range entries[start:end] {
+			batch.Entries = append(batch.Entries, &pb.Entry{Key: entry.Key, Value: entry.Value, Version: entry.Version})
+		}
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+
+		s.rebalancer.mut.Lock()
+		s.rebalancer.progress.KeysSent += end - start
+		s.rebalancer.progress.Pending[targetId] -= end - start
+		s.rebalancer.mut.Unlock()
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// MigrateKeys stores the keys streamed by their previous owner
+func (s *CacheServer) MigrateKeys(stream pb.CacheService_MigrateKeysServer) error {
+	received := 0
+	callerId := ""
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		callerId = batch.CallerNodeId
+		for _, entry := range batch.Entries {
+			s.cache.PutVersioned(entry.Key, entry.Value, entry.Version)
+		}
+		received += len(batch.Entries)
+	}
+	s.logger.Infof("Received %d migrated keys from node %s", received, callerId)
+	return stream.SendAndClose(&pb.GenericResponse{Data: SUCCESS})
+}
+
+// Placement of the current cluster without one node, what the cluster looked
+// like before that node joined. With fixed-partition placement the slots of
+// the node go where the leader assigns them once the node is gone.
+func (s *CacheServer) placementWithout(id string) (*placementSnapshot, error) {
+	cfg := s.clusterConfig()
+	cfg.SlotTable = nil
+	nodes := cfg.Nodes[:0]
+	members := make(map[string]bool)
+	for _, n := range cfg.Nodes {
+		if n.Id != id {
+			nodes = append(nodes, n)
+			members[n.Id] = true
+		}
+	}
+	cfg.Nodes = nodes
+
+	ring, err := ch.InitRingFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	info := s.members.Snapshot()
+	p := &placementSnapshot{ring: ring, n: info.GetReplicationFactor(), members: members}
+	if info.Slots > 0 {
+		var ids []string
+		for _, n := range nodes {
+			ids = append(ids, n.Id)
+		}
+		s.slotMutex.RLock()
+		p.slots = ch.AssignSlots(s.slotTable, ids, info.Slots)
+		s.slotMutex.RUnlock()
+	}
+	return p, nil
+}
+
+// proxyRead looks up a key that none of its replicas has on its replicas from
+// before the last placement change, as it may not have been migrated yet.
+// Returns nil outside the proxy window.
+func (s *CacheServer) proxyRead(key string, current []string) *pb.ReplicaValue {
+	s.rebalancer.mut.Lock()
+	prev, until := s.rebalancer.previous, s.rebalancer.previousUntil
+	s.rebalancer.mut.Unlock()
+	if prev == nil || time.Now().After(until) {
+		return nil
+	}
+
+	var newest *pb.ReplicaValue
+	for _, id := range prev.replicas(key) {
+		if slices.Contains(current, id) {
+			continue
+		}
+		replica, ok := s.members.Get(id)
+		if !ok {
+			continue
+		}
+		value, err := s.replicaGet(replica, key)
+		if err == nil && value.Found && (newest == nil || value.Version > newest.Version) {
+			newest = value
+		}
+	}
+	if newest != nil {
+		s.rebalancer.proxiedReads.Add(1)
+	}
+	return newest
+}
+
+func (s *CacheServer) RebalanceProgress() RebalanceProgress {
+	s.rebalancer.mut.Lock()
+	defer s.rebalancer.mut.Unlock()
+
+	progress := s.rebalancer.progress
+	progress.Pending = make(map[string]int, len(s.rebalancer.progress.Pending))
+	for id, pending := range s.rebalancer.progress.Pending {
+		progress.Pending[id] = pending
+	}
+	progress.ProxiedReads = s.rebalancer.proxiedReads.Load()
+	return progress
+}
+
+// RebalanceProgressHandler Implementation
+func (s *CacheServer) RebalanceProgressHandler(client *gin.Context) {
+	client.IndentedJSON(http.StatusOK, s.RebalanceProgress())
+}
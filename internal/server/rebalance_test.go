@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/pkg/store"
+)
+
+func testPlacement(n int, ids ...string) *placementSnapshot {
+	r := ch.InitRing(10)
+	members := make(map[string]bool)
+	for _, id := range ids {
+		r.Add(id, "localhost", 8080, 5005)
+		members[id] = true
+	}
+	return &placementSnapshot{ring: r, n: n, members: members}
+}
+
+// entries that a node replicates under a placement
+func testEntries(p *placementSnapshot, id string) []store.Entry {
+	var entries []store.Entry
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if slices.Contains(p.replicas(key), id) {
+			entries = append(entries, store.Entry{Key: key, Value: "v", Version: 1})
+		}
+	}
+	return entries
+}
+
+func TestPlanMoves(t *testing.T) {
+	prev := testPlacement(1, "node0", "node1")
+	next := testPlacement(1, "node0", "node1", "node2")
+
+	moves := planMoves("node0", testEntries(prev, "node0"), prev, next)
+	if len(moves) == 0 {
+		t.Fatalf("expected some keys to move to the joining node")
+	}
+	for _, move := range moves {
+		if after := next.replicas(move.entry.Key); after[0] != "node2" {
+			t.Errorf("only keys now owned by node2 should move, moved %s owned by %v", move.entry.Key, after)
+		}
+		if len(move.targets) != 1 || move.targets[0] != "node2" || !move.drop {
+			t.Errorf("expected %s to move to node2 and be dropped, got %+v", move.entry.Key, move)
+		}
+	}
+
+	// with two replicas only the first surviving previous replica sends a key
+	prev = testPlacement(2, "node0", "node1", "node2")
+	next = testPlacement(2, "node0", "node1")
+	senders := make(map[string]int)
+	for _, id := range []string{"node0", "node1"} {
+		for _, move := range planMoves(id, testEntries(prev, id), prev, next) {
+			if len(move.targets) > 0 {
+				senders[move.entry.Key]++
+			}
+			if move.drop {
+				t.Errorf("%s should keep %s, every survivor is a replica", id, move.entry.Key)
+			}
+		}
+	}
+	for key, count := range senders {
+		if count != 1 {
+			t.Errorf("expected a single sender for %s, got %d", key, count)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+const (
+	READ_REPAIR_OFF   = "off"
+	READ_REPAIR_SYNC  = "sync"
+	READ_REPAIR_ASYNC = "async"
+)
+
+type readRepairStats struct {
+	conflicts atomic.Uint64
+	repairs   atomic.Uint64
+	failures  atomic.Uint64
+}
+
+// Read repair metrics
+type ReadRepairMetrics struct {
+	Mode      string `json:"mode"`
+	Conflicts uint64 `json:"conflicts"`
+	Repairs   uint64 `json:"repairs"`
+	Failures  uint64 `json:"failures"`
+}
+
+// Set read repair mode: off, sync (before answering the read) or async (in the background)
+func (s *CacheServer) SetReadRepair(mode string) {
+	switch mode {
+	case READ_REPAIR_OFF, READ_REPAIR_SYNC, READ_REPAIR_ASYNC:
+		s.readRepair = mode
+	default:
+		s.logger.Errorf("unknown read repair mode %s, keeping %s", mode, s.readRepair)
+	}
+}
+
+// repairReplicas writes the newest value back to every replica that answered
+// with an older version or without the key
+func (s *CacheServer) repairReplicas(key string, replicas []*node.Node, values []*pb.ReplicaValue, newest *pb.ReplicaValue) {
+	if newest == nil {
+		return
+	}
+
+	byId := make(map[string]*node.Node, len(replicas))
+	for _, replica := range replicas {
+		byId[replica.Id] = replica
+	}
+
+	for _, value := range values {
+		if value.Found && value.Version >= newest.Version {
+			continue
+		}
+		replica, ok := byId[value.NodeId]
+		if !ok {
+			continue
+		}
+
+		s.logger.Infof("Read repair of key %s on node %s to version %d", key, replica.Id, newest.Version)
+		if err := s.replicaPut(replica, key, newest.Value, newest.Version); err != nil {
+			s.logger.Infof("read repair of key %s on node %s failed: %v", key, replica.Id, err)
+			s.readRepairStats.failures.Add(1)
+			continue
+		}
+		s.readRepairStats.repairs.Add(1)
+	}
+}
+
+func (s *CacheServer) ReadRepairMetrics() ReadRepairMetrics {
+	return ReadRepairMetrics{
+		Mode:      s.readRepair,
+		Conflicts: s.readRepairStats.conflicts.Load(),
+		Repairs:   s.readRepairStats.repairs.Load(),
+		Failures:  s.readRepairStats.failures.Load(),
+	}
+}
+
+// ReadRepairMetricsHandler Implementation
+func (s *CacheServer) ReadRepairMetricsHandler(client *gin.Context) {
+	client.IndentedJSON(http.StatusOK, s.ReadRepairMetrics())
+}
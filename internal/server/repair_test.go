@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+func TestRepairReplicas(t *testing.T) {
+	s := newTestServer(t, "node0")
+	replicas := []*node.Node{node.InitNode("node0", "localhost", 8080, 5005), node.InitNode("node1", "localhost", 8081, 5006)}
+	s.cache.PutVersioned("key", "old", 1)
+
+	values := []*pb.ReplicaValue{
+		s.localReplicaValue("key"),
+		{NodeId: "node1", Value: "new", Found: true, Version: 2},
+	}
+	_, newest := resolveReplicaValues(replicas, values)
+	s.repairReplicas("key", replicas, values, newest)
+
+	value, version, err := s.cache.GetVersioned("key")
+	if err != nil || value != "new" || version != 2 {
+		t.Errorf("expected stale local replica to be repaired to new@2, got %s@%d %v", value, version, err)
+	}
+	if m := s.ReadRepairMetrics(); m.Repairs != 1 || m.Failures != 0 {
+		t.Errorf("unexpected read repair metrics %+v", m)
+	}
+}
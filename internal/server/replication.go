@@ -0,0 +1,373 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const REPLICA_TIMEOUT = 3 * time.Second
+
+type replicaResult struct {
+	nodeId string
+	value  *pb.ReplicaValue
+	hinted bool
+	err    error
+}
+
+// Ring built from the current cluster config, rebuilt after membership changes
+func (s *CacheServer) getRing() (*ch.Ring, error) {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+
+	if s.ring != nil && !s.ringStale {
+		return s.ring, nil
+	}
+	ring, err := ch.InitRingFromConfig(s.clusterConfig())
+	if err != nil {
+		return nil, err
+	}
+	s.ring = ring
+	s.ringStale = false
+	return ring, nil
+}
+
+// Mark the ring for rebuild after the cluster config changed
+func (s *CacheServer) invalidateRing() {
+	s.ringMutex.Lock()
+	s.ringStale = true
+	s.ringMutex.Unlock()
+	s.triggerRebalance()
+}
+
+// Snapshot of where keys are placed: the ring, the slot table in
+// fixed-partition mode, the replication factor and the cluster members
+type placementSnapshot struct {
+	ring    *ch.Ring
+	slots   *ch.SlotTable
+	n       int
+	members map[string]bool
+}
+
+// Snapshot the current placement of the cluster
+func (s *CacheServer) currentPlacement() (*placementSnapshot, error) {
+	ring, err := s.getRing()
+	if err != nil {
+		return nil, err
+	}
+
+	s.slotMutex.RLock()
+	slots := s.slotTable
+	s.slotMutex.RUnlock()
+
+	info := s.members.Snapshot()
+	members := make(map[string]bool, len(info.Nodes))
+	for id := range info.Nodes {
+		members[id] = true
+	}
+	return &placementSnapshot{ring: ring, slots: slots, n: info.GetReplicationFactor(), members: members}, nil
+}
+
+// replicas returns the ids of the nodes that hold a copy of key, primary owner first
+func (p *placementSnapshot) replicas(key string) []string {
+	if p.ring.Nodes.Len() == 0 {
+		return nil
+	}
+	ids := p.ring.GetReplicas(key, p.n)
+
+	// with fixed-partition placement the slot owner is the primary
+	if p.slots != nil {
+		if owner := p.slots.Get(key); owner != "" {
+			replicaIds := []string{owner}
+			for _, id := range p.ring.GetReplicas(key, p.n+1) {
+				if id != owner && len(replicaIds) < p.n {
+					replicaIds = append(replicaIds, id)
+				}
+			}
+			ids = replicaIds
+		}
+	}
+	return ids
+}
+
+// replicaNodes returns the nodes that hold a copy of key, primary owner first
+func (s *CacheServer) replicaNodes(key string) ([]*node.Node, error) {
+	p, err := s.currentPlacement()
+	if err != nil {
+		return nil, err
+	}
+	if p.ring.Nodes.Len() == 0 {
+		return nil, fmt.Errorf("no nodes in cluster")
+	}
+
+	var replicas []*node.Node
+	for _, id := range p.replicas(key) {
+		if replica, ok := s.members.Get(id); ok {
+			replicas = append(replicas, replica)
+		}
+	}
+	return replicas, nil
+}
+
+// quorum validates a requested quorum against the number of replicas, 0 means 1
+func quorum(requested int32, replicas int) (int, error) {
+	if requested <= 0 {
+		return 1, nil
+	}
+	if int(requested) > replicas {
+		return 0, status.Errorf(codes.InvalidArgument, "quorum %d exceeds replication factor %d", requested, replicas)
+	}
+	return int(requested), nil
+}
+
+// Get or reuse a gRPC client to another node
+func (s *CacheServer) getNodeClient(n *node.Node) (pb.CacheServiceClient, error) {
+	addr := fmt.Sprintf("%s:%d", n.Host, n.GrpcPort)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	if c, ok := s.clients[addr]; ok {
+		return c, nil
+	}
+	conn, err := s.dialNode(n.Host, int(n.GrpcPort))
+	if err != nil {
+		return nil, err
+	}
+	c := pb.NewCacheServiceClient(conn)
+	s.clients[addr], s.conns[addr] = c, conn
+	return c, nil
+}
+
+// Close the cached gRPC client to the address a node had, once it moved
+func (s *CacheServer) dropNodeClient(n *node.Node) {
+	addr := fmt.Sprintf("%s:%d", n.Host, n.GrpcPort)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	if conn, ok := s.conns[addr]; ok {
+		conn.Close()
+	}
+	delete(s.clients, addr)
+	delete(s.conns, addr)
+}
+
+// coordinatePut writes a key to all of its replicas and returns once w of them
+// acknowledged. Hints for unreachable replicas only live in the memory of this
+// node, so they do not count toward w.
+func (s *CacheServer) coordinatePut(key string, value string, writeQuorum int32) error {
+	replicas, err := s.replicaNodes(key)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "unable to place key %s: %v", key, err)
+	}
+	w, err := quorum(writeQuorum, len(replicas))
+	if err != nil {
+		return err
+	}
+
+	version := time.Now().UnixNano()
+	results := make(chan replicaResult, len(replicas))
+	for _, replica := range replicas {
+		go func(replica *node.Node) {
+			err := s.replicaPut(replica, key, value, version)
+			if err != nil && replica.Id != s.nodeId {
+				// keep the write for the unreachable replica and replay it once it is back
+				s.hints.add(replica.Id, key, value, version)
+				results <- replicaResult{nodeId: replica.Id, hinted: true, err: err}
+				return
+			}
+			results <- replicaResult{nodeId: replica.Id, err: err}
+		}(replica)
+	}
+
+	acks, hinted, failed := 0, 0, 0
+	for range replicas {
+		res := <-results
+		if res.hinted {
+			s.logger.Infof("replica write of key %s to node %s failed, stored hint: %v", key, res.nodeId, res.err)
+			hinted++
+			failed++
+		} else if res.err != nil {
+			s.logger.Infof("replica write of key %s to node %s failed: %v", key, res.nodeId, res.err)
+			failed++
+		} else {
+			acks++
+		}
+		if acks >= w {
+			return nil
+		}
+		if failed > len(replicas)-w {
+			break
+		}
+	}
+	return status.Errorf(codes.Unavailable, "not enough replicas for key %s: %d/%d acks, %d hinted", key, acks, w, hinted)
+}
+
+func (s *CacheServer) replicaPut(replica *node.Node, key string, value string, version int64) error {
+	if replica.Id == s.nodeId {
+		s.cache.PutVersioned(key, value, version)
+		return nil
+	}
+
+	c, err := s.getNodeClient(replica)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), REPLICA_TIMEOUT)
+	defer cancel()
+
+	_, err = c.ReplicaPut(ctx, &pb.PutRequest{Key: key, Value: value, Version: version})
+	return err
+}
+
+// coordinateGet reads a key from its replicas and returns once r of them
+// answered. Replicas that disagree are reported as a conflict.
+func (s *CacheServer) coordinateGet(key string, readQuorum int32) (*pb.GetResponse, error) {
+	replicas, err := s.replicaNodes(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to place key %s: %v", key, err)
+	}
+	r, err := quorum(readQuorum, len(replicas))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan replicaResult, len(replicas))
+	for _, replica := range replicas {
+		go func(replica *node.Node) {
+			value, err := s.replicaGet(replica, key)
+			results <- replicaResult{nodeId: replica.Id, value: value, err: err}
+		}(replica)
+	}
+
+	var values []*pb.ReplicaValue
+	received := 0
+	for range replicas {
+		res := <-results
+		received++
+		if res.err != nil {
+			s.logger.Infof("replica read of key %s from node %s failed: %v", key, res.nodeId, res.err)
+		} else {
+			values = append(values, res.value)
+		}
+		if len(values) >= r || received-len(values) > len(replicas)-r {
+			break
+		}
+	}
+	if len(values) < r {
+		return nil, status.Errorf(codes.Unavailable, "read quorum not reached for key %s: %d/%d replies", key, len(values), r)
+	}
+
+	res, newest := resolveReplicaValues(replicas, values)
+	if newest == nil {
+		ids := make([]string, len(replicas))
+		for i, replica := range replicas {
+			ids[i] = replica.Id
+		}
+		if proxied := s.proxyRead(key, ids); proxied != nil {
+			// the key has not been migrated to its new replicas yet, serve it
+			// from its previous owner and copy it over
+			res.Data = proxied.Value
+			res.Replicas = append(res.Replicas, proxied)
+			go s.repairReplicas(key, replicas, values, proxied)
+			return res, nil
+		}
+	}
+	if res.Conflict {
+		s.readRepairStats.conflicts.Add(1)
+	}
+
+	switch s.readRepair {
+	case READ_REPAIR_SYNC:
+		s.repairReplicas(key, replicas, values, newest)
+	case READ_REPAIR_ASYNC:
+		pending := len(replicas) - received
+		go func(values []*pb.ReplicaValue) {
+			// also wait for the replicas that answered after the quorum was reached
+			for i := 0; i < pending; i++ {
+				if res := <-results; res.err == nil {
+					values = append(values, res.value)
+				}
+			}
+			_, newest := resolveReplicaValues(replicas, values)
+			s.repairReplicas(key, replicas, values, newest)
+		}(append([]*pb.ReplicaValue{}, values...))
+	}
+
+	return res, nil
+}
+
+// resolveReplicaValues picks the newest value among the replicas that have the
+// key, preferring the higher priority replica on equal versions, and flags a
+// conflict when the replicas disagree
+func resolveReplicaValues(replicas []*node.Node, values []*pb.ReplicaValue) (*pb.GetResponse, *pb.ReplicaValue) {
+	res := &pb.GetResponse{Data: "key not found", Replicas: values}
+
+	byNode := make(map[string]*pb.ReplicaValue, len(values))
+	for _, value := range values {
+		byNode[value.NodeId] = value
+	}
+	var newest *pb.ReplicaValue
+	for _, replica := range replicas {
+		if value, ok := byNode[replica.Id]; ok && value.Found && (newest == nil || value.Version > newest.Version) {
+			newest = value
+		}
+	}
+	if newest != nil {
+		res.Data = newest.Value
+	}
+
+	for _, value := range values[1:] {
+		if value.Found != values[0].Found || value.Value != values[0].Value || value.Version != values[0].Version {
+			res.Conflict = true
+			break
+		}
+	}
+	return res, newest
+}
+
+func (s *CacheServer) replicaGet(replica *node.Node, key string) (*pb.ReplicaValue, error) {
+	if replica.Id == s.nodeId {
+		return s.localReplicaValue(key), nil
+	}
+
+	c, err := s.getNodeClient(replica)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), REPLICA_TIMEOUT)
+	defer cancel()
+
+	return c.ReplicaGet(ctx, &pb.GetRequest{Key: key})
+}
+
+func (s *CacheServer) localReplicaValue(key string) *pb.ReplicaValue {
+	value, version, err := s.cache.GetVersioned(key)
+	if err != nil {
+		return &pb.ReplicaValue{NodeId: s.nodeId, Found: false}
+	}
+	return &pb.ReplicaValue{NodeId: s.nodeId, Value: value, Found: true, Version: version}
+}
+
+// ReplicaGet reads a key from the local store only, used by coordinators
+func (s *CacheServer) ReplicaGet(ctx context.Context, req *pb.GetRequest) (*pb.ReplicaValue, error) {
+	return s.localReplicaValue(req.Key), nil
+}
+
+// ReplicaPut writes a key to the local store only, used by coordinators
+func (s *CacheServer) ReplicaPut(ctx context.Context, req *pb.PutRequest) (*empty.Empty, error) {
+	if req.Version == 0 {
+		s.cache.Put(req.Key, req.Value)
+	} else {
+		s.cache.PutVersioned(req.Key, req.Value, req.Version)
+	}
+	return &empty.Empty{}, nil
+}
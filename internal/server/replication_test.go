@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuorum(t *testing.T) {
+	if q, err := quorum(0, 3); err != nil || q != 1 {
+		t.Errorf("expected default quorum 1, got %d, %v", q, err)
+	}
+	if q, err := quorum(2, 3); err != nil || q != 2 {
+		t.Errorf("expected quorum 2, got %d, %v", q, err)
+	}
+	if _, err := quorum(4, 3); err == nil {
+		t.Errorf("expected error for quorum larger than replication factor")
+	}
+}
+
+func TestResolveReplicaValues(t *testing.T) {
+	replicas := []*node.Node{node.InitNode("node0", "localhost", 8080, 5005), node.InitNode("node1", "localhost", 8081, 5006)}
+
+	res, newest := resolveReplicaValues(replicas, []*pb.ReplicaValue{
+		{NodeId: "node1", Value: "a", Found: true, Version: 1},
+		{NodeId: "node0", Value: "a", Found: true, Version: 1},
+	})
+	if res.Data != "a" || res.Conflict || newest.NodeId != "node0" {
+		t.Errorf("expected agreeing replicas to resolve to a on the primary without conflict, got %v", res)
+	}
+
+	res, newest = resolveReplicaValues(replicas, []*pb.ReplicaValue{
+		{NodeId: "node1", Value: "b", Found: true, Version: 2},
+		{NodeId: "node0", Value: "a", Found: true, Version: 1},
+	})
+	if res.Data != "b" || !res.Conflict || newest.Version != 2 {
+		t.Errorf("expected newest value b with conflict, got %v", res)
+	}
+
+	res, newest = resolveReplicaValues(replicas, []*pb.ReplicaValue{
+		{NodeId: "node0", Found: false},
+		{NodeId: "node1", Value: "b", Found: true, Version: 1},
+	})
+	if res.Data != "b" || !res.Conflict || newest.NodeId != "node1" {
+		t.Errorf("expected missing key on primary to fall back to b with conflict, got %v", res)
+	}
+
+	res, newest = resolveReplicaValues(replicas, []*pb.ReplicaValue{
+		{NodeId: "node0", Found: false},
+		{NodeId: "node1", Found: false},
+	})
+	if res.Data != "key not found" || res.Conflict || newest != nil {
+		t.Errorf("expected key not found without conflict, got %v", res)
+	}
+}
+
+// downClient fails every replica write, like an unreachable node
+func TestHintsDoNotCountTowardWriteQuorum(t *testing.T) {
+	nodes := []*node.Node{
+		node.InitNode("node0", "localhost", 8080, 5005),
+		node.InitNode("node1", "localhost", 8081, 5006),
+		node.InitNode("node2", "localhost", 8082, 5007),
+	}
+	s := newTestServer(t, "node0", nodes...)
+	s.members.Replace(nodes, &pb.Placement{Algorithm: node.RING, ReplicationFactor: 3}, 0)
+	s.clients["localhost:5006"] = downClient{}
+	s.clients["localhost:5007"] = downClient{}
+
+	// only the local replica acknowledges, the two others are hinted
+	if err := s.coordinatePut("key", "value", 2); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected w=2 to fail with two replicas down, got %v", err)
+	}
+	if pending := s.hints.metrics().Pending; pending["node1"] != 1 || pending["node2"] != 1 {
+		t.Errorf("expected the write to be hinted for both down replicas, got %v", pending)
+	}
+	if err := s.coordinatePut("key", "value", 1); err != nil {
+		t.Errorf("expected the local ack to satisfy w=1, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ROUTING_FORWARD  = "forward"
+	ROUTING_REDIRECT = "redirect"
+	// prefix of the error returned to gRPC callers when a key lives on another node
+	MOVED = "MOVED"
+)
+
+// Set how requests for keys this node does not replicate are handled: forward
+// coordinates them on behalf of the caller, redirect answers with the owner
+func (s *CacheServer) SetRouting(mode string) {
+	switch mode {
+	case ROUTING_FORWARD, ROUTING_REDIRECT:
+		s.routing = mode
+	default:
+		s.logger.Errorf("unknown routing mode %s, keeping %s", mode, s.routing)
+	}
+}
+
+// movedTo returns the primary owner of a key when redirects are enabled and
+// this node is not one of the key's replicas, nil if the request is served here
+func (s *CacheServer) movedTo(key string) (*node.Node, error) {
+	if s.routing != ROUTING_REDIRECT {
+		return nil, nil
+	}
+	replicas, err := s.replicaNodes(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to place key %s: %v", key, err)
+	}
+	if len(replicas) == 0 || slices.ContainsFunc(replicas, func(n *node.Node) bool { return n.Id == s.nodeId }) {
+		return nil, nil
+	}
+	return replicas[0], nil
+}
+
+// movedError tells a gRPC caller which node to retry on, e.g. "MOVED node1 host:5006"
+func movedError(owner *node.Node) error {
+	return status.Errorf(codes.FailedPrecondition, "%s %s %s:%d", MOVED, owner.Id, owner.Host, owner.GrpcPort)
+}
+
+// redirect answers a REST request with a temporary redirect to the same path
+// on the owner, which keeps the method and body of a PUT
+func redirect(client *gin.Context, owner *node.Node) {
+	location := fmt.Sprintf("http://%s%s", owner.RestAddr(), client.Request.URL.RequestURI())
+	client.Header("Location", location)
+	client.IndentedJSON(http.StatusTemporaryRedirect, gin.H{"message": fmt.Sprintf("%s %s %s", MOVED, owner.Id, location)})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc/status"
+)
+
+func TestMovedTo(t *testing.T) {
+	node1 := node.InitNode("node1", "localhost", 8081, 5006)
+	nodes := []*node.Node{node.InitNode("node0", "localhost", 8080, 5005), node1}
+	s := newTestServer(t, "node0", nodes...)
+	s.members.Replace(nodes, &pb.Placement{Algorithm: node.RING, VirtualNodes: 10}, 0)
+	s.routing = ROUTING_REDIRECT
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		replicas, _ := s.replicaNodes(key)
+		owner, err := s.movedTo(key)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if replicas[0].Id == "node0" && owner != nil {
+			t.Errorf("expected %s to be served locally, got moved to %s", key, owner.Id)
+		}
+		if replicas[0].Id == "node1" && (owner == nil || owner.Id != "node1") {
+			t.Errorf("expected %s to be moved to node1, got %v", key, owner)
+		}
+	}
+
+	s.routing = ROUTING_FORWARD
+	for i := 0; i < 50; i++ {
+		if owner, _ := s.movedTo(fmt.Sprintf("key%d", i)); owner != nil {
+			t.Errorf("expected forward mode to serve every key, got moved to %s", owner.Id)
+		}
+	}
+
+	if msg := status.Convert(movedError(node1)).Message(); msg != "MOVED node1 localhost:5006" {
+		t.Errorf("unexpected MOVED message %q", msg)
+	}
+}
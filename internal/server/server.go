@@ -9,10 +9,18 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/nathang15/go-tinystore/internal/ch"
+	"github.com/nathang15/go-tinystore/internal/discovery"
+	"github.com/nathang15/go-tinystore/internal/gossip"
+	"github.com/nathang15/go-tinystore/internal/membership"
 	"github.com/nathang15/go-tinystore/internal/node"
 	"github.com/nathang15/go-tinystore/pb"
 	"github.com/nathang15/go-tinystore/pkg/store"
@@ -21,6 +29,7 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -37,17 +46,45 @@ type ServerConfig struct {
 
 type CacheServer struct {
 	// Ring            ring.Ring
-	router    *gin.Engine
-	cache     *store.LRU
-	logger    *zap.SugaredLogger
-	nodesInfo node.NodesInfo
-	leaderId  string
-	nodeId    string
+	router  *gin.Engine
+	cache   *store.LRU
+	logger  *zap.SugaredLogger
+	members *membership.Membership
+	nodeId  string
 	// groupId         string
 	shutdownChannel chan bool
+	stopOnce        sync.Once
+	// set once the node shuts down, it no longer competes for leadership
+	leaving         atomic.Bool
 	decisionChannel chan string
 	// mutex           sync.Mutex
-	electionStatus bool
+	electionStatus      bool
+	slotTable           *ch.SlotTable
+	configMut           sync.Mutex // serializes checking cluster configs against the current one and applying them
+	configChanged       configNotifier
+	ring                *ch.Ring
+	ringStale           bool
+	ringMutex           sync.Mutex
+	clients             map[string]pb.CacheServiceClient
+	conns               map[string]*grpc.ClientConn
+	clientsMutex        sync.Mutex
+	hints               *hintStore
+	readRepair          string
+	readRepairStats     readRepairStats
+	antiEntropyInterval time.Duration
+	antiEntropyRate     int
+	antiEntropyStats    antiEntropyStats
+	rebalancer          *rebalancer
+	routing             string
+	gossip              *gossip.Memberlist
+	detector            *phiDetector
+	election            Election
+	adminToken          string
+	discovery           discovery.Discovery
+	dataDir             string
+	identity            node.Identity
+	identityMut         sync.Mutex
+	slotMutex           sync.RWMutex
 	pb.UnimplementedCacheServiceServer
 }
 
@@ -59,45 +96,59 @@ type Pair struct {
 func InitCacheServer(capacity int, configFile string, verbose bool, nodeId string) (*grpc.Server, *CacheServer) {
 	sugaredLogger := GetSugaredZapLogger(verbose)
 	nodesInfo := node.LoadNodesConfig(configFile)
-	var finNodeId string
+	if _, err := ch.GetHasher(nodesInfo.Hasher); err != nil {
+		sugaredLogger.Fatalf("Invalid placement in config file: %v", err)
+	}
+	if err := nodesInfo.Validate(); err != nil {
+		sugaredLogger.Fatalf("Invalid placement in config file: %v", err)
+	}
+	finNodeId := nodeId
 	if nodeId == DYNAMIC {
 		log.Printf("passed node id: %s", nodeId)
 		finNodeId = node.GetCurrentNodeId(nodesInfo)
 		log.Printf("final node id: %s", finNodeId)
-		if _, ok := nodesInfo.Nodes[finNodeId]; !ok {
-			host, _ := os.Hostname()
-			nodesInfo.Nodes[finNodeId] = node.InitNode(finNodeId, host, 8080, 5005)
-		}
-	} else {
-		finNodeId = nodeId
-		if _, ok := nodesInfo.Nodes[finNodeId]; !ok {
-			panic("Node not found in config file")
-		}
 	}
-
-	if _, ok := nodesInfo.Nodes[nodeId]; !ok {
+	// a node missing from the config file, dynamic or with a persisted or
+	// pinned id, joins under its host name until SetAdvertiseAddrs sets its
+	// actual addresses
+	if _, ok := nodesInfo.Nodes[finNodeId]; !ok {
 		host, _ := os.Hostname()
-		nodesInfo.Nodes[nodeId] = node.InitNode(nodeId, host, 8080, 5005)
+		nodesInfo.Nodes[finNodeId] = node.InitNode(finNodeId, host, 8080, 5005)
 	}
 
 	router := gin.New()
 	router.Use(gin.Recovery())
 
-	lru := store.Init(capacity)
-
 	cacheServer := CacheServer{
-		router:          router,
-		cache:           &lru,
-		logger:          sugaredLogger,
-		nodesInfo:       nodesInfo,
-		nodeId:          finNodeId,
-		leaderId:        NO_LEADER,
-		decisionChannel: make(chan string, 1),
+		router:              router,
+		cache:               store.New(capacity),
+		logger:              sugaredLogger,
+		members:             membership.New(nodesInfo),
+		nodeId:              finNodeId,
+		identity:            node.Identity{NodeId: finNodeId, ClusterId: nodesInfo.ClusterId},
+		shutdownChannel:     make(chan bool),
+		decisionChannel:     make(chan string, 1),
+		clients:             make(map[string]pb.CacheServiceClient),
+		conns:               make(map[string]*grpc.ClientConn),
+		hints:               newHintStore(DEFAULT_MAX_HINTS, DEFAULT_HINT_TTL),
+		readRepair:          READ_REPAIR_ASYNC,
+		antiEntropyInterval: DEFAULT_ANTI_ENTROPY_INTERVAL,
+		antiEntropyRate:     DEFAULT_ANTI_ENTROPY_RATE,
+		rebalancer:          newRebalancer(),
+		routing:             ROUTING_FORWARD,
+		detector:            newPhiDetector(DEFAULT_PHI_THRESHOLD, DEFAULT_PHI_SUSTAIN),
 	}
+	cacheServer.election = bullyElection{s: &cacheServer}
+	go cacheServer.followMembership(cacheServer.members.Subscribe())
 
 	//routes
 	cacheServer.router.GET("/get/:key", cacheServer.GetHandler)
 	cacheServer.router.POST("/put", cacheServer.PutHandler)
+	cacheServer.router.GET("/metrics/hints", cacheServer.HintMetricsHandler)
+	cacheServer.router.GET("/metrics/read-repair", cacheServer.ReadRepairMetricsHandler)
+	cacheServer.router.GET("/metrics/anti-entropy", cacheServer.AntiEntropyMetricsHandler)
+	cacheServer.router.GET("/metrics/rebalance", cacheServer.RebalanceProgressHandler)
+	cacheServer.registerAdminRoutes()
 
 	//Set up TLS
 	credentials, err := LoadTLSCredentials()
@@ -107,37 +158,64 @@ func InitCacheServer(capacity int, configFile string, verbose bool, nodeId strin
 
 	grpcServer := grpc.NewServer(grpc.Creds(credentials))
 	pb.RegisterCacheServiceServer(grpcServer, &cacheServer)
+	pb.RegisterAdminServiceServer(grpcServer, &adminServer{s: &cacheServer})
 	reflection.Register(grpcServer)
 	return grpcServer, &cacheServer
 }
 
-// GetHandler Impementation
+// GetHandler Impementation, the optional r query parameter sets the read quorum
 func (server *CacheServer) GetHandler(client *gin.Context) {
-	res := make(chan gin.H)
-	go func(ctx *gin.Context) {
-		value, err := server.cache.Get(client.Param("key"))
-		if err != nil {
-			res <- gin.H{"message": "key not found"}
-		} else {
-			res <- gin.H{"value": value}
-		}
-	}(client.Copy())
-	client.IndentedJSON(http.StatusOK, <-res)
+	owner, err := server.movedTo(client.Param("key"))
+	if err != nil {
+		client.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": status.Convert(err).Message()})
+		return
+	}
+	if owner != nil {
+		redirect(client, owner)
+		return
+	}
+
+	readQuorum, _ := strconv.Atoi(client.Query("r"))
+	res, err := server.coordinateGet(client.Param("key"), int32(readQuorum))
+	if err != nil {
+		client.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": status.Convert(err).Message()})
+		return
+	}
+
+	body := gin.H{"value": res.Data}
+	if !slices.ContainsFunc(res.Replicas, func(v *pb.ReplicaValue) bool { return v.Found }) {
+		body = gin.H{"message": "key not found"}
+	}
+	if res.Conflict {
+		body["conflict"] = true
+		body["replicas"] = res.Replicas
+	}
+	client.IndentedJSON(http.StatusOK, body)
 }
 
-// PutHandler Impementation
+// PutHandler Impementation, the optional w query parameter sets the write quorum
 func (server *CacheServer) PutHandler(client *gin.Context) {
-	res := make(chan gin.H)
-	go func(ctx *gin.Context) {
-		var newPair Pair
-		if err := client.BindJSON(&newPair); err != nil {
-			server.logger.Errorf("unable to deserialize key-value pair from json")
-			return
-		}
-		server.cache.Put(newPair.Key, newPair.Value)
-		res <- gin.H{"key": newPair.Key, "value": newPair.Value}
-	}(client.Copy())
-	client.IndentedJSON(http.StatusCreated, <-res)
+	var newPair Pair
+	if err := client.BindJSON(&newPair); err != nil {
+		server.logger.Errorf("unable to deserialize key-value pair from json")
+		return
+	}
+	owner, err := server.movedTo(newPair.Key)
+	if err != nil {
+		client.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": status.Convert(err).Message()})
+		return
+	}
+	if owner != nil {
+		redirect(client, owner)
+		return
+	}
+
+	writeQuorum, _ := strconv.Atoi(client.Query("w"))
+	if err := server.coordinatePut(newPair.Key, newPair.Value, int32(writeQuorum)); err != nil {
+		client.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": status.Convert(err).Message()})
+		return
+	}
+	client.IndentedJSON(http.StatusCreated, gin.H{"key": newPair.Key, "value": newPair.Value})
 }
 
 // Set up mTLS config and creds
@@ -206,6 +284,22 @@ func GetSugaredZapLogger(verbose bool) *zap.SugaredLogger {
 	return logger.Sugar()
 }
 
+// ServeHttp runs the REST API on a listener
+func (s *CacheServer) ServeHttp(listener net.Listener) *http.Server {
+	srv := &http.Server{
+		Addr:    listener.Addr().String(),
+		Handler: s.router,
+	}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			log.Printf("listen: %s\n", err)
+		}
+	}()
+
+	return srv
+}
+
 func (s *CacheServer) RunHttpServer(port int) *http.Server {
 	addr := fmt.Sprintf(":%d", port)
 	srv := &http.Server{
@@ -223,19 +317,40 @@ func (s *CacheServer) RunHttpServer(port int) *http.Server {
 }
 
 func (s *CacheServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
-	value, err := s.cache.Get(req.Key)
+	owner, err := s.movedTo(req.Key)
 	if err != nil {
-		return &pb.GetResponse{Data: "key not found"}, nil
+		return nil, err
+	}
+	if owner != nil {
+		return nil, movedError(owner)
 	}
-	return &pb.GetResponse{Data: value}, nil
+	return s.coordinateGet(req.Key, req.ReadQuorum)
 }
 
 func (s *CacheServer) Put(ctx context.Context, req *pb.PutRequest) (*empty.Empty, error) {
-	s.cache.Put(req.Key, req.Value)
+	owner, err := s.movedTo(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		return nil, movedError(owner)
+	}
+	if err := s.coordinatePut(req.Key, req.Value, req.WriteQuorum); err != nil {
+		return nil, err
+	}
 	return &empty.Empty{}, nil
 }
 
 func (s *CacheServer) ServerInitCacheClient(serverHost string, serverPort int) (pb.CacheServiceClient, error) {
+	conn, err := s.dialNode(serverHost, serverPort)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewCacheServiceClient(conn), nil
+}
+
+// dialNode opens a mutually authenticated connection to another node
+func (s *CacheServer) dialNode(serverHost string, serverPort int) (*grpc.ClientConn, error) {
 	creds, err := LoadTLSCredentials()
 	if err != nil {
 		s.logger.Fatalf("failed to create credentials: %v", err)
@@ -257,40 +372,139 @@ func (s *CacheServer) ServerInitCacheClient(serverHost string, serverPort int) (
 	if err != nil {
 		return nil, err
 	}
+	return conn, nil
+}
 
-	return pb.NewCacheServiceClient(conn), nil
+// Set zone/rack of the local node, overriding the config file
+func (s *CacheServer) SetZone(zone string) {
+	if s.members.Update(s.nodeId, func(n *node.Node) { n.Zone = zone }) {
+		s.invalidateRing()
+	}
+}
+
+// Set the host:port addresses other nodes and clients reach the gRPC server
+// and the REST API of this node at. An empty gRPC host keeps the host of the
+// config file or the host name, an empty REST host uses the gRPC host.
+// Registration, the cluster config and the ring all carry these addresses.
+func (s *CacheServer) SetAdvertiseAddrs(grpcAddr string, restAddr string) error {
+	grpcHost, grpcPort, err := splitAddr(grpcAddr)
+	if err != nil {
+		return fmt.Errorf("invalid gRPC advertise address %q: %v", grpcAddr, err)
+	}
+	restHost, restPort, err := splitAddr(restAddr)
+	if err != nil {
+		return fmt.Errorf("invalid REST advertise address %q: %v", restAddr, err)
+	}
+
+	s.members.Update(s.nodeId, func(n *node.Node) {
+		if grpcHost != "" {
+			n.Host = grpcHost
+		}
+		n.GrpcPort = grpcPort
+		n.RestHost = ""
+		if restHost != n.Host {
+			n.RestHost = restHost
+		}
+		n.RestPort = restPort
+	})
+	s.invalidateRing()
+
+	self, _ := s.members.Get(s.nodeId)
+	s.logger.Infof("Advertising gRPC at %s:%d and REST at %s", self.Host, self.GrpcPort, self.RestAddr())
+	return nil
+}
+
+func splitAddr(addr string) (string, int32, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, int32(p), nil
+}
+
+// Set the leader election priority of this node, higher priorities lead first
+func (s *CacheServer) SetLeaderPriority(priority int32) {
+	s.members.Update(s.nodeId, func(n *node.Node) { n.Priority = priority })
+}
+
+// Keep this node from ever becoming leader, it only follows
+func (s *CacheServer) SetNeverLeader() {
+	s.members.Update(s.nodeId, func(n *node.Node) { n.NeverLeader = true })
 }
 
 func (s *CacheServer) RegisterNodeInternal() {
-	s.logger.Infof("attempting to register %s with cluster", s.nodeId)
-	localNode := s.nodesInfo.Nodes[s.nodeId]
-	for _, node := range s.nodesInfo.Nodes {
-		if node.Id == s.nodeId {
+	s.registerWithSeeds(s.seeds())
+}
+
+// Find the nodes to register with through d instead of the config file
+func (s *CacheServer) SetDiscovery(d discovery.Discovery) {
+	s.discovery = d
+}
+
+// seeds returns the discovered seeds, or the nodes of the config file
+// without a discovery
+func (s *CacheServer) seeds() []discovery.Seed {
+	if s.discovery == nil {
+		return discovery.FromConfig(s.members.Snapshot())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	seeds, err := s.discovery.Seeds(ctx)
+	if err != nil {
+		s.logger.Errorf("unable to discover seeds: %v", err)
+	}
+	return seeds
+}
+
+// registerWithSeeds registers this node through the first seed that accepts
+// it, reports whether one did
+func (s *CacheServer) registerWithSeeds(seeds []discovery.Seed) bool {
+	s.logger.Infof("attempting to register %s with cluster through %d seeds", s.nodeId, len(seeds))
+	localNode, _ := s.members.Get(s.nodeId)
+	for _, seed := range seeds {
+		if seed.Id == s.nodeId || (seed.Host == localNode.Host && seed.Port == localNode.GrpcPort) {
 			continue
 		}
-		req := pb.Node{
-			Id:       localNode.Id,
-			Host:     localNode.Host,
-			RestPort: localNode.RestPort,
-			GrpcPort: localNode.GrpcPort,
-		}
-		client, err := s.ServerInitCacheClient(node.Host, int(node.GrpcPort))
+		req := localNode.ToProto()
+		conn, err := s.dialNode(seed.Host, int(seed.Port))
 		if err != nil {
-			s.logger.Errorf("unable to connect to node %s", node.Id)
+			s.logger.Errorf("unable to connect to seed %s", seed.Addr())
 			continue
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		_, err = client.RegisterNodeWithCluster(ctx, &req)
+		_, err = pb.NewCacheServiceClient(conn).RegisterNodeWithCluster(ctx, req)
+		cancel()
+		conn.Close()
 		if err != nil {
-			s.logger.Infof("error registering node %s with cluster: %v", s.nodeId, err)
+			s.logger.Infof("error registering node %s with cluster through %s: %v", s.nodeId, seed.Addr(), err)
 			continue
 		}
 
-		s.logger.Infof("node %s is registered with cluster", s.nodeId)
+		s.logger.Infof("node %s is registered with cluster through %s", s.nodeId, seed.Addr())
+		s.rebalancer.joined.Store(true)
+
+		return true
+	}
+	return false
+}
 
+// Retry the registration every time a watched discovery finds new seeds,
+// until this node is registered or leads a cluster of its own
+func (s *CacheServer) RunDiscovery() {
+	watcher, ok := s.discovery.(discovery.Watcher)
+	if !ok {
 		return
 	}
+	for seeds := range watcher.Watch(s.shutdownChannel) {
+		if s.rebalancer.joined.Load() || s.members.IsLeader(s.nodeId) {
+			continue
+		}
+		s.registerWithSeeds(seeds)
+	}
 }
 
 func CreateAndRunAllFromConfig(capacity int, configFile string, verbose bool) []ServerConfig {
@@ -320,6 +534,12 @@ func CreateAndRunAllFromConfig(capacity int, configFile string, verbose bool) []
 
 		go cacheServer.MonitorLeaderStatus()
 
+		go cacheServer.RunHintedHandoff()
+
+		go cacheServer.RunAntiEntropy()
+
+		go cacheServer.RunRebalancer()
+
 		httpServer := cacheServer.RunHttpServer(int(nodeInfo.RestPort))
 
 		components = append(components, ServerConfig{GrpcServer: grpcServer, HttpServer: httpServer})
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/nathang15/go-tinystore/internal/membership"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"github.com/nathang15/go-tinystore/pkg/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer returns a server for nodeId without the background loops of
+// InitCacheServer. Its cluster holds nodes, or only itself on localhost when
+// none are given, and it records the configs it publishes.
+func newTestServer(t *testing.T, nodeId string, nodes ...*node.Node) *CacheServer {
+	if len(nodes) == 0 {
+		nodes = []*node.Node{node.InitNode(nodeId, "localhost", 8080, 5005)}
+	}
+	nodesInfo := node.NodesInfo{Nodes: make(map[string]*node.Node)}
+	for _, n := range nodes {
+		nodesInfo.Nodes[n.Id] = n
+	}
+
+	s := &CacheServer{
+		router:          gin.New(),
+		cache:           store.New(100),
+		logger:          GetSugaredZapLogger(false),
+		members:         membership.New(nodesInfo),
+		nodeId:          nodeId,
+		shutdownChannel: make(chan bool),
+		decisionChannel: make(chan string, 1),
+		clients:         make(map[string]pb.CacheServiceClient),
+		hints:           newHintStore(DEFAULT_MAX_HINTS, DEFAULT_HINT_TTL),
+		readRepair:      READ_REPAIR_SYNC,
+		rebalancer:      newRebalancer(),
+		routing:         ROUTING_FORWARD,
+		detector:        newPhiDetector(DEFAULT_PHI_THRESHOLD, DEFAULT_PHI_SUSTAIN),
+		election:        &recordElection{},
+	}
+	t.Cleanup(s.stop)
+	return s
+}
+
+// recordElection keeps the configs the leader publishes instead of pushing them
+type recordElection struct {
+	published []*pb.ClusterConfig
+}
+
+func (e *recordElection) Elect()   {}
+func (e *recordElection) Monitor() {}
+func (e *recordElection) Publish(cfg *pb.ClusterConfig) {
+	e.published = append(e.published, cfg)
+}
+
+func TestAdvertiseAddrs(t *testing.T) {
+	s := newTestServer(t, "node7", node.InitNode("node7", "node7-host", 8080, 5005))
+
+	// an empty host keeps the host of the node
+	if err := s.SetAdvertiseAddrs(":6000", ":9000"); err != nil {
+		t.Fatal(err)
+	}
+	self, _ := s.members.Get("node7")
+	if self.Host != "node7-host" || self.GrpcPort != 6000 || self.RestAddr() != "node7-host:9000" {
+		t.Errorf("expected node7-host with gRPC 6000 and REST 9000, got %s:%d and %s", self.Host, self.GrpcPort, self.RestAddr())
+	}
+
+	if err := s.SetAdvertiseAddrs("10.0.0.5:6000", "public.example.com:443"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := s.clusterConfig()
+	if len(cfg.Nodes) != 1 || cfg.Nodes[0].Host != "10.0.0.5" || cfg.Nodes[0].GrpcPort != 6000 || cfg.Nodes[0].RestHost != "public.example.com" || cfg.Nodes[0].RestPort != 443 {
+		t.Errorf("expected cluster config to advertise the new addresses, got %v", cfg.Nodes)
+	}
+
+	for _, addrs := range [][2]string{{"10.0.0.5", ":9000"}, {":6000", ":0"}, {":6000", ":http"}} {
+		if err := s.SetAdvertiseAddrs(addrs[0], addrs[1]); err == nil {
+			t.Errorf("expected %v to be refused", addrs)
+		}
+	}
+}
+
+// client of a node that is down, calls not overridden here panic
+type downClient struct {
+	pb.CacheServiceClient
+}
+
+func (downClient) ReplicaPut(ctx context.Context, in *pb.PutRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return nil, status.Error(codes.Unavailable, "node is down")
+}
+
+func (downClient) RegisterNodeWithCluster(ctx context.Context, in *pb.Node, opts ...grpc.CallOption) (*pb.GenericResponse, error) {
+	return nil, status.Error(codes.Unavailable, "node is down")
+}
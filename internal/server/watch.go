@@ -0,0 +1,90 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nathang15/go-tinystore/pb"
+)
+
+// configNotifier wakes up every watcher of the cluster config when it
+// changes. The zero value is ready to use.
+type configNotifier struct {
+	mut     sync.Mutex
+	changed chan struct{}
+}
+
+// wait returns a channel closed on the next change
+func (n *configNotifier) wait() <-chan struct{} {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if n.changed == nil {
+		n.changed = make(chan struct{})
+	}
+	return n.changed
+}
+
+func (n *configNotifier) notify() {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if n.changed != nil {
+		close(n.changed)
+		n.changed = nil
+	}
+}
+
+// Version of a cluster config as seen by watchers, a config is only sent
+// again when one of these changed
+type configVersion struct {
+	epoch       int64
+	term        int64
+	leaderId    string
+	slotVersion int64
+	nodes       string
+}
+
+func versionOf(cfg *pb.ClusterConfig) configVersion {
+	ids := make([]string, len(cfg.Nodes))
+	for i, n := range cfg.Nodes {
+		ids[i] = n.Id
+	}
+	sort.Strings(ids)
+	return configVersion{
+		epoch:       cfg.Epoch,
+		term:        cfg.LeaderTerm,
+		leaderId:    cfg.LeaderId,
+		slotVersion: cfg.GetSlotTable().GetVersion(),
+		nodes:       strings.Join(ids, ","),
+	}
+}
+
+// WatchClusterConfig streams the cluster config to a client, starting with
+// the current one and then every new version, including leader changes, as
+// soon as this node learns about it. The stream ends when the client goes
+// away or the node shuts down.
+func (s *CacheServer) WatchClusterConfig(req *pb.ClusterConfigRequest, stream pb.CacheService_WatchClusterConfigServer) error {
+	s.logger.Infof("Node %s is watching the cluster config", req.CallerNodeId)
+
+	var sent *configVersion
+	for {
+		// wait for the next change before building the config so none is missed
+		changed := s.configChanged.wait()
+
+		cfg := s.clusterConfig()
+		if version := versionOf(cfg); sent == nil || version != *sent {
+			if err := stream.Send(cfg); err != nil {
+				return err
+			}
+			sent = &version
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		case <-s.shutdownChannel:
+			return nil
+		}
+	}
+}
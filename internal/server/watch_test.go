@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/pb"
+	"google.golang.org/grpc"
+)
+
+// watchStream collects the configs sent to a watcher
+type watchStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	configs chan *pb.ClusterConfig
+}
+
+func (w *watchStream) Context() context.Context { return w.ctx }
+
+func (w *watchStream) Send(cfg *pb.ClusterConfig) error {
+	w.configs <- cfg
+	return nil
+}
+
+func TestWatchClusterConfig(t *testing.T) {
+	s := newTestServer(t, "node0")
+	go s.followMembership(s.members.Subscribe())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchStream{ctx: ctx, configs: make(chan *pb.ClusterConfig, 16)}
+	done := make(chan error)
+	go func() { done <- s.WatchClusterConfig(&pb.ClusterConfigRequest{CallerNodeId: "client"}, stream) }()
+
+	next := func() *pb.ClusterConfig {
+		select {
+		case cfg := <-stream.configs:
+			return cfg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a config")
+			return nil
+		}
+	}
+
+	// the current config is sent right away
+	if cfg := next(); len(cfg.Nodes) != 1 || cfg.Epoch != 0 {
+		t.Errorf("expected the initial config, got %v", cfg)
+	}
+
+	nodes := []*pb.Node{node.InitNode("node0", "localhost", 8080, 5005).ToProto(), node.InitNode("node1", "localhost", 8081, 5006).ToProto()}
+	if _, err := s.UpdateClusterConfig(context.Background(), &pb.ClusterConfig{Nodes: nodes, Epoch: 3, LeaderTerm: 1, LeaderId: "node1"}); err != nil {
+		t.Fatal(err)
+	}
+	for cfg := next(); cfg.Epoch != 3 || len(cfg.Nodes) != 2 || cfg.LeaderId != "node1"; cfg = next() {
+	}
+
+	// leader changes are pushed without a new epoch
+	s.members.SetLeader("node0", 2)
+	for cfg := next(); cfg.LeaderId != "node0" || cfg.LeaderTerm != 2; cfg = next() {
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the watch to end cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not end with its client")
+	}
+}
@@ -8,50 +8,191 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/nathang15/go-tinystore/internal/discovery"
+	"github.com/nathang15/go-tinystore/internal/gossip"
+	"github.com/nathang15/go-tinystore/internal/node"
+	"github.com/nathang15/go-tinystore/internal/raft"
 	"github.com/nathang15/go-tinystore/internal/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ring-analyze" {
+		if err := runRingAnalyze(os.Args[2:]); err != nil {
+			log.Fatalf("ring-analyze: %v", err)
+		}
+		return
+	}
+
 	grpc_port := flag.Int("grpc-port", 5005, "port number for gRPC server")
 	capacity := flag.Int("capacity", 5, "lru capacity")
 	verbose := flag.Bool("verbose", false, "events log")
 	config_file := flag.String("config", "", "JSON config file")
 	rest_port := flag.Int("rest-port", 8080, "enable REST API for client requests too")
+	zone := flag.String("zone", "", "zone/rack of this node, overrides the config file")
+	max_hints := flag.Int("max-hints", server.DEFAULT_MAX_HINTS, "max hinted handoff writes kept per unreachable node")
+	hint_ttl := flag.Duration("hint-ttl", server.DEFAULT_HINT_TTL, "max age of a hinted handoff write before it is dropped")
+	read_repair := flag.String("read-repair", server.READ_REPAIR_ASYNC, "read repair mode: off, sync or async")
+	anti_entropy_interval := flag.Duration("anti-entropy-interval", server.DEFAULT_ANTI_ENTROPY_INTERVAL, "how often replicas compare Merkle trees, 0 disables anti-entropy")
+	anti_entropy_rate := flag.Int("anti-entropy-rate", server.DEFAULT_ANTI_ENTROPY_RATE, "max keys per second pulled by anti-entropy, 0 for unlimited")
+	rebalance_proxy_window := flag.Duration("rebalance-proxy-window", server.DEFAULT_REBALANCE_PROXY_WINDOW, "how long reads that miss are proxied to a key's previous owners after membership changes, 0 disables")
+	drain_timeout := flag.Duration("drain-timeout", server.DEFAULT_DRAIN_TIMEOUT, "max time to hand keys off and finish in-flight requests on shutdown")
+	routing := flag.String("routing", server.ROUTING_FORWARD, "requests for keys this node does not replicate: forward or redirect (MOVED)")
+	use_gossip := flag.Bool("gossip", false, "track membership with SWIM gossip instead of the leader's status checks")
+	gossip_interval := flag.Duration("gossip-interval", gossip.DefaultConfig().ProbeInterval, "how often each node probes a random member")
+	suspicion_timeout := flag.Duration("suspicion-timeout", gossip.DefaultConfig().SuspicionTimeout, "how long a member stays suspect before it is declared dead")
+	phi_threshold := flag.Float64("phi-threshold", server.DEFAULT_PHI_THRESHOLD, "phi above which the leader suspects a node that missed heartbeats")
+	phi_sustain := flag.Duration("phi-sustain", server.DEFAULT_PHI_SUSTAIN, "how long a node must stay suspected before the leader removes it")
+	election := flag.String("election", server.ELECTION_BULLY, "leader election: bully, or raft to also replicate cluster configs as a log")
+	raft_dir := flag.String("raft-dir", "", "directory persisting the raft term, vote and log, defaults to raft in -data-dir, state is kept in memory if both are empty")
+	priority := flag.Int("priority", -1, "leader election priority of this node, overrides the config file when 0 or above")
+	never_leader := flag.Bool("never-leader", false, "this node never becomes leader, overrides the config file")
+	admin_token := flag.String("admin-token", "", "bearer token of the admin API, the admin API is disabled if empty")
+	data_dir := flag.String("data-dir", "", "directory persisting the node and cluster id across restarts, nothing is persisted if empty")
+	node_id := flag.String("node-id", "", "pin the id of this node, overrides the persisted id")
+	cluster_id := flag.String("cluster-id", "", "pin the id of the cluster, overrides the persisted id")
+	grpc_addr := flag.String("grpc-addr", "", "address the gRPC server listens on, defaults to :<grpc-port>")
+	rest_addr := flag.String("rest-addr", "", "address the REST API listens on, defaults to :<rest-port>")
+	grpc_advertise_addr := flag.String("grpc-advertise-addr", "", "host:port other nodes and clients reach the gRPC server at, defaults to the host of this node and the port the gRPC server listens on")
+	rest_advertise_addr := flag.String("rest-advertise-addr", "", "host:port clients reach the REST API at, defaults to the gRPC host and the port the REST API listens on")
+	discovery_spec := flag.String("discovery", "", "where to find the cluster instead of the config file nodes: static:host:port,..., dns:name:port, srv:name or file:path")
 
 	flag.Parse()
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpc_port))
+	if *grpc_addr == "" {
+		*grpc_addr = fmt.Sprintf(":%d", *grpc_port)
+	}
+	if *rest_addr == "" {
+		*rest_addr = fmt.Sprintf(":%d", *rest_port)
+	}
+	listener, err := net.Listen("tcp", *grpc_addr)
 	if err != nil {
 		panic(err)
 	}
+	rest_listener, err := net.Listen("tcp", *rest_addr)
+	if err != nil {
+		panic(err)
+	}
+	// advertise the ports actually listened on unless told otherwise
+	if *grpc_advertise_addr == "" {
+		*grpc_advertise_addr = fmt.Sprintf(":%d", listener.Addr().(*net.TCPAddr).Port)
+	}
+	if *rest_advertise_addr == "" {
+		*rest_advertise_addr = fmt.Sprintf(":%d", rest_listener.Addr().(*net.TCPAddr).Port)
+	}
 
-	grpc_server, cache_server := server.InitCacheServer(*capacity, *config_file, *verbose, server.DYNAMIC)
+	identity, err := node.LoadIdentity(*data_dir)
+	if err != nil {
+		log.Fatalf("Failed to load node identity: %v", err)
+	}
+	// a pinned id wins over the persisted one, a new node picks one from the config file
+	id := server.DYNAMIC
+	if *node_id != "" {
+		id = *node_id
+	} else if identity.NodeId != "" {
+		id = identity.NodeId
+	}
 
-	log.Printf("Running gRPC server on: %d", *grpc_port)
+	grpc_server, cache_server := server.InitCacheServer(*capacity, *config_file, *verbose, id)
+	if err := cache_server.PersistIdentity(*data_dir, identity, *cluster_id); err != nil {
+		log.Fatalf("Failed to persist node identity: %v", err)
+	}
+	if err := cache_server.SetAdvertiseAddrs(*grpc_advertise_addr, *rest_advertise_addr); err != nil {
+		log.Fatalf("Failed to set advertise addresses: %v", err)
+	}
+
+	log.Printf("Running gRPC server on: %s", listener.Addr())
 	go grpc_server.Serve(listener)
 
+	if *zone != "" {
+		cache_server.SetZone(*zone)
+	}
+	if *priority >= 0 {
+		cache_server.SetLeaderPriority(int32(*priority))
+	}
+	if *never_leader {
+		cache_server.SetNeverLeader()
+	}
+	cache_server.SetHintLimits(*max_hints, *hint_ttl)
+	cache_server.SetReadRepair(*read_repair)
+	cache_server.SetAntiEntropy(*anti_entropy_interval, *anti_entropy_rate)
+	cache_server.SetRebalanceProxyWindow(*rebalance_proxy_window)
+	cache_server.SetRouting(*routing)
+	cache_server.SetFailureDetector(*phi_threshold, *phi_sustain)
+	cache_server.SetAdminToken(*admin_token)
+	if *discovery_spec != "" {
+		d, err := discovery.Parse(*discovery_spec)
+		if err != nil {
+			log.Fatalf("Invalid discovery: %v", err)
+		}
+		cache_server.SetDiscovery(d)
+	}
+	if *use_gossip {
+		config := gossip.DefaultConfig()
+		config.ProbeInterval = *gossip_interval
+		config.SuspicionTimeout = *suspicion_timeout
+		cache_server.EnableGossip(config)
+	}
+	switch *election {
+	case server.ELECTION_RAFT:
+		if *raft_dir == "" && *data_dir != "" {
+			*raft_dir = filepath.Join(*data_dir, "raft")
+		}
+		if err := cache_server.EnableRaft(raft.DefaultConfig(), *raft_dir); err != nil {
+			log.Fatalf("Failed to start raft: %v", err)
+		}
+	case server.ELECTION_BULLY:
+	default:
+		log.Fatalf("Unknown election %s", *election)
+	}
+
 	cache_server.RegisterNodeInternal()
 
 	cache_server.RunElection()
 
 	go cache_server.MonitorLeaderStatus()
 
-	log.Printf("Running REST API server on: %d", *rest_port)
-	http_server := cache_server.RunHttpServer(*rest_port)
+	go cache_server.RunHintedHandoff()
+
+	go cache_server.RunAntiEntropy()
+
+	go cache_server.RunRebalancer()
+
+	go cache_server.RunGossip()
+
+	go cache_server.RunDiscovery()
+
+	log.Printf("Running REST API server on: %s", rest_listener.Addr())
+	http_server := cache_server.ServeHttp(rest_listener)
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		<-c
 
+		ctx, cancel := context.WithTimeout(context.Background(), *drain_timeout)
+		defer cancel()
+
+		log.Printf("Draining node!")
+		cache_server.Drain(ctx)
+
 		log.Printf("Shutting down gRPC server!")
-		grpc_server.Stop()
+		stopped := make(chan bool)
+		go func() {
+			grpc_server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpc_server.Stop()
+		}
 
 		log.Printf("Shutting down HTTP server!")
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
 		if err := http_server.Shutdown(ctx); err != nil {
@@ -61,6 +202,4 @@ func main() {
 	}()
 
 	select {}
-
-	// ring.PrintBucketDistribution()
 }
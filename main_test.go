@@ -39,7 +39,10 @@ func Test10kRestApiPuts(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
@@ -80,7 +83,10 @@ func Test10kRestApiPuts10Vnode(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
@@ -121,7 +127,10 @@ func Test50kRestApiPuts10Vnode(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
@@ -161,7 +170,10 @@ func Test10kGrpcPuts(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
@@ -201,7 +213,10 @@ func Test10kGrpcPuts10Vnode(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
@@ -241,7 +256,10 @@ func Test50kGrpcPuts10Vnode(t *testing.T) {
 
 	components := server.CreateAndRunAllFromConfig(capacity, configPath, verbose)
 
-	c := client.InitClient(certdir, configPath, vNode)
+	c, err := client.InitClient(certdir, configPath, vNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 	c.StartClusterConfigWatcher()
 
 	var wg sync.WaitGroup
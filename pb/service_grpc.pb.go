@@ -26,16 +26,36 @@ type CacheServiceClient interface {
 	// Get/Put operations
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Replication
+	ReplicaGet(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ReplicaValue, error)
+	ReplicaPut(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	DeliverHints(ctx context.Context, in *HintBatch, opts ...grpc.CallOption) (*GenericResponse, error)
+	// Anti-entropy
+	GetMerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTree, error)
+	StreamRangeEntries(ctx context.Context, in *RangeEntriesRequest, opts ...grpc.CallOption) (CacheService_StreamRangeEntriesClient, error)
+	// Rebalancing
+	MigrateKeys(ctx context.Context, opts ...grpc.CallOption) (CacheService_MigrateKeysClient, error)
 	// Elections
 	GetPid(ctx context.Context, in *PidRequest, opts ...grpc.CallOption) (*PidResponse, error)
 	GetLeader(ctx context.Context, in *LeaderRequest, opts ...grpc.CallOption) (*LeaderResponse, error)
 	GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	UpdateLeader(ctx context.Context, in *NewLeaderAnnouncement, opts ...grpc.CallOption) (*GenericResponse, error)
 	RequestElection(ctx context.Context, in *ElectionRequest, opts ...grpc.CallOption) (*GenericResponse, error)
+	TransferLeadership(ctx context.Context, in *LeadershipTransfer, opts ...grpc.CallOption) (*GenericResponse, error)
 	// Cluster management
 	GetClusterConfig(ctx context.Context, in *ClusterConfigRequest, opts ...grpc.CallOption) (*ClusterConfig, error)
 	UpdateClusterConfig(ctx context.Context, in *ClusterConfig, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	RegisterNodeWithCluster(ctx context.Context, in *Node, opts ...grpc.CallOption) (*GenericResponse, error)
+	LeaveCluster(ctx context.Context, in *Node, opts ...grpc.CallOption) (*GenericResponse, error)
+	Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*GenericResponse, error)
+	WatchClusterConfig(ctx context.Context, in *ClusterConfigRequest, opts ...grpc.CallOption) (CacheService_WatchClusterConfigClient, error)
+	// Gossip membership
+	Ping(ctx context.Context, in *GossipPing, opts ...grpc.CallOption) (*GossipAck, error)
+	PingReq(ctx context.Context, in *GossipPing, opts ...grpc.CallOption) (*GossipAck, error)
+	// Raft elections and config log
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, in *AppendEntriesRequest, opts ...grpc.CallOption) (*AppendEntriesResponse, error)
+	TimeoutNow(ctx context.Context, in *TimeoutNowRequest, opts ...grpc.CallOption) (*TimeoutNowResponse, error)
 }
 
 type cacheServiceClient struct {
@@ -64,6 +84,108 @@ func (c *cacheServiceClient) Put(ctx context.Context, in *PutRequest, opts ...gr
 	return out, nil
 }
 
+func (c *cacheServiceClient) ReplicaGet(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ReplicaValue, error) {
+	out := new(ReplicaValue)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/ReplicaGet", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) ReplicaPut(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/ReplicaPut", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) DeliverHints(ctx context.Context, in *HintBatch, opts ...grpc.CallOption) (*GenericResponse, error) {
+	out := new(GenericResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/DeliverHints", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) GetMerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTree, error) {
+	out := new(MerkleTree)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/GetMerkleTree", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) StreamRangeEntries(ctx context.Context, in *RangeEntriesRequest, opts ...grpc.CallOption) (CacheService_StreamRangeEntriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CacheService_ServiceDesc.Streams[0], "/pb.CacheService/StreamRangeEntries", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cacheServiceStreamRangeEntriesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CacheService_StreamRangeEntriesClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type cacheServiceStreamRangeEntriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *cacheServiceStreamRangeEntriesClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *cacheServiceClient) MigrateKeys(ctx context.Context, opts ...grpc.CallOption) (CacheService_MigrateKeysClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CacheService_ServiceDesc.Streams[1], "/pb.CacheService/MigrateKeys", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cacheServiceMigrateKeysClient{stream}
+	return x, nil
+}
+
+type CacheService_MigrateKeysClient interface {
+	Send(*MigrationBatch) error
+	CloseAndRecv() (*GenericResponse, error)
+	grpc.ClientStream
+}
+
+type cacheServiceMigrateKeysClient struct {
+	grpc.ClientStream
+}
+
+func (x *cacheServiceMigrateKeysClient) Send(m *MigrationBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *cacheServiceMigrateKeysClient) CloseAndRecv() (*GenericResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(GenericResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *cacheServiceClient) GetPid(ctx context.Context, in *PidRequest, opts ...grpc.CallOption) (*PidResponse, error) {
 	out := new(PidResponse)
 	err := c.cc.Invoke(ctx, "/pb.CacheService/GetPid", in, out, opts...)
@@ -109,6 +231,15 @@ func (c *cacheServiceClient) RequestElection(ctx context.Context, in *ElectionRe
 	return out, nil
 }
 
+func (c *cacheServiceClient) TransferLeadership(ctx context.Context, in *LeadershipTransfer, opts ...grpc.CallOption) (*GenericResponse, error) {
+	out := new(GenericResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/TransferLeadership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *cacheServiceClient) GetClusterConfig(ctx context.Context, in *ClusterConfigRequest, opts ...grpc.CallOption) (*ClusterConfig, error) {
 	out := new(ClusterConfig)
 	err := c.cc.Invoke(ctx, "/pb.CacheService/GetClusterConfig", in, out, opts...)
@@ -136,6 +267,101 @@ func (c *cacheServiceClient) RegisterNodeWithCluster(ctx context.Context, in *No
 	return out, nil
 }
 
+func (c *cacheServiceClient) LeaveCluster(ctx context.Context, in *Node, opts ...grpc.CallOption) (*GenericResponse, error) {
+	out := new(GenericResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/LeaveCluster", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*GenericResponse, error) {
+	out := new(GenericResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/Decommission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) WatchClusterConfig(ctx context.Context, in *ClusterConfigRequest, opts ...grpc.CallOption) (CacheService_WatchClusterConfigClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CacheService_ServiceDesc.Streams[2], "/pb.CacheService/WatchClusterConfig", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cacheServiceWatchClusterConfigClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CacheService_WatchClusterConfigClient interface {
+	Recv() (*ClusterConfig, error)
+	grpc.ClientStream
+}
+
+type cacheServiceWatchClusterConfigClient struct {
+	grpc.ClientStream
+}
+
+func (x *cacheServiceWatchClusterConfigClient) Recv() (*ClusterConfig, error) {
+	m := new(ClusterConfig)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *cacheServiceClient) Ping(ctx context.Context, in *GossipPing, opts ...grpc.CallOption) (*GossipAck, error) {
+	out := new(GossipAck)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) PingReq(ctx context.Context, in *GossipPing, opts ...grpc.CallOption) (*GossipAck, error) {
+	out := new(GossipAck)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/PingReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error) {
+	out := new(VoteResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/RequestVote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) AppendEntries(ctx context.Context, in *AppendEntriesRequest, opts ...grpc.CallOption) (*AppendEntriesResponse, error) {
+	out := new(AppendEntriesResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/AppendEntries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) TimeoutNow(ctx context.Context, in *TimeoutNowRequest, opts ...grpc.CallOption) (*TimeoutNowResponse, error) {
+	out := new(TimeoutNowResponse)
+	err := c.cc.Invoke(ctx, "/pb.CacheService/TimeoutNow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CacheServiceServer is the server API for CacheService service.
 // All implementations must embed UnimplementedCacheServiceServer
 // for forward compatibility
@@ -143,16 +369,36 @@ type CacheServiceServer interface {
 	// Get/Put operations
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Put(context.Context, *PutRequest) (*emptypb.Empty, error)
+	// Replication
+	ReplicaGet(context.Context, *GetRequest) (*ReplicaValue, error)
+	ReplicaPut(context.Context, *PutRequest) (*emptypb.Empty, error)
+	DeliverHints(context.Context, *HintBatch) (*GenericResponse, error)
+	// Anti-entropy
+	GetMerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTree, error)
+	StreamRangeEntries(*RangeEntriesRequest, CacheService_StreamRangeEntriesServer) error
+	// Rebalancing
+	MigrateKeys(CacheService_MigrateKeysServer) error
 	// Elections
 	GetPid(context.Context, *PidRequest) (*PidResponse, error)
 	GetLeader(context.Context, *LeaderRequest) (*LeaderResponse, error)
 	GetStatus(context.Context, *StatusRequest) (*emptypb.Empty, error)
 	UpdateLeader(context.Context, *NewLeaderAnnouncement) (*GenericResponse, error)
 	RequestElection(context.Context, *ElectionRequest) (*GenericResponse, error)
+	TransferLeadership(context.Context, *LeadershipTransfer) (*GenericResponse, error)
 	// Cluster management
 	GetClusterConfig(context.Context, *ClusterConfigRequest) (*ClusterConfig, error)
 	UpdateClusterConfig(context.Context, *ClusterConfig) (*emptypb.Empty, error)
 	RegisterNodeWithCluster(context.Context, *Node) (*GenericResponse, error)
+	LeaveCluster(context.Context, *Node) (*GenericResponse, error)
+	Decommission(context.Context, *DecommissionRequest) (*GenericResponse, error)
+	WatchClusterConfig(*ClusterConfigRequest, CacheService_WatchClusterConfigServer) error
+	// Gossip membership
+	Ping(context.Context, *GossipPing) (*GossipAck, error)
+	PingReq(context.Context, *GossipPing) (*GossipAck, error)
+	// Raft elections and config log
+	RequestVote(context.Context, *VoteRequest) (*VoteResponse, error)
+	AppendEntries(context.Context, *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	TimeoutNow(context.Context, *TimeoutNowRequest) (*TimeoutNowResponse, error)
 	mustEmbedUnimplementedCacheServiceServer()
 }
 
@@ -166,6 +412,24 @@ func (UnimplementedCacheServiceServer) Get(context.Context, *GetRequest) (*GetRe
 func (UnimplementedCacheServiceServer) Put(context.Context, *PutRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
 }
+func (UnimplementedCacheServiceServer) ReplicaGet(context.Context, *GetRequest) (*ReplicaValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplicaGet not implemented")
+}
+func (UnimplementedCacheServiceServer) ReplicaPut(context.Context, *PutRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplicaPut not implemented")
+}
+func (UnimplementedCacheServiceServer) DeliverHints(context.Context, *HintBatch) (*GenericResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeliverHints not implemented")
+}
+func (UnimplementedCacheServiceServer) GetMerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTree, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMerkleTree not implemented")
+}
+func (UnimplementedCacheServiceServer) StreamRangeEntries(*RangeEntriesRequest, CacheService_StreamRangeEntriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamRangeEntries not implemented")
+}
+func (UnimplementedCacheServiceServer) MigrateKeys(CacheService_MigrateKeysServer) error {
+	return status.Errorf(codes.Unimplemented, "method MigrateKeys not implemented")
+}
 func (UnimplementedCacheServiceServer) GetPid(context.Context, *PidRequest) (*PidResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetPid not implemented")
 }
@@ -181,6 +445,9 @@ func (UnimplementedCacheServiceServer) UpdateLeader(context.Context, *NewLeaderA
 func (UnimplementedCacheServiceServer) RequestElection(context.Context, *ElectionRequest) (*GenericResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RequestElection not implemented")
 }
+func (UnimplementedCacheServiceServer) TransferLeadership(context.Context, *LeadershipTransfer) (*GenericResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
 func (UnimplementedCacheServiceServer) GetClusterConfig(context.Context, *ClusterConfigRequest) (*ClusterConfig, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetClusterConfig not implemented")
 }
@@ -190,6 +457,30 @@ func (UnimplementedCacheServiceServer) UpdateClusterConfig(context.Context, *Clu
 func (UnimplementedCacheServiceServer) RegisterNodeWithCluster(context.Context, *Node) (*GenericResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RegisterNodeWithCluster not implemented")
 }
+func (UnimplementedCacheServiceServer) LeaveCluster(context.Context, *Node) (*GenericResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveCluster not implemented")
+}
+func (UnimplementedCacheServiceServer) Decommission(context.Context, *DecommissionRequest) (*GenericResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decommission not implemented")
+}
+func (UnimplementedCacheServiceServer) WatchClusterConfig(*ClusterConfigRequest, CacheService_WatchClusterConfigServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchClusterConfig not implemented")
+}
+func (UnimplementedCacheServiceServer) Ping(context.Context, *GossipPing) (*GossipAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedCacheServiceServer) PingReq(context.Context, *GossipPing) (*GossipAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PingReq not implemented")
+}
+func (UnimplementedCacheServiceServer) RequestVote(context.Context, *VoteRequest) (*VoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedCacheServiceServer) AppendEntries(context.Context, *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEntries not implemented")
+}
+func (UnimplementedCacheServiceServer) TimeoutNow(context.Context, *TimeoutNowRequest) (*TimeoutNowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TimeoutNow not implemented")
+}
 func (UnimplementedCacheServiceServer) mustEmbedUnimplementedCacheServiceServer() {}
 
 // UnsafeCacheServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -239,6 +530,125 @@ func _CacheService_Put_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CacheService_ReplicaGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).ReplicaGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/ReplicaGet",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).ReplicaGet(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_ReplicaPut_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).ReplicaPut(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/ReplicaPut",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).ReplicaPut(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_DeliverHints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HintBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).DeliverHints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/DeliverHints",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).DeliverHints(ctx, req.(*HintBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_GetMerkleTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).GetMerkleTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/GetMerkleTree",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).GetMerkleTree(ctx, req.(*MerkleTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_StreamRangeEntries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RangeEntriesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CacheServiceServer).StreamRangeEntries(m, &cacheServiceStreamRangeEntriesServer{stream})
+}
+
+type CacheService_StreamRangeEntriesServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type cacheServiceStreamRangeEntriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *cacheServiceStreamRangeEntriesServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CacheService_MigrateKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CacheServiceServer).MigrateKeys(&cacheServiceMigrateKeysServer{stream})
+}
+
+type CacheService_MigrateKeysServer interface {
+	SendAndClose(*GenericResponse) error
+	Recv() (*MigrationBatch, error)
+	grpc.ServerStream
+}
+
+type cacheServiceMigrateKeysServer struct {
+	grpc.ServerStream
+}
+
+func (x *cacheServiceMigrateKeysServer) SendAndClose(m *GenericResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *cacheServiceMigrateKeysServer) Recv() (*MigrationBatch, error) {
+	m := new(MigrationBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _CacheService_GetPid_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PidRequest)
 	if err := dec(in); err != nil {
@@ -329,6 +739,24 @@ func _CacheService_RequestElection_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CacheService_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeadershipTransfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/TransferLeadership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).TransferLeadership(ctx, req.(*LeadershipTransfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _CacheService_GetClusterConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ClusterConfigRequest)
 	if err := dec(in); err != nil {
@@ -383,6 +811,153 @@ func _CacheService_RegisterNodeWithCluster_Handler(srv interface{}, ctx context.
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CacheService_LeaveCluster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Node)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).LeaveCluster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/LeaveCluster",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).LeaveCluster(ctx, req.(*Node))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_Decommission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecommissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).Decommission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/Decommission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).Decommission(ctx, req.(*DecommissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_WatchClusterConfig_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ClusterConfigRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CacheServiceServer).WatchClusterConfig(m, &cacheServiceWatchClusterConfigServer{stream})
+}
+
+type CacheService_WatchClusterConfigServer interface {
+	Send(*ClusterConfig) error
+	grpc.ServerStream
+}
+
+type cacheServiceWatchClusterConfigServer struct {
+	grpc.ServerStream
+}
+
+func (x *cacheServiceWatchClusterConfigServer) Send(m *ClusterConfig) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CacheService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GossipPing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).Ping(ctx, req.(*GossipPing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_PingReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GossipPing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).PingReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/PingReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).PingReq(ctx, req.(*GossipPing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/RequestVote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_AppendEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendEntriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).AppendEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/AppendEntries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).AppendEntries(ctx, req.(*AppendEntriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_TimeoutNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TimeoutNowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).TimeoutNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CacheService/TimeoutNow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).TimeoutNow(ctx, req.(*TimeoutNowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CacheService_ServiceDesc is the grpc.ServiceDesc for CacheService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -398,6 +973,22 @@ var CacheService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Put",
 			Handler:    _CacheService_Put_Handler,
 		},
+		{
+			MethodName: "ReplicaGet",
+			Handler:    _CacheService_ReplicaGet_Handler,
+		},
+		{
+			MethodName: "ReplicaPut",
+			Handler:    _CacheService_ReplicaPut_Handler,
+		},
+		{
+			MethodName: "DeliverHints",
+			Handler:    _CacheService_DeliverHints_Handler,
+		},
+		{
+			MethodName: "GetMerkleTree",
+			Handler:    _CacheService_GetMerkleTree_Handler,
+		},
 		{
 			MethodName: "GetPid",
 			Handler:    _CacheService_GetPid_Handler,
@@ -418,6 +1009,10 @@ var CacheService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RequestElection",
 			Handler:    _CacheService_RequestElection_Handler,
 		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _CacheService_TransferLeadership_Handler,
+		},
 		{
 			MethodName: "GetClusterConfig",
 			Handler:    _CacheService_GetClusterConfig_Handler,
@@ -430,6 +1025,244 @@ var CacheService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterNodeWithCluster",
 			Handler:    _CacheService_RegisterNodeWithCluster_Handler,
 		},
+		{
+			MethodName: "LeaveCluster",
+			Handler:    _CacheService_LeaveCluster_Handler,
+		},
+		{
+			MethodName: "Decommission",
+			Handler:    _CacheService_Decommission_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _CacheService_Ping_Handler,
+		},
+		{
+			MethodName: "PingReq",
+			Handler:    _CacheService_PingReq_Handler,
+		},
+		{
+			MethodName: "RequestVote",
+			Handler:    _CacheService_RequestVote_Handler,
+		},
+		{
+			MethodName: "AppendEntries",
+			Handler:    _CacheService_AppendEntries_Handler,
+		},
+		{
+			MethodName: "TimeoutNow",
+			Handler:    _CacheService_TimeoutNow_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRangeEntries",
+			Handler:       _CacheService_StreamRangeEntries_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "MigrateKeys",
+			Handler:       _CacheService_MigrateKeys_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchClusterConfig",
+			Handler:       _CacheService_WatchClusterConfig_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "service.proto",
+}
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminServiceClient interface {
+	AddNode(ctx context.Context, in *Node, opts ...grpc.CallOption) (*ClusterConfig, error)
+	RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*ClusterConfig, error)
+	SetMaintenance(ctx context.Context, in *MaintenanceRequest, opts ...grpc.CallOption) (*ClusterConfig, error)
+	TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*LeaderResponse, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) AddNode(ctx context.Context, in *Node, opts ...grpc.CallOption) (*ClusterConfig, error) {
+	out := new(ClusterConfig)
+	err := c.cc.Invoke(ctx, "/pb.AdminService/AddNode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*ClusterConfig, error) {
+	out := new(ClusterConfig)
+	err := c.cc.Invoke(ctx, "/pb.AdminService/RemoveNode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SetMaintenance(ctx context.Context, in *MaintenanceRequest, opts ...grpc.CallOption) (*ClusterConfig, error) {
+	out := new(ClusterConfig)
+	err := c.cc.Invoke(ctx, "/pb.AdminService/SetMaintenance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*LeaderResponse, error) {
+	out := new(LeaderResponse)
+	err := c.cc.Invoke(ctx, "/pb.AdminService/TransferLeadership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility
+type AdminServiceServer interface {
+	AddNode(context.Context, *Node) (*ClusterConfig, error)
+	RemoveNode(context.Context, *RemoveNodeRequest) (*ClusterConfig, error)
+	SetMaintenance(context.Context, *MaintenanceRequest) (*ClusterConfig, error)
+	TransferLeadership(context.Context, *TransferLeadershipRequest) (*LeaderResponse, error)
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServiceServer struct {
+}
+
+func (UnimplementedAdminServiceServer) AddNode(context.Context, *Node) (*ClusterConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddNode not implemented")
+}
+func (UnimplementedAdminServiceServer) RemoveNode(context.Context, *RemoveNodeRequest) (*ClusterConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveNode not implemented")
+}
+func (UnimplementedAdminServiceServer) SetMaintenance(context.Context, *MaintenanceRequest) (*ClusterConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMaintenance not implemented")
+}
+func (UnimplementedAdminServiceServer) TransferLeadership(context.Context, *TransferLeadershipRequest) (*LeaderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_AddNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Node)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).AddNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AdminService/AddNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).AddNode(ctx, req.(*Node))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_RemoveNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).RemoveNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AdminService/RemoveNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).RemoveNode(ctx, req.(*RemoveNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AdminService/SetMaintenance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetMaintenance(ctx, req.(*MaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AdminService/TransferLeadership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).TransferLeadership(ctx, req.(*TransferLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddNode",
+			Handler:    _AdminService_AddNode_Handler,
+		},
+		{
+			MethodName: "RemoveNode",
+			Handler:    _AdminService_RemoveNode_Handler,
+		},
+		{
+			MethodName: "SetMaintenance",
+			Handler:    _AdminService_SetMaintenance_Handler,
+		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _AdminService_TransferLeadership_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "service.proto",
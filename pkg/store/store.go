@@ -4,6 +4,7 @@ package store
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
 type Node struct {
@@ -11,6 +12,8 @@ type Node struct {
 	next *Node
 	key  string
 	val  string
+	// version of the value, a write timestamp in nanoseconds. Replicas keep the highest version.
+	version int64
 }
 
 type LRU struct {
@@ -23,39 +26,62 @@ type LRU struct {
 }
 
 func Init(capacity int) LRU {
-	lru := LRU{
+	head := &Node{prev: nil, next: nil, key: "", val: ""}
+	tail := &Node{prev: head, next: nil, key: "", val: ""}
+	head.next = tail
+	return LRU{
 		cache:    make(map[string]*Node, capacity),
-		head:     &Node{prev: nil, next: nil, key: "", val: ""},
-		tail:     &Node{prev: nil, next: nil, key: "", val: ""},
+		head:     head,
+		tail:     tail,
 		capacity: capacity,
 		size:     0,
 	}
-	lru.head.next = lru.tail
-	lru.tail.prev = lru.head
-	return lru
+}
+
+// New returns an empty cache with the given capacity, ready to be shared
+func New(capacity int) *LRU {
+	lru := Init(capacity)
+	return &lru
 }
 
 func (lru *LRU) Get(key string) (string, error) {
-	lru.mut.RLock()
-	defer lru.mut.RUnlock()
+	value, _, err := lru.GetVersioned(key)
+	return value, err
+}
+
+// GetVersioned returns the value of a key along with its version
+func (lru *LRU) GetVersioned(key string) (string, int64, error) {
+	// full lock since a hit moves the node to the head of the list
+	lru.mut.Lock()
+	defer lru.mut.Unlock()
 	if node, existed := lru.cache[key]; existed {
 		lru.moveToHead(node)
-		return node.val, nil
+		return node.val, node.version, nil
 	}
 
-	return "", errors.New("element not found")
+	return "", 0, errors.New("element not found")
 }
 
 func (lru *LRU) Put(key string, value string) {
+	lru.PutVersioned(key, value, time.Now().UnixNano())
+}
+
+// PutVersioned stores a value with the given version unless the key already
+// holds a newer one. Returns whether the value was stored.
+func (lru *LRU) PutVersioned(key string, value string, version int64) bool {
 	lru.mut.Lock()
 	defer lru.mut.Unlock()
 	if node, existed := lru.cache[key]; existed {
+		if node.version > version {
+			return false
+		}
 		node.val = value
+		node.version = version
 		lru.moveToHead(node)
-		return
+		return true
 	}
 
-	node := Node{prev: lru.head, next: lru.head.next, key: key, val: value}
+	node := Node{prev: lru.head, next: lru.head.next, key: key, val: value, version: version}
 
 	lru.cache[key] = &node
 
@@ -72,6 +98,43 @@ func (lru *LRU) Put(key string, value string) {
 		lru.evict()
 		lru.size -= 1
 	}
+	return true
+}
+
+// Entry is a key with its value and version
+type Entry struct {
+	Key     string
+	Value   string
+	Version int64
+}
+
+// Entries returns a copy of every entry without changing the recency order
+func (lru *LRU) Entries() []Entry {
+	lru.mut.RLock()
+	defer lru.mut.RUnlock()
+
+	entries := make([]Entry, 0, len(lru.cache))
+	for node := lru.head.next; node != lru.tail; node = node.next {
+		entries = append(entries, Entry{Key: node.key, Value: node.val, Version: node.version})
+	}
+	return entries
+}
+
+// DeleteVersioned removes a key unless it was overwritten with a version
+// newer than the given one. Returns whether the key was removed.
+func (lru *LRU) DeleteVersioned(key string, version int64) bool {
+	lru.mut.Lock()
+	defer lru.mut.Unlock()
+	node, existed := lru.cache[key]
+	if !existed || node.version > version {
+		return false
+	}
+
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	delete(lru.cache, key)
+	lru.size -= 1
+	return true
 }
 
 func (lru *LRU) moveToHead(node *Node) {
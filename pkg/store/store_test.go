@@ -86,3 +86,67 @@ func TestCacheWriteThroughput(t *testing.T) {
 	t.Logf("Time to write 10M puts: %.2f seconds", endSeconds)
 	t.Logf("LRU Cache write throughput: %.2f puts/second", float64(num_puts)/endSeconds)
 }
+
+func TestVersionedPut(t *testing.T) {
+	lru := Init(2)
+
+	if !lru.PutVersioned("1", "a", 10) {
+		t.Errorf("Expected first write to be stored")
+	}
+	if lru.PutVersioned("1", "b", 5) {
+		t.Errorf("Expected older write to be rejected")
+	}
+	value, version, err := lru.GetVersioned("1")
+	AssertEqualNoError(t, "a", value, err)
+	AssertEqualNoError(t, int64(10), version, err)
+
+	if !lru.PutVersioned("1", "c", 10) {
+		t.Errorf("Expected write with equal version to be stored")
+	}
+	actual, err := lru.Get("1")
+	AssertEqualNoError(t, "c", actual, err)
+
+	// plain puts are stamped with the current time so they win over old versions
+	lru.Put("1", "d")
+	value, version, err = lru.GetVersioned("1")
+	AssertEqualNoError(t, "d", value, err)
+	if version <= 10 {
+		t.Errorf("Expected put to stamp a newer version, got %d", version)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	lru := Init(2)
+	lru.PutVersioned("1", "a", 1)
+	lru.PutVersioned("2", "b", 2)
+	lru.PutVersioned("3", "c", 3)
+
+	entries := lru.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0] != (Entry{Key: "3", Value: "c", Version: 3}) || entries[1] != (Entry{Key: "2", Value: "b", Version: 2}) {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+}
+
+func TestDeleteVersioned(t *testing.T) {
+	lru := Init(2)
+	lru.PutVersioned("1", "a", 5)
+
+	if lru.DeleteVersioned("1", 4) {
+		t.Errorf("Expected delete of an older version to be ignored")
+	}
+	if !lru.DeleteVersioned("1", 5) {
+		t.Errorf("Expected delete of the stored version to succeed")
+	}
+	if _, err := lru.Get("1"); err == nil {
+		t.Errorf("Expected key 1 to be deleted")
+	}
+
+	lru.Put("2", "b")
+	lru.Put("3", "c")
+	if len(lru.Entries()) != 2 {
+		t.Errorf("Expected deleted key to free its slot, got %v", lru.Entries())
+	}
+}